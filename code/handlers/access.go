@@ -0,0 +1,400 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"start-feishubot/initialization"
+	"start-feishubot/logger"
+	"start-feishubot/services/access"
+
+	larkcard "github.com/larksuite/oapi-sdk-go/v3/card"
+	larkcore "github.com/larksuite/oapi-sdk-go/v3/core"
+	larkcontact "github.com/larksuite/oapi-sdk-go/v3/service/contact/v3"
+	larkim "github.com/larksuite/oapi-sdk-go/v3/service/im/v1"
+)
+
+// Features that can be restricted to some users, chats or departments
+const (
+	FeaturePic       = "Pic"       // image generation, variations and edits
+	FeatureRole      = "Role"      // changing the role or system prompt
+	FeatureBalance   = "Balance"   // querying the API balance
+	FeatureAIMode    = "AIMode"    // changing the AI mode
+	FeatureVoice     = "Voice"     // voice replies
+	FeatureKnowledge = "Knowledge" // adding documents to the knowledge base
+)
+
+var features = []string{FeaturePic, FeatureRole, FeatureBalance,
+	FeatureAIMode, FeatureVoice, FeatureKnowledge}
+
+// cardFeatures are the features card actions belong to
+var cardFeatures = map[CardKind]string{
+	PicModeChangeKind:    FeaturePic,
+	PicTextMoreKind:      FeaturePic,
+	PicVarMoreKind:       FeaturePic,
+	PicEditMoreKind:      FeaturePic,
+	RoleTagsChooseKind:   FeatureRole,
+	RoleChooseKind:       FeatureRole,
+	AIModeChooseKind:     FeatureAIMode,
+	VoiceReplyKind:       FeatureVoice,
+	KnowledgeBaseAddKind: FeatureKnowledge,
+}
+
+// departmentsTTL is how long the departments of a user are cached
+const departmentsTTL = 10 * time.Minute
+
+var departmentCache = struct {
+	sync.Mutex
+	entries map[string]departmentEntry
+}{entries: map[string]departmentEntry{}}
+
+type departmentEntry struct {
+	ids     []string
+	expires time.Time
+}
+
+// openAccessPolicy builds the access policy from the config, it fails
+// closed: a policy that cannot be read stops the bot
+func openAccessPolicy(config initialization.Config) *access.Policy {
+	features, err := access.ParseFeatures(config.AccessFeatures)
+	if err != nil {
+		logger.Fatalf("invalid ACCESS_FEATURES: %v", err)
+	}
+	policy, err := access.Open(access.Rules{
+		AllowUsers:       config.AccessAllowUsers,
+		DenyUsers:        config.AccessDenyUsers,
+		AllowChats:       config.AccessAllowChats,
+		DenyChats:        config.AccessDenyChats,
+		AllowDepartments: config.AccessAllowDepartments,
+		DenyDepartments:  config.AccessDenyDepartments,
+		Features:         features,
+		Admins:           config.BotAdmins,
+	}, config.AccessRulesFile)
+	if err != nil {
+		logger.Fatalf("open access rules failed: %v", err)
+	}
+	return policy
+}
+
+// subject describes the sender of a message or card action, departments
+// are only looked up when a rule names one
+func (m MessageHandler) subject(ctx context.Context, userId,
+	chatId string) access.Subject {
+	subject := access.Subject{UserID: userId, ChatID: chatId}
+	if userId != "" && m.access.NeedsDepartments() {
+		departments, err := userDepartments(ctx, userId)
+		if err != nil {
+			logger.Ctx(ctx).Warnf("get departments of %s failed: %v",
+				userId, err)
+		}
+		subject.Departments = departments
+	}
+	return subject
+}
+
+// userDepartments returns the open department ids of a user, it needs the
+// contact:user.department:readonly permission
+func userDepartments(ctx context.Context, openId string) (
+	departments []string, err error) {
+	departmentCache.Lock()
+	entry, ok := departmentCache.entries[openId]
+	departmentCache.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.ids, nil
+	}
+
+	ctx, done := larkCall(ctx, "get_user")
+	defer done(&err)
+	client := initialization.GetLarkClient()
+	resp, err := client.Contact.User.Get(ctx, larkcontact.NewGetUserReqBuilder().
+		UserId(openId).
+		UserIdType(larkcontact.UserIdTypeOpenId).
+		DepartmentIdType(larkcontact.DepartmentIdTypeOpenDepartmentId).
+		Build())
+	if err != nil {
+		return nil, err
+	}
+	if !resp.Success() {
+		return nil, errors.New(resp.Msg)
+	}
+	if resp.Data.User != nil {
+		departments = resp.Data.User.DepartmentIds
+	}
+	departmentCache.Lock()
+	departmentCache.entries[openId] = departmentEntry{ids: departments,
+		expires: time.Now().Add(departmentsTTL)}
+	departmentCache.Unlock()
+	return departments, nil
+}
+
+// permitted tells whether the sender may use feature, and tells them when
+// they may not
+func (a *ActionInfo) permitted(feature string) bool {
+	subject := a.handler.subject(*a.ctx, a.info.userId,
+		larkcore.StringValue(a.info.chatId))
+	if a.handler.access.Permitted(subject, feature) {
+		return true
+	}
+	logger.Ctx(*a.ctx).WithField("feature", feature).
+		Infof("feature denied to %s", a.info.userId)
+	replyMsg(*a.ctx, fmt.Sprintf("🤖️: You do not have permission to use %s, "+
+		"please ask a bot admin.", feature), a.info.msgId)
+	return false
+}
+
+type AccessAction struct { /* Allow and deny lists */
+}
+
+func (*AccessAction) Execute(a *ActionInfo) bool {
+	subject := a.handler.subject(*a.ctx, a.info.userId,
+		larkcore.StringValue(a.info.chatId))
+	if a.handler.access.Allowed(subject) {
+		return true
+	}
+	logger.Ctx(*a.ctx).Infof("access denied to %s", a.info.userId)
+	replyMsg(*a.ctx, "🤖️: You do not have access to this bot, "+
+		"please ask a bot admin.", a.info.msgId)
+	return false
+}
+
+const accessUsage = `🤖️: Access commands, for bot admins:
+/access - show the rules
+/access allow|deny user|chat|dept <id>... - add to a list
+/access remove allow|deny user|chat|dept <id>... - remove from a list
+/access grant|revoke <feature> <id>... - restrict a feature
+/access open <feature> - lift the restriction of a feature
+/access admin add|remove <open_id>...
+"this" stands for the current chat, mentioned users stand for their open id.
+Features: `
+
+// runAccess manages the access rules, the command is for bot admins
+func runAccess(a *ActionInfo, args commandArgs) {
+	reply, err := a.handler.manageAccess(a, args.fields)
+	if err != nil {
+		reply = fmt.Sprintf("🤖️: %v", err)
+	}
+	logger.Ctx(*a.ctx).Infof("access command by %s: /access %s",
+		a.info.userId, args.text)
+	replyMsg(*a.ctx, reply, a.info.msgId)
+}
+
+func (m MessageHandler) manageAccess(a *ActionInfo, args []string) (
+	string, error) {
+	usage := accessUsage + strings.Join(features, ", ")
+	if len(args) == 0 || args[0] == "show" {
+		return describeRules(m.access.Rules()), nil
+	}
+	ids := accessIds(a, args)
+	switch {
+	case (args[0] == "allow" || args[0] == "deny") && len(args) > 1:
+		list, err := accessList(args[0], args[1])
+		if err != nil {
+			return "", err
+		}
+		if targets := ids(2); len(targets) > 0 {
+			return "🤖️: Added.", m.access.Add(list, targets...)
+		}
+	case args[0] == "remove" && len(args) > 2:
+		list, err := accessList(args[1], args[2])
+		if err != nil {
+			return "", err
+		}
+		if targets := ids(3); len(targets) > 0 {
+			return "🤖️: Removed.", m.access.Remove(list, targets...)
+		}
+	case (args[0] == "grant" || args[0] == "revoke") && len(args) > 1:
+		feature, err := knownFeature(args[1])
+		if err != nil {
+			return "", err
+		}
+		targets := ids(2)
+		if len(targets) == 0 {
+			break
+		}
+		if args[0] == "grant" {
+			return fmt.Sprintf("🤖️: %s granted.", feature),
+				m.access.Grant(feature, targets...)
+		}
+		return fmt.Sprintf("🤖️: %s revoked.", feature),
+			m.access.Revoke(feature, targets...)
+	case args[0] == "open" && len(args) > 1:
+		feature, err := knownFeature(args[1])
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("🤖️: %s is open to everyone.", feature),
+			m.access.Unrestrict(feature)
+	case args[0] == "admin" && len(args) > 1:
+		targets := ids(2)
+		if len(targets) == 0 {
+			break
+		}
+		switch args[1] {
+		case "add":
+			return "🤖️: Admins added.", m.access.Add(access.Admins, targets...)
+		case "remove":
+			return "🤖️: Admins removed.",
+				m.access.Remove(access.Admins, targets...)
+		}
+	}
+	return usage, nil
+}
+
+// accessIds returns the ids given from position i on, "this" stands for
+// the current chat and the users mentioned besides the bot are added
+func accessIds(a *ActionInfo, args []string) func(i int) []string {
+	return func(i int) []string {
+		var ids []string
+		for _, arg := range args[i:] {
+			if arg == "this" {
+				arg = larkcore.StringValue(a.info.chatId)
+			}
+			ids = append(ids, arg)
+		}
+		for _, mention := range a.info.mention {
+			if mention.Id != nil && larkcore.StringValue(mention.Name) !=
+				a.handler.config.FeishuBotName {
+				ids = append(ids, larkcore.StringValue(mention.Id.OpenId))
+			}
+		}
+		return ids
+	}
+}
+
+func accessList(kind, target string) (string, error) {
+	if kind != "allow" && kind != "deny" {
+		return "", fmt.Errorf("expected allow or deny, got %q", kind)
+	}
+	switch target {
+	case "user", "users":
+		return kind + "_users", nil
+	case "chat", "chats":
+		return kind + "_chats", nil
+	case "dept", "department", "departments":
+		return kind + "_departments", nil
+	}
+	return "", fmt.Errorf("expected user, chat or dept, got %q", target)
+}
+
+func knownFeature(name string) (string, error) {
+	for _, feature := range features {
+		if strings.EqualFold(feature, name) {
+			return feature, nil
+		}
+	}
+	return "", fmt.Errorf("unknown feature %q, expected one of %s", name,
+		strings.Join(features, ", "))
+}
+
+func describeRules(rules access.Rules) string {
+	var sb strings.Builder
+	sb.WriteString("🤖️: Access rules")
+	line := func(label string, ids []string) {
+		if len(ids) > 0 {
+			sb.WriteString(fmt.Sprintf("\n%s: %s", label,
+				strings.Join(ids, ", ")))
+		}
+	}
+	line("Admins", rules.Admins)
+	line("Allowed users", rules.AllowUsers)
+	line("Denied users", rules.DenyUsers)
+	line("Allowed chats", rules.AllowChats)
+	line("Denied chats", rules.DenyChats)
+	line("Allowed departments", rules.AllowDepartments)
+	line("Denied departments", rules.DenyDepartments)
+	names := make([]string, 0, len(rules.Features))
+	for name := range rules.Features {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if len(rules.Features[name]) == 0 {
+			sb.WriteString(fmt.Sprintf("\nFeature %s: nobody", name))
+			continue
+		}
+		line("Feature "+name, rules.Features[name])
+	}
+	if sb.Len() == len("🤖️: Access rules") {
+		sb.WriteString("\nEveryone may use every feature.")
+	}
+	return sb.String()
+}
+
+// authorizeCard checks the user of a card action may use the bot and the
+// feature of the card, and tells them when they may not
+func (m MessageHandler) authorizeCard(ctx context.Context,
+	cardAction *larkcard.CardAction, kind CardKind) error {
+	// card actions do not tell the chat, the message they belong to does.
+	// Rules on chats cannot be checked without it, so the action is denied
+	chatId := ""
+	if m.access.NeedsChats() {
+		var err error
+		chatId, err = messageChatId(ctx, cardAction.OpenMessageID)
+		if err != nil {
+			logger.Ctx(ctx).Warnf("get chat of card failed: %v", err)
+			replyMsg(ctx, "🤖️: Could not check your access in this chat, "+
+				"please try again later.", &cardAction.OpenMessageID)
+			return fmt.Errorf("get chat of card failed: %w", err)
+		}
+	}
+	subject := m.subject(ctx, cardAction.OpenID, chatId)
+	var err error
+	if !m.access.Allowed(subject) {
+		err = errors.New("access denied")
+		replyMsg(ctx, "🤖️: You do not have access to this bot, "+
+			"please ask a bot admin.", &cardAction.OpenMessageID)
+	} else if feature, ok := cardFeatures[kind]; ok &&
+		!m.access.Permitted(subject, feature) {
+		err = fmt.Errorf("%s denied", feature)
+		replyMsg(ctx, fmt.Sprintf("🤖️: You do not have permission to use "+
+			"%s, please ask a bot admin.", feature), &cardAction.OpenMessageID)
+	}
+	if err != nil {
+		logger.Ctx(ctx).Infof("card %s by %s: %v", kind, cardAction.OpenID, err)
+	}
+	return err
+}
+
+// messageChatId returns the chat the message msgId was sent in
+func messageChatId(ctx context.Context, msgId string) (string, error) {
+	msg, err := getMessage(ctx, msgId)
+	if err != nil || msg == nil {
+		return "", err
+	}
+	return larkcore.StringValue(msg.ChatId), nil
+}
+
+// messageSender returns the open id of the user who sent msgId, empty
+// when an app sent it
+func messageSender(ctx context.Context, msgId string) (string, error) {
+	msg, err := getMessage(ctx, msgId)
+	if err != nil || msg == nil || msg.Sender == nil ||
+		larkcore.StringValue(msg.Sender.SenderType) != "user" {
+		return "", err
+	}
+	return larkcore.StringValue(msg.Sender.Id), nil
+}
+
+// getMessage returns the message msgId, nil when Lark has no such message
+func getMessage(ctx context.Context, msgId string) (_ *larkim.Message,
+	err error) {
+	ctx, done := larkCall(ctx, "get_message")
+	defer done(&err)
+	req := larkim.NewGetMessageReqBuilder().MessageId(msgId).Build()
+	resp, err := initialization.GetLarkClient().Im.Message.Get(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if !resp.Success() {
+		return nil, errors.New(resp.Msg)
+	}
+	if len(resp.Data.Items) == 0 {
+		return nil, nil
+	}
+	return resp.Data.Items[0], nil
+}
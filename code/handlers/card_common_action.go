@@ -5,6 +5,12 @@ import (
 	"encoding/json"
 	"fmt"
 	larkcard "github.com/larksuite/oapi-sdk-go/v3/card"
+	"start-feishubot/logger"
+	"start-feishubot/services/audit"
+	"start-feishubot/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type CardHandlerMeta func(cardMsg CardMsg, m MessageHandler) CardHandlerFunc
@@ -20,11 +26,17 @@ func NewCardHandler(m MessageHandler) CardHandlerFunc {
 		NewPicResolutionHandler,
 		NewVisionResolutionHandler,
 		NewPicTextMoreHandler,
+		NewPicEditMoreHandler,
+		NewPicVarMoreHandler,
 		NewPicModeChangeHandler,
 		NewRoleTagCardHandler,
 		NewRoleCardHandler,
 		NewAIModeCardHandler,
 		NewVisionModeChangeHandler,
+		NewStopGenerationHandler,
+		NewRegenerateHandler,
+		NewKnowledgeBaseAddHandler,
+		NewVoiceReplyHandler,
 	}
 
 	return func(ctx context.Context, cardAction *larkcard.CardAction) (interface{}, error) {
@@ -34,6 +46,20 @@ func NewCardHandler(m MessageHandler) CardHandlerFunc {
 		if err := json.Unmarshal(actionValueJson, &cardMsg); err != nil {
 			return nil, err
 		}
+		ctx = logger.WithSessionID(ctx, cardMsg.SessionId)
+		ctx, span := tracing.Start(ctx, "card "+string(cardMsg.Kind),
+			trace.WithAttributes(attribute.String("session.id", cardMsg.SessionId)))
+		defer span.End()
+		ctx = audit.WithScope(ctx, audit.Scope{
+			UserID:    cardAction.OpenID,
+			SessionID: cardMsg.SessionId,
+			MsgID:     cardAction.OpenMessageID,
+			Action:    "card " + string(cardMsg.Kind),
+		})
+		if err := m.authorizeCard(ctx, cardAction, cardMsg.Kind); err != nil {
+			auditCardAction(ctx, err)
+			return nil, nil
+		}
 		//pp.Println(cardMsg)
 		//logger.Debug("cardMsg ", cardMsg)
 		for _, handler := range handlers {
@@ -42,8 +68,20 @@ func NewCardHandler(m MessageHandler) CardHandlerFunc {
 			if err == ErrNextHandler {
 				continue
 			}
+			tracing.Fail(span, err)
+			auditCardAction(ctx, err)
 			return i, err
 		}
 		return nil, nil
 	}
 }
+
+// auditCardAction records a card action such as a regeneration, what it
+// sent to the providers is recorded by the calls themselves
+func auditCardAction(ctx context.Context, err error) {
+	record := audit.Record{}
+	if err != nil {
+		record.Error = err.Error()
+	}
+	audit.RecordAction(ctx, record)
+}
@@ -1,12 +1,15 @@
 package handlers
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	larkcore "github.com/larksuite/oapi-sdk-go/v3/core"
 	"start-feishubot/logger"
+	"strconv"
 
 	"start-feishubot/services"
+	"start-feishubot/services/openai"
 
 	larkcard "github.com/larksuite/oapi-sdk-go/v3/card"
 )
@@ -27,6 +30,10 @@ func NewPicResolutionHandler(cardMsg CardMsg, m MessageHandler) CardHandlerFunc
 			}
 			return nil, nil
 		}
+		if cardMsg.Kind == PicModelKind || cardMsg.Kind == PicQualityKind ||
+			cardMsg.Kind == PicCountKind || cardMsg.Kind == PicEnhanceKind {
+			return CommonProcessPicSetting(cardMsg, cardAction, m.sessionCache)
+		}
 		return nil, ErrNextHandler
 	}
 }
@@ -48,7 +55,31 @@ func NewPicTextMoreHandler(cardMsg CardMsg, m MessageHandler) CardHandlerFunc {
 	return func(ctx context.Context, cardAction *larkcard.CardAction) (interface{}, error) {
 		if cardMsg.Kind == PicTextMoreKind {
 			go func() {
-				m.CommonProcessPicMore(cardMsg)
+				m.CommonProcessPicMore(ctx, cardMsg)
+			}()
+			return nil, nil
+		}
+		return nil, ErrNextHandler
+	}
+}
+
+func NewPicVarMoreHandler(cardMsg CardMsg, m MessageHandler) CardHandlerFunc {
+	return func(ctx context.Context, cardAction *larkcard.CardAction) (interface{}, error) {
+		if cardMsg.Kind == PicVarMoreKind {
+			go func() {
+				m.CommonProcessPicVarMore(ctx, cardMsg)
+			}()
+			return nil, nil
+		}
+		return nil, ErrNextHandler
+	}
+}
+
+func NewPicEditMoreHandler(cardMsg CardMsg, m MessageHandler) CardHandlerFunc {
+	return func(ctx context.Context, cardAction *larkcard.CardAction) (interface{}, error) {
+		if cardMsg.Kind == PicEditMoreKind {
+			go func() {
+				m.CommonProcessPicEditMore(ctx, cardMsg)
 			}()
 			return nil, nil
 		}
@@ -90,16 +121,89 @@ func CommonProcessPicStyle(msg CardMsg,
 	return newCard, nil, true
 }
 
-func (m MessageHandler) CommonProcessPicMore(msg CardMsg) {
-	resolution := m.sessionCache.GetPicResolution(msg.SessionId)
-	style := m.sessionCache.GetPicStyle(msg.SessionId)
+// CommonProcessPicSetting stores the image model, quality or count chosen
+// in the picture settings card
+func CommonProcessPicSetting(msg CardMsg, cardAction *larkcard.CardAction,
+	cache services.SessionServiceCacheInterface) (interface{}, error) {
+	option := cardAction.Action.Option
+	var setting string
+	switch msg.Kind {
+	case PicModelKind:
+		cache.SetPicModel(msg.SessionId, option)
+		setting = "Image model"
+	case PicQualityKind:
+		cache.SetPicQuality(msg.SessionId, option)
+		setting = "Image quality"
+	case PicCountKind:
+		count, err := strconv.Atoi(option)
+		if err != nil {
+			return nil, err
+		}
+		cache.SetPicCount(msg.SessionId, count)
+		setting = "Images per prompt"
+	case PicEnhanceKind:
+		cache.SetPicEnhance(msg.SessionId, option == "on")
+		setting = "Prompt enhancement"
+	}
+
+	// Return a confirmation card
+	return newSendCard(
+		withHeader("🖼️ Picture Creation Mode", larkcard.TemplateBlue),
+		withMainMd(setting+" updated to **"+option+"**"),
+		withPicResolutionBtn(&msg.SessionId),
+		withNote("Settings a model does not support fall back to its defaults."),
+	)
+}
 
-	logger.Debugf("resolution: %v", resolution)
-	logger.Debug("msg: %v", msg)
+func (m MessageHandler) CommonProcessPicMore(ctx context.Context,
+	msg CardMsg) {
+	logger.Ctx(ctx).Debugf("msg: %v", logger.UserContent(msg))
 	question := msg.Value.(string)
-	bs64, _ := m.gpt.GenerateOneImage(question, resolution, style)
-	replayImageCardByBase64(context.Background(), bs64, &msg.MsgId,
-		&msg.SessionId, question)
+	if err := m.replyImages(ctx, &msg.SessionId,
+		&msg.MsgId, question); err != nil {
+		replyMsg(ctx, fmt.Sprintf(
+			"🤖️: Image generation failed, please try again later. Error message: %v", err), &msg.MsgId)
+	}
+}
+
+// CommonProcessPicVarMore makes a variation of a generated image
+func (m MessageHandler) CommonProcessPicVarMore(ctx context.Context,
+	msg CardMsg) {
+	imageKey, _ := msg.Value.(string)
+	data, err := downloadUploadedImage(ctx, imageKey)
+	var image []byte
+	if err == nil {
+		image, err = openai.ConvertToRGBA(bytes.NewReader(data))
+	}
+	if err == nil {
+		err = openai.VerifyPngs([][]byte{image})
+	}
+	var bs64 string
+	if err == nil {
+		bs64, err = m.gpt.WithContext(ctx).GenerateOneImageVariation(
+			bytes.NewReader(image), m.sessionCache.GetPicResolution(msg.SessionId))
+	}
+	if err != nil {
+		replyMsg(ctx, fmt.Sprintf(
+			"🤖️: Image variation failed, please try again later. Error message: %v", err), &msg.MsgId)
+		return
+	}
+	replayVariantImageByBase64(ctx, bs64, &msg.MsgId, &msg.SessionId)
+}
+
+func (m MessageHandler) CommonProcessPicEditMore(ctx context.Context,
+	msg CardMsg) {
+	prompt, _ := msg.Value.(string)
+	edit := imageEdit{
+		imageKey: msg.ImageKey,
+		maskKey:  msg.MaskKey,
+		prompt:   prompt,
+	}
+	if err := m.editImage(ctx, &msg.SessionId, &msg.MsgId,
+		edit); err != nil {
+		replyMsg(ctx, fmt.Sprintf(
+			"🤖️: Image editing failed, please try again later. Error message: %v", err), &msg.MsgId)
+	}
 }
 
 func CommonProcessPicModeChange(cardMsg CardMsg,
@@ -15,7 +15,7 @@ func NewRoleTagCardHandler(cardMsg CardMsg,
 	return func(ctx context.Context, cardAction *larkcard.CardAction) (interface{}, error) {
 
 		if cardMsg.Kind == RoleTagsChooseKind {
-			newCard, err, done := CommonProcessRoleTag(cardMsg, cardAction,
+			newCard, err, done := CommonProcessRoleTag(ctx, cardMsg, cardAction,
 				m.sessionCache)
 			if done {
 				return newCard, err
@@ -31,7 +31,7 @@ func NewRoleCardHandler(cardMsg CardMsg,
 	return func(ctx context.Context, cardAction *larkcard.CardAction) (interface{}, error) {
 
 		if cardMsg.Kind == RoleChooseKind {
-			newCard, err, done := CommonProcessRole(cardMsg, cardAction,
+			newCard, err, done := CommonProcessRole(ctx, cardMsg, cardAction,
 				m.sessionCache)
 			if done {
 				return newCard, err
@@ -42,7 +42,8 @@ func NewRoleCardHandler(cardMsg CardMsg,
 	}
 }
 
-func CommonProcessRoleTag(msg CardMsg, cardAction *larkcard.CardAction,
+func CommonProcessRoleTag(ctx context.Context, msg CardMsg,
+	cardAction *larkcard.CardAction,
 	cache services.SessionServiceCacheInterface) (interface{},
 	error, bool) {
 	option := cardAction.Action.Option
@@ -50,12 +51,13 @@ func CommonProcessRoleTag(msg CardMsg, cardAction *larkcard.CardAction,
 	//	&msg.MsgId)
 	roles := initialization.GetTitleListByTag(option)
 	//fmt.Printf("roles: %s", roles)
-	SendRoleListCard(context.Background(), &msg.SessionId,
+	SendRoleListCard(ctx, &msg.SessionId,
 		&msg.MsgId, option, *roles)
 	return nil, nil, true
 }
 
-func CommonProcessRole(msg CardMsg, cardAction *larkcard.CardAction,
+func CommonProcessRole(ctx context.Context, msg CardMsg,
+	cardAction *larkcard.CardAction,
 	cache services.SessionServiceCacheInterface) (interface{},
 	error, bool) {
 	option := cardAction.Action.Option
@@ -69,7 +71,7 @@ func CommonProcessRole(msg CardMsg, cardAction *larkcard.CardAction,
 	})
 	cache.SetMsg(msg.SessionId, systemMsg)
 	//pp.Println("systemMsg: ", systemMsg)
-	sendSystemInstructionCard(context.Background(), &msg.SessionId,
+	sendSystemInstructionCard(ctx, &msg.SessionId,
 		&msg.MsgId, contentByTitle)
 	//replyMsg(context.Background(), "已选择角色:"+contentByTitle,
 	//	&msg.MsgId)
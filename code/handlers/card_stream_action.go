@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"context"
+
+	"start-feishubot/logger"
+
+	larkcard "github.com/larksuite/oapi-sdk-go/v3/card"
+)
+
+func NewStopGenerationHandler(cardMsg CardMsg,
+	m MessageHandler) CardHandlerFunc {
+	return func(ctx context.Context, cardAction *larkcard.CardAction) (interface{}, error) {
+		if cardMsg.Kind == StopGenerationKind {
+			if !m.ownsTurn(ctx, cardAction, cardMsg.MsgId) {
+				return toast("Only the person who asked can stop this answer"), nil
+			}
+			// The stream patches the card with the partial answer itself
+			stopGeneration(cardMsg.MsgId)
+			return nil, nil
+		}
+		return nil, ErrNextHandler
+	}
+}
+
+func NewRegenerateHandler(cardMsg CardMsg,
+	m MessageHandler) CardHandlerFunc {
+	return func(ctx context.Context, cardAction *larkcard.CardAction) (interface{}, error) {
+		if cardMsg.Kind == RegenerateKind {
+			if !m.ownsTurn(ctx, cardAction, cardMsg.MsgId) {
+				return toast("Only the person who asked can regenerate this answer"), nil
+			}
+			go func() {
+				m.CommonProcessRegenerate(ctx, cardMsg)
+			}()
+			return nil, nil
+		}
+		return nil, ErrNextHandler
+	}
+}
+
+// ownsTurn tells whether the user of cardAction sent msgId, the question
+// a card answers. Other members of a group may not stop or regenerate it
+func (m MessageHandler) ownsTurn(ctx context.Context,
+	cardAction *larkcard.CardAction, msgId string) bool {
+	sender, err := messageSender(ctx, msgId)
+	if err != nil {
+		logger.Ctx(ctx).Warnf("get sender of %s failed: %v", msgId, err)
+		return false
+	}
+	return sender != "" && sender == cardAction.OpenID
+}
+
+// toast is the card action response showing content in a toast
+func toast(content string) map[string]interface{} {
+	return map[string]interface{}{
+		"toast": map[string]interface{}{"type": "info", "content": content},
+	}
+}
+
+// CommonProcessRegenerate asks the user message the card answered again,
+// with the history before it. The new answer replaces the old one and the
+// later turns of the topic are kept
+func (m MessageHandler) CommonProcessRegenerate(ctx context.Context,
+	cardMsg CardMsg) {
+	if !m.replyTurn(ctx, cardMsg.SessionId, cardMsg.MsgId) {
+		replyMsg(ctx, "🤖️: There is no answer to regenerate in this topic", &cardMsg.MsgId)
+	}
+}
+
+// replyTurn answers the user message asked in msgId with the history before
+// it, it returns false when the message is not in the session
+func (m MessageHandler) replyTurn(ctx context.Context, sessionId string,
+	msgId string) bool {
+	msg := m.sessionCache.TurnHistory(sessionId, msgId)
+	if msg == nil {
+		return false
+	}
+
+	if m.config.StreamMode {
+		m.replyStream(ctx, &sessionId, &msgId, msg)
+		return true
+	}
+	m.replyCompletions(ctx, &sessionId, &msgId, msg)
+	return true
+}
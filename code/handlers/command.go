@@ -0,0 +1,331 @@
+package handlers
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"start-feishubot/logger"
+	"start-feishubot/metrics"
+
+	larkcard "github.com/larksuite/oapi-sdk-go/v3/card"
+)
+
+// anyArgs lifts the limit on the number of arguments of a command
+const anyArgs = -1
+
+// command is typed as "/name args" or as one of its plain text aliases.
+// The help card is generated from the registered commands, in the order
+// they are registered
+type command struct {
+	name        string   // such as /picture
+	aliases     []string // plain text forms, matched case-insensitively
+	args        string   // usage of the arguments, empty when it takes none
+	minArgs     int
+	maxArgs     int    // anyArgs for no limit
+	title       string // heading on the help card
+	description string
+	feature     string // access feature needed, empty for everyone
+	admin       bool   // for bot admins only, hidden from others
+	openAIOnly  bool   // not available with Azure OpenAI
+	// button is shown next to the command on the help card, optional
+	button func(sessionId string) *larkcard.MessageCardEmbedButton
+	run    func(a *ActionInfo, args commandArgs)
+}
+
+// commandArgs are what follows the command name
+type commandArgs struct {
+	text   string // as typed, such as a role description
+	fields []string
+}
+
+// usage lists the ways to type c
+func (c *command) usage() string {
+	forms := make([]string, 0, len(c.aliases)+1)
+	for _, form := range append([]string{c.name}, c.aliases...) {
+		form = "*" + form + "*"
+		if c.args != "" {
+			form += " " + c.args
+		}
+		forms = append(forms, form)
+	}
+	return strings.Join(forms, " or ")
+}
+
+// available tells whether c can be used by the sender of a
+func (c *command) available(a *ActionInfo) bool {
+	if c.openAIOnly && a.handler.config.AzureOn {
+		return false
+	}
+	return !c.admin || a.handler.access.IsAdmin(a.info.userId)
+}
+
+type commandRegistry struct {
+	commands []*command
+	byName   map[string]*command
+}
+
+// commands are the commands of the bot, registered by init functions
+var commands = &commandRegistry{byName: map[string]*command{}}
+
+// register adds c, a name or alias taken twice is a programming error
+func (r *commandRegistry) register(c *command) {
+	for _, key := range append([]string{c.name}, c.aliases...) {
+		key = strings.ToLower(key)
+		if _, ok := r.byName[key]; ok {
+			panic(fmt.Sprintf("command %s registered twice", key))
+		}
+		r.byName[key] = c
+	}
+	r.commands = append(r.commands, c)
+}
+
+// lookup returns the command named or aliased name
+func (r *commandRegistry) lookup(name string) *command {
+	return r.byName[strings.ToLower(name)]
+}
+
+// commandName tells commands from other text starting with a slash, such
+// as paths
+var commandName = regexp.MustCompile(`^/[A-Za-z][A-Za-z0-9_]*$`)
+
+// parse returns the command text is and its arguments. For text that is
+// a slash command nobody registered, it returns a nil command and the
+// name typed
+func (r *commandRegistry) parse(text string) (*command, commandArgs,
+	string) {
+	text = strings.TrimSpace(text)
+	if strings.HasPrefix(text, "/") {
+		name, rest := text, ""
+		if i := strings.IndexAny(text, " \t\n"); i >= 0 {
+			name, rest = text[:i], strings.TrimSpace(text[i+1:])
+		}
+		if !commandName.MatchString(name) {
+			return nil, commandArgs{}, ""
+		}
+		c := r.lookup(name)
+		if c == nil {
+			return nil, commandArgs{}, name
+		}
+		return c, newCommandArgs(rest), name
+	}
+	if c := r.lookup(text); c != nil {
+		return c, commandArgs{}, c.name
+	}
+	// aliases of commands taking arguments are followed by them
+	for _, c := range r.commands {
+		if c.maxArgs == 0 {
+			continue
+		}
+		for _, alias := range c.aliases {
+			if len(text) > len(alias) &&
+				strings.EqualFold(text[:len(alias)], alias) &&
+				strings.ContainsRune(" \t\n", rune(text[len(alias)])) {
+				return c, newCommandArgs(strings.TrimSpace(
+					text[len(alias):])), c.name
+			}
+		}
+	}
+	return nil, commandArgs{}, ""
+}
+
+func newCommandArgs(text string) commandArgs {
+	return commandArgs{text: text, fields: strings.Fields(text)}
+}
+
+// suggest returns the commands of a named like name, closest first
+func (r *commandRegistry) suggest(a *ActionInfo, name string) []string {
+	name = strings.ToLower(name)
+	type candidate struct {
+		name     string
+		distance int
+	}
+	var candidates []candidate
+	for _, c := range r.commands {
+		if !c.available(a) {
+			continue
+		}
+		d := editDistance(name, strings.ToLower(c.name))
+		if d <= 2 || (len(name) > 2 && strings.HasPrefix(c.name, name)) {
+			candidates = append(candidates, candidate{c.name, d})
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].distance < candidates[j].distance
+	})
+	names := make([]string, 0, len(candidates))
+	for _, c := range candidates {
+		names = append(names, c.name)
+	}
+	return names
+}
+
+// editDistance is the Levenshtein distance between a and b
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur := make([]int, len(rb)+1)
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(rb)]
+}
+
+func minInt(values ...int) int {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+	return m
+}
+
+func init() {
+	for _, c := range []*command{
+		{name: "/clear", aliases: []string{"clear"},
+			title: "🆑 Clear Topic Context", run: clearTopic,
+			button: func(sessionId string) *larkcard.MessageCardEmbedButton {
+				return newBtn("Clear Now", map[string]interface{}{
+					"value":     "1",
+					"kind":      ClearCardKind,
+					"chatType":  UserChatType,
+					"sessionId": sessionId,
+				}, larkcard.MessageCardButtonTypeDanger)
+			}},
+		{name: "/ai_mode", aliases: []string{"ai mode"},
+			title: "🤖 Divergent Mode Selection", feature: FeatureAIMode,
+			run: listAIModes},
+		{name: "/roles", aliases: []string{"roles"},
+			title: "🛖 Built-in Role List", feature: FeatureRole,
+			run: listRoles},
+		{name: "/system", aliases: []string{"role play"},
+			args: "<role info>", minArgs: 1, maxArgs: anyArgs,
+			title: "🥷 Role-Playing Mode", feature: FeatureRole,
+			description: "to start a topic with the assistant in that role",
+			run:         playRole},
+		{name: "/voice", aliases: []string{"voice reply"},
+			title: "🔊 Voice Reply", feature: FeatureVoice, openAIOnly: true,
+			description: "to also get answers as voice messages",
+			run:         showVoiceReply},
+		{name: "/picture", aliases: []string{"picture", "picture creation"},
+			title: "🎨 Image Creation Mode", feature: FeaturePic,
+			openAIOnly: true, run: startPicMode},
+		{name: "/edit", aliases: []string{"image edit"},
+			title: "🖌️ Image Edit Mode", feature: FeaturePic, openAIOnly: true,
+			description: "then send an image, an optional mask and the " +
+				"change to make",
+			run: startPicEditMode},
+		{name: "/vision", aliases: []string{"vision", "image reasoning"},
+			title: "🕵️ Image Analysis Mode", openAIOnly: true,
+			description: "or send images directly in the chat when the " +
+				"model supports them",
+			run: startVisionMode},
+		{name: "/balance", aliases: []string{"balance"},
+			title: "🎰 Token Balance Query", feature: FeatureBalance,
+			run: showBalance},
+		{name: "/access", args: "[show|allow|deny|remove|grant|revoke|admin ...]",
+			maxArgs: anyArgs, admin: true, title: "🔐 Access Rules",
+			description: "alone to show the rules, with *help* for how to " +
+				"change them",
+			run: runAccess},
+		{name: "/help", aliases: []string{"help"},
+			title: "🎒 Need More Help?", run: showHelp},
+	} {
+		commands.register(c)
+	}
+}
+
+type CommandAction struct { /* Registered commands */
+}
+
+func (*CommandAction) Execute(a *ActionInfo) bool {
+	c, args, name := commands.parse(a.info.qParsed)
+	if c == nil {
+		if name == "" {
+			return true
+		}
+		metrics.Commands.WithLabelValues("unknown").Inc()
+		replyMsg(*a.ctx, unknownCommand(name,
+			commands.suggest(a, name)), a.info.msgId)
+		return false
+	}
+	return a.runCommand(c, args)
+}
+
+// runCommand checks the sender may run c with args before running it
+func (a *ActionInfo) runCommand(c *command, args commandArgs) bool {
+	metrics.Commands.WithLabelValues(c.name).Inc()
+	logger.Ctx(*a.ctx).WithField("command", c.name).Debug("command")
+	switch {
+	case c.admin && !a.handler.access.IsAdmin(a.info.userId):
+		replyMsg(*a.ctx, fmt.Sprintf("🤖️: Only bot admins can use %s.",
+			c.name), a.info.msgId)
+		return false
+	case c.openAIOnly && a.handler.config.AzureOn:
+		replyMsg(*a.ctx, fmt.Sprintf("🤖️: %s is not available with "+
+			"Azure OpenAI.", c.name), a.info.msgId)
+		return false
+	case len(args.fields) < c.minArgs ||
+		(c.maxArgs != anyArgs && len(args.fields) > c.maxArgs):
+		replyMsg(*a.ctx, "🤖️: Usage: "+c.usage(), a.info.msgId)
+		return false
+	case c.feature != "" && !a.permitted(c.feature):
+		return false
+	}
+	c.run(a, args)
+	return false
+}
+
+func unknownCommand(name string, suggestions []string) string {
+	msg := fmt.Sprintf("🤖️: Unknown command %s", name)
+	if len(suggestions) > 0 {
+		msg += ", did you mean " + strings.Join(suggestions, " or ") + "?"
+	} else {
+		msg += "."
+	}
+	return msg + " Send /help to see all commands."
+}
+
+// helpTips are described on the help card after the commands
+var helpTips = []string{
+	"🎤 **AI Voice Chat**\nDirectly send voice messages in private chat mode, or mention the bot in a reply to a voice message in a group with *transcribe* [language] [timestamps] or a question about it",
+	"📄 **Document Q&A**\nSend a PDF, DOCX, Markdown, TXT or CSV file in private chat, then reply in its topic with questions",
+	"🎰 **Continuous Dialogue & Multi-Topic Mode**\nClick the dialogue box to reply and maintain topic continuity. Meanwhile, ask separately to start a new topic",
+}
+
+// helpElements describe the commands available to the sender of a
+func helpElements(a *ActionInfo) []larkcard.MessageCardElement {
+	var elements []larkcard.MessageCardElement
+	for _, c := range commands.commands {
+		if !c.available(a) {
+			continue
+		}
+		text := fmt.Sprintf("**%s**\nReply with %s", c.title, c.usage())
+		if c.description != "" {
+			text += ", " + c.description
+		}
+		if c.button != nil {
+			elements = append(elements, withMdAndExtraBtn(text,
+				c.button(*a.info.sessionId)), withSplitLine())
+			continue
+		}
+		elements = append(elements, withMainMd(text), withSplitLine())
+	}
+	for _, tip := range helpTips {
+		elements = append(elements, withMainMd(tip), withSplitLine())
+	}
+	return elements[:len(elements)-1]
+}
@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCommandParse(t *testing.T) {
+	tests := []struct {
+		text     string
+		command  string
+		args     string
+		typedAs  string
+		matching bool
+	}{
+		{"/clear", "/clear", "", "/clear", true},
+		{"  CLEAR ", "/clear", "", "/clear", true},
+		{"Picture Creation", "/picture", "", "/picture", true},
+		{"/system you are a pirate", "/system", "you are a pirate", "/system", true},
+		{"Role play you are a pirate", "/system", "you are a pirate", "/system", true},
+		{"/ai_mode", "/ai_mode", "", "/ai_mode", true},
+		// plain text aliases of commands without arguments match whole
+		{"ai mode please", "", "", "", false},
+		{"help me write a poem", "", "", "", false},
+		{"/pictur", "", "", "/pictur", false},
+		{"/usr/bin/env is missing", "", "", "", false},
+		{"what is 1/2", "", "", "", false},
+	}
+	for _, tt := range tests {
+		c, args, name := commands.parse(tt.text)
+		if (c != nil) != tt.matching || (c != nil && c.name != tt.command) {
+			t.Errorf("parse(%q) command = %v, want %q", tt.text, c, tt.command)
+			continue
+		}
+		if args.text != tt.args || name != tt.typedAs {
+			t.Errorf("parse(%q) = %q, %q, want %q, %q", tt.text, args.text,
+				name, tt.args, tt.typedAs)
+		}
+	}
+}
+
+func TestCommandSuggest(t *testing.T) {
+	r := &commandRegistry{byName: map[string]*command{}}
+	for _, name := range []string{"/picture", "/edit", "/vision", "/voice"} {
+		r.register(&command{name: name})
+	}
+	a := &ActionInfo{handler: &MessageHandler{}, info: &MsgInfo{}}
+
+	tests := []struct {
+		name string
+		want []string
+	}{
+		{"/pictur", []string{"/picture"}},
+		{"/voise", []string{"/voice"}},
+		{"/vis", []string{"/vision"}},
+		{"/weather", []string{}},
+	}
+	for _, tt := range tests {
+		if got := r.suggest(a, tt.name); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("suggest(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCommandUsage(t *testing.T) {
+	c := commands.lookup("/system")
+	if got, want := c.usage(),
+		"*/system* <role info> or *role play* <role info>"; got != want {
+		t.Errorf("usage = %q, want %q", got, want)
+	}
+}
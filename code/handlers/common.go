@@ -36,8 +36,18 @@ func parsePostContent(content string) string {
 	contentList := contentMap["content"].([]interface{})
 	for _, v := range contentList {
 		for _, v1 := range v.([]interface{}) {
-			if v1.(map[string]interface{})["tag"] == "text" {
-				text += v1.(map[string]interface{})["text"].(string)
+			element := v1.(map[string]interface{})
+			switch element["tag"] {
+			case "text":
+				text += element["text"].(string)
+			case "a":
+				// keep the address so linked pages can be read
+				label, _ := element["text"].(string)
+				href, _ := element["href"].(string)
+				if label != "" && label != href {
+					text += label + " "
+				}
+				text += href
 			}
 		}
 		// add new line
@@ -148,6 +158,20 @@ func parseFileKey(content string) string {
 	return fileKey
 }
 
+func parseFileName(content string) string {
+	var contentMap map[string]interface{}
+	err := json.Unmarshal([]byte(content), &contentMap)
+	if err != nil {
+		fmt.Println(err)
+		return ""
+	}
+	if contentMap["file_name"] == nil {
+		return ""
+	}
+	fileName := contentMap["file_name"].(string)
+	return fileName
+}
+
 func parseImageKey(content string) string {
 	var contentMap map[string]interface{}
 	err := json.Unmarshal([]byte(content), &contentMap)
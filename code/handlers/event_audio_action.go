@@ -1,14 +1,11 @@
 package handlers
 
 import (
-	"context"
 	"fmt"
-	"os"
+	"strings"
 
 	"start-feishubot/initialization"
-	"start-feishubot/utils/audio"
-
-	larkim "github.com/larksuite/oapi-sdk-go/v3/service/im/v1"
+	"start-feishubot/logger"
 )
 
 type AudioAction struct { /*Audio*/
@@ -20,50 +17,72 @@ func (*AudioAction) Execute(a *ActionInfo) bool {
 		return true
 	}
 
-	// Only parse audio in private chats, ignore in other chats
-	if a.info.handlerType != UserHandler {
-		return true
-	}
-
 	//Check if it's audio
 	if a.info.msgType == "audio" {
-		fileKey := a.info.fileKey
-		//fmt.Printf("fileKey: %s \n", fileKey)
-		msgId := a.info.msgId
-		//fmt.Println("msgId: ", *msgId)
-		req := larkim.NewGetMessageResourceReqBuilder().MessageId(
-			*msgId).FileKey(fileKey).Type("file").Build()
-		resp, err := initialization.GetLarkClient().Im.MessageResource.Get(context.Background(), req)
-		//fmt.Println(resp, err)
-		if err != nil {
-			fmt.Println(err)
+		// Only parse audio messages in private chats, group chats
+		// transcribe audio the bot is mentioned in a reply to
+		if a.info.handlerType != UserHandler {
 			return true
 		}
-		f := fmt.Sprintf("%s.ogg", fileKey)
-		resp.WriteFile(f)
-		defer os.Remove(f)
-
-		//fmt.Println("f: ", f)
-		output := fmt.Sprintf("%s.mp3", fileKey)
-		// Wait for conversion to complete
-		audio.OggToWavByPath(f, output)
-		defer os.Remove(output)
-		//fmt.Println("output: ", output)
-
-		text, err := a.handler.gpt.AudioToText(output)
-		if err != nil {
-			fmt.Println(err)
-
-			sendMsg(*a.ctx, fmt.Sprintf("🤖️: Audio conversion failed, please try again later. Error message: %v", err), a.info.msgId)
+		t, ok := replyTranscript(a, a.info.fileKey, transcribeCommand{})
+		if !ok {
 			return false
 		}
+		a.info.qParsed = t.Text
+		return true
+	}
 
-		replyMsg(*a.ctx, fmt.Sprintf("🤖️：%s", text), a.info.msgId)
-		//fmt.Println("text: ", text)
-		a.info.qParsed = text
+	if a.info.parentId == "" || a.info.handlerType != GroupHandler {
+		return true
+	}
+	fileKey, err := repliedAudio(*a.ctx, a.info.parentId)
+	if err != nil {
+		logger.Ctx(*a.ctx).Warnf("get replied message failed: %v", err)
+		return true
+	}
+	if fileKey == "" {
 		return true
 	}
 
+	question := a.info.qParsed
+	cmd, isCommand := parseTranscribeCommand(question)
+	t, ok := replyTranscript(a, fileKey, cmd)
+	if !ok || isCommand {
+		return false
+	}
+	a.info.qParsed = fmt.Sprintf("%s\n\nTranscript:\n%s", question, t.Text)
 	return true
+}
+
+// replyTranscript transcribes the audio fileKey and replies with the text,
+// ok is false when transcription failed and the user has been told
+func replyTranscript(a *ActionInfo, fileKey string,
+	cmd transcribeCommand) (*transcript, bool) {
+	msgId := *a.info.msgId
+	if a.info.msgType != "audio" {
+		msgId = a.info.parentId
+	}
+	language := cmd.language
+	if language == "" {
+		language = initialization.GetConfig().AudioLanguage
+	}
 
+	t, err := a.handler.transcribe(*a.ctx, msgId, fileKey, language)
+	if err != nil {
+		logger.Ctx(*a.ctx).Warnf("transcribe audio failed: %v", err)
+		replyMsg(*a.ctx, fmt.Sprintf("🤖️: Audio conversion failed, please try again later. Error message: %v", err), a.info.msgId)
+		return nil, false
+	}
+	if strings.TrimSpace(t.Text) == "" {
+		replyMsg(*a.ctx, "🤖️: No speech was recognized in this audio", a.info.msgId)
+		return nil, false
+	}
+
+	replyMsg(*a.ctx, fmt.Sprintf("🤖️：%s", t.Text), a.info.msgId)
+	if cmd.timestamps || initialization.GetConfig().AudioTranscriptExport {
+		if err := exportTranscript(*a.ctx, a.info.msgId, fileKey, t); err != nil {
+			logger.Ctx(*a.ctx).Warnf("export transcript failed: %v", err)
+		}
+	}
+	return t, true
 }
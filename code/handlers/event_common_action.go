@@ -6,7 +6,6 @@ import (
 
 	"start-feishubot/initialization"
 	"start-feishubot/services/openai"
-	"start-feishubot/utils"
 
 	larkim "github.com/larksuite/oapi-sdk-go/v3/service/im/v1"
 )
@@ -15,11 +14,16 @@ type MsgInfo struct {
 	handlerType HandlerType
 	msgType     string
 	msgId       *string
+	parentId    string // message replied to
 	chatId      *string
+	userId      string // open id of the sender
 	qParsed     string
+	unredacted  string // qParsed before RedactAction, links are read from it
 	fileKey     string
+	fileName    string
 	imageKey    string
-	imageKeys   []string // Image group in post message card
+	imageKeys   []string          // Image group in post message card
+	images      []openai.ImageURL // Images attached to the chat message
 	sessionId   *string
 	mention     []*larkim.MentionEvent
 }
@@ -76,94 +80,40 @@ func (*EmptyAction) Execute(a *ActionInfo) bool {
 	return true
 }
 
-type ClearAction struct { /* Clear message */
+// clearTopic asks for confirmation before clearing the topic context
+func clearTopic(a *ActionInfo, _ commandArgs) {
+	sendClearCacheCheckCard(*a.ctx, a.info.sessionId, a.info.msgId)
 }
 
-func (*ClearAction) Execute(a *ActionInfo) bool {
-	if _, foundClear := utils.EitherTrimEqual(a.info.qParsed,
-		"/clear", "clear"); foundClear {
-		sendClearCacheCheckCard(*a.ctx, a.info.sessionId,
-			a.info.msgId)
-		return false
-	}
-	return true
-}
-
-type RolePlayAction struct { /* Role-playing */
-}
-
-func (*RolePlayAction) Execute(a *ActionInfo) bool {
-	if system, foundSystem := utils.EitherCutPrefix(a.info.qParsed,
-		"/system ", "role play "); foundSystem {
-		a.handler.sessionCache.Clear(*a.info.sessionId)
-		systemMsg := append([]openai.Messages{}, openai.Messages{
-			Role: "system", Content: system,
-		})
-		a.handler.sessionCache.SetMsg(*a.info.sessionId, systemMsg)
-		sendSystemInstructionCard(*a.ctx, a.info.sessionId,
-			a.info.msgId, system)
-		return false
-	}
-	return true
-}
-
-type HelpAction struct { /* Help */
-}
-
-func (*HelpAction) Execute(a *ActionInfo) bool {
-	if _, foundHelp := utils.EitherTrimEqual(a.info.qParsed, "/help",
-		"help"); foundHelp {
-		sendHelpCard(*a.ctx, a.info.sessionId, a.info.msgId)
-		return false
-	}
-	return true
+// playRole starts a new topic with args as the system message
+func playRole(a *ActionInfo, args commandArgs) {
+	a.handler.sessionCache.Clear(*a.info.sessionId)
+	systemMsg := append([]openai.Messages{}, openai.Messages{
+		Role: "system", Content: args.text,
+	})
+	a.handler.sessionCache.SetMsg(*a.info.sessionId, systemMsg)
+	sendSystemInstructionCard(*a.ctx, a.info.sessionId,
+		a.info.msgId, args.text)
 }
 
-type BalanceAction struct { /* Balance */
+func showHelp(a *ActionInfo, _ commandArgs) {
+	sendHelpCard(*a.ctx, a.info.msgId, helpElements(a))
 }
 
-func (*BalanceAction) Execute(a *ActionInfo) bool {
-	if _, foundBalance := utils.EitherTrimEqual(a.info.qParsed,
-		"/balance", "balance"); foundBalance {
-		balanceResp, err := a.handler.gpt.GetBalance()
-		if err != nil {
-			replyMsg(*a.ctx, "Failed to query balance, please try again later", a.info.msgId)
-			return false
-		}
-		sendBalanceCard(*a.ctx, a.info.sessionId, *balanceResp)
-		return false
+func showBalance(a *ActionInfo, _ commandArgs) {
+	balanceResp, err := a.handler.gpt.WithContext(*a.ctx).GetBalance()
+	if err != nil {
+		replyMsg(*a.ctx, "Failed to query balance, please try again later", a.info.msgId)
+		return
 	}
-	return true
+	sendBalanceCard(*a.ctx, a.info.sessionId, *balanceResp)
 }
 
-type RoleListAction struct { /* Role list */
+func listRoles(a *ActionInfo, _ commandArgs) {
+	tags := initialization.GetAllUniqueTags()
+	SendRoleTagsCard(*a.ctx, a.info.sessionId, a.info.msgId, *tags)
 }
 
-func (*RoleListAction) Execute(a *ActionInfo) bool {
-	if _, foundSystem := utils.EitherTrimEqual(a.info.qParsed,
-		"/roles", "roles"); foundSystem {
-		//a.handler.sessionCache.Clear(*a.info.sessionId)
-		//systemMsg := append([]openai.Messages{}, openai.Messages{
-		//	Role: "system", Content: system,
-		//})
-		//a.handler.sessionCache.SetMsg(*a.info.sessionId, systemMsg)
-		//sendSystemInstructionCard(*a.ctx, a.info.sessionId,
-		//	a.info.msgId, system)
-		tags := initialization.GetAllUniqueTags()
-		SendRoleTagsCard(*a.ctx, a.info.sessionId, a.info.msgId, *tags)
-		return false
-	}
-	return true
-}
-
-type AIModeAction struct { /* Divergent mode */
-}
-
-func (*AIModeAction) Execute(a *ActionInfo) bool {
-	if _, foundMode := utils.EitherCutPrefix(a.info.qParsed,
-		"/ai_mode", "ai mode"); foundMode {
-		SendAIModeListsCard(*a.ctx, a.info.sessionId, a.info.msgId, openai.AIModeStrs)
-		return false
-	}
-	return true
+func listAIModes(a *ActionInfo, _ commandArgs) {
+	SendAIModeListsCard(*a.ctx, a.info.sessionId, a.info.msgId, openai.AIModeStrs)
 }
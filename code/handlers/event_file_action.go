@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"start-feishubot/services"
+	"start-feishubot/services/openai"
+	"start-feishubot/utils/document"
+)
+
+const (
+	documentChunkBytes = 1500
+	// documents longer than this are cut, the card tells the user
+	maxDocumentChunks    = 200
+	documentPreviewRunes = 200
+)
+
+type FileAction struct { /*Documents*/
+}
+
+// Execute ingests PDF, DOCX, Markdown, TXT and CSV files into the session
+// so later questions in the topic are answered from them
+func (*FileAction) Execute(a *ActionInfo) bool {
+	if a.info.msgType != "file" {
+		return true
+	}
+
+	kind, err := document.KindOf(a.info.fileName)
+	if err != nil {
+		replyMsg(*a.ctx, "🤖️: Only PDF, DOCX, Markdown, TXT and CSV files are supported for now~", a.info.msgId)
+		return false
+	}
+
+	maxSize := int64(a.handler.config.DocumentMaxSizeMB) << 20
+	data, err := downloadResource(*a.ctx, *a.info.msgId, a.info.fileKey,
+		"file", maxSize)
+	if err != nil {
+		replyWithErrorMsg(*a.ctx, err, a.info.msgId)
+		return false
+	}
+
+	text, err := document.Extract(a.info.fileName, data)
+	if errors.Is(err, document.ErrNoText) {
+		replyMsg(*a.ctx, "🤖️: No text found in this file, scanned documents are not supported~", a.info.msgId)
+		return false
+	}
+	if err != nil {
+		replyWithErrorMsg(*a.ctx, err, a.info.msgId)
+		return false
+	}
+
+	chunks := document.Chunk(a.handler.redact(*a.info.sessionId, text),
+		documentChunkBytes)
+	truncated := len(chunks) > maxDocumentChunks
+	if truncated {
+		chunks = chunks[:maxDocumentChunks]
+	}
+	a.handler.sessionCache.AddDocument(*a.info.sessionId, services.Document{
+		Name: a.info.fileName, Chunks: chunks,
+	})
+	sendDocumentCard(*a.ctx, a.info.sessionId, a.info.msgId, a.info.fileName,
+		describeDocument(kind, len(data), text, len(chunks)),
+		preview(text), truncated, a.handler.knowledge != nil)
+	return false
+}
+
+func describeDocument(kind document.Kind, size int, text string,
+	chunks int) string {
+	return fmt.Sprintf("%s · %s · %d characters · %d chunks", kind,
+		formatSize(size), utf8.RuneCountInString(text), chunks)
+}
+
+func formatSize(size int) string {
+	switch {
+	case size >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(size)/(1<<20))
+	case size >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(size)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", size)
+}
+
+func preview(text string) string {
+	runes := []rune(text)
+	if len(runes) <= documentPreviewRunes {
+		return text
+	}
+	return string(runes[:documentPreviewRunes]) + "..."
+}
+
+// withDocuments adds the excerpts of the session documents most relevant
+// to the last question, the excerpts are not kept in the history
+func (m MessageHandler) withDocuments(sessionId string,
+	msg []openai.Messages) []openai.Messages {
+	documents := m.sessionCache.GetDocuments(sessionId)
+	if len(documents) == 0 || len(msg) == 0 {
+		return msg
+	}
+
+	var sources, chunks []string
+	for _, doc := range documents {
+		for i, chunk := range doc.Chunks {
+			sources = append(sources, fmt.Sprintf("%s, part %d", doc.Name, i+1))
+			chunks = append(chunks, chunk)
+		}
+	}
+	// matching chunks come first, the budget left is filled in document
+	// order since questions like "summarize it" share few terms with the text
+	ranked := document.Rank(msg[len(msg)-1].Content, chunks)
+	picked := map[int]bool{}
+	for _, i := range ranked {
+		picked[i] = true
+	}
+	for i := range chunks {
+		if !picked[i] {
+			ranked = append(ranked, i)
+		}
+	}
+
+	var excerpts strings.Builder
+	excerpts.WriteString("Answer using the following excerpts from the " +
+		"documents the user shared, and say so when they do not " +
+		"contain the answer. Each excerpt starts with its source in " +
+		"brackets.\n")
+	budget := m.config.DocumentContextTokens
+	for _, i := range ranked {
+		excerpt := fmt.Sprintf("\n[Source: %s]\n%s\n", sources[i], chunks[i])
+		tokens := (&openai.Messages{Content: excerpt}).CalculateTokenLength()
+		if tokens > budget {
+			break
+		}
+		budget -= tokens
+		excerpts.WriteString(excerpt)
+	}
+
+	request := append([]openai.Messages{}, msg[:len(msg)-1]...)
+	request = append(request, openai.Messages{
+		Role: "system", Content: excerpts.String(),
+	}, msg[len(msg)-1])
+	return request
+}
@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"fmt"
+
+	"start-feishubot/initialization"
+	"start-feishubot/services"
+	"start-feishubot/services/larkdoc"
+	"start-feishubot/utils/document"
+)
+
+// linked documents read from a single message
+const maxLinkedDocuments = 3
+
+var larkDocLabels = map[larkdoc.Kind]string{
+	larkdoc.KindDocx:  "Lark Doc",
+	larkdoc.KindDoc:   "Lark Doc",
+	larkdoc.KindSheet: "Lark Sheet",
+	larkdoc.KindWiki:  "Lark Wiki",
+}
+
+type LarkDocAction struct { /*Lark Docs links*/
+}
+
+// Execute reads the Lark Docs, Wiki pages and Sheets linked in the message
+// into the session documents, so the question and later ones in the topic
+// are answered from their content
+func (*LarkDocAction) Execute(a *ActionInfo) bool {
+	if a.info.msgType != "text" && a.info.msgType != "post" {
+		return true
+	}
+	mode := a.handler.sessionCache.GetMode(*a.info.sessionId)
+	if mode == services.ModePicCreate || mode == services.ModePicEdit ||
+		mode == services.ModeVision {
+		return true
+	}
+	links := larkdoc.FindLinks(a.info.unredacted)
+	if len(links) == 0 {
+		return true
+	}
+	if len(links) > maxLinkedDocuments {
+		links = links[:maxLinkedDocuments]
+	}
+
+	fetcher := larkdoc.NewFetcher(initialization.GetLarkClient())
+	for _, link := range links {
+		doc, err := fetcher.Fetch(*a.ctx, link)
+		if err != nil {
+			linkFailed(a, link.URL, fmt.Sprintf("🤖️: Could not read %s, please make sure the bot has access to it. Error message: %v", link.URL, err), err)
+			continue
+		}
+		chunks := document.Chunk(a.handler.redact(*a.info.sessionId,
+			doc.Content), documentChunkBytes)
+		if len(chunks) == 0 {
+			continue
+		}
+		if len(chunks) > maxDocumentChunks {
+			chunks = chunks[:maxDocumentChunks]
+		}
+		title := doc.Title
+		if title == "" {
+			title = link.URL
+		}
+		a.handler.sessionCache.AddDocument(*a.info.sessionId, services.Document{
+			Name: a.handler.redact(*a.info.sessionId, fmt.Sprintf("%s \"%s\" %s",
+				larkDocLabels[doc.Kind], title, link.URL)),
+			Chunks: chunks,
+		})
+	}
+	return true
+}
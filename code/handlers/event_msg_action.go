@@ -1,12 +1,11 @@
 package handlers
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
-	"log"
-	"strings"
 	"time"
 
+	"start-feishubot/logger"
 	"start-feishubot/services/openai"
 )
 
@@ -52,32 +51,52 @@ func (*MessageAction) Execute(a *ActionInfo) bool {
 	// If there is no prompt, default to simulating ChatGPT
 	msg = setDefaultPrompt(msg)
 	msg = append(msg, openai.Messages{
-		Role: "user", Content: a.info.qParsed,
+		Role: "user", Content: a.info.qParsed, Images: a.info.images,
+		MsgId: *a.info.msgId,
 	})
 
+	a.handler.replyCompletions(*a.ctx, a.info.sessionId, a.info.msgId, msg)
+	return false
+}
+
+// replyCompletions answers the last user message of msg with a topic card
+// and stores the conversation
+func (m MessageHandler) replyCompletions(ctx context.Context,
+	sessionId *string, msgId *string, msg []openai.Messages) {
 	// get ai mode as temperature
-	aiMode := a.handler.sessionCache.GetAIMode(*a.info.sessionId)
-	fmt.Println("msg: ", msg)
-	fmt.Println("aiMode: ", aiMode)
-	completions, err := a.handler.gpt.Completions(msg, aiMode)
+	aiMode := m.sessionCache.GetAIMode(*sessionId)
+	logger.Ctx(ctx).WithField("ai_mode", aiMode).
+		Debugf("completion request: %v", logger.UserContent(msg))
+	request, sources := m.withKnowledge(m.withDocuments(*sessionId, msg))
+	completions, err := m.gpt.WithContext(ctx).Completions(request, aiMode)
 	if err != nil {
-		replyMsg(*a.ctx, fmt.Sprintf(
-			"🤖️: The message bot encountered an error, please try again later. Error info: %v", err), a.info.msgId)
-		return false
+		replyMsg(ctx, fmt.Sprintf(
+			"🤖️: The message bot encountered an error, please try again later. Error info: %v", err), msgId)
+		return
 	}
+	if !m.moderate(ctx, stageOutput, completions.Content, msgId) {
+		return
+	}
+	m.sessionCache.SaveAnswer(*sessionId, msg, completions)
 	msg = append(msg, completions)
-	a.handler.sessionCache.SetMsg(*a.info.sessionId, msg)
+	m.replyCache.SetSession(*msgId, *sessionId)
+	// the history keeps the placeholders, the user sees the values
+	answer := m.restore(*sessionId, completions.Content)
+	var replyId *string
 	// if new topic (system + user + assistant = 3 messages)
 	if len(msg) == 3 {
 		//fmt.Println("new topic", msg[1].Content)
-		sendNewTopicCard(*a.ctx, a.info.sessionId, a.info.msgId,
-			completions.Content)
+		replyId, err = sendNewTopicCard(ctx, sessionId, msgId,
+			answer, sources)
 	} else {
 		// old topic with conversation history
-		sendOldTopicCard(*a.ctx, a.info.sessionId, a.info.msgId,
-			completions.Content)
+		replyId, err = sendOldTopicCard(ctx, sessionId, msgId,
+			answer, sources)
 	}
-	return false
+	if err == nil {
+		m.replyCache.AddReply(*msgId, *replyId)
+	}
+	m.replyVoice(ctx, sessionId, msgId, answer)
 }
 
 // Check if msg contains system role
@@ -101,8 +120,17 @@ func (m *StreamMessageAction) Execute(a *ActionInfo) bool {
 	// If there is no prompt, default to simulating ChatGPT
 	msg = setDefaultPrompt(msg)
 	msg = append(msg, openai.Messages{
-		Role: "user", Content: a.info.qParsed,
+		Role: "user", Content: a.info.qParsed, Images: a.info.images,
+		MsgId: *a.info.msgId,
 	})
+	a.handler.replyStream(*a.ctx, a.info.sessionId, a.info.msgId, msg)
+	return false
+}
+
+// replyStream streams the answer to the last user message of msg into a
+// topic card and stores the conversation
+func (m MessageHandler) replyStream(ctx context.Context,
+	sessionId *string, msgId *string, msg []openai.Messages) {
 	// if new topic
 	var ifNewTopic bool
 	if len(msg) <= 3 {
@@ -111,109 +139,43 @@ func (m *StreamMessageAction) Execute(a *ActionInfo) bool {
 		ifNewTopic = false
 	}
 
-	cardId, err2 := sendOnProcess(a, ifNewTopic)
-	if err2 != nil {
-		return false
-	}
-
-	answer := ""
-	chatResponseStream := make(chan string)
-	done := make(chan struct{}) // 添加 done 信号，保证 goroutine 正确退出
-	noContentTimeout := time.AfterFunc(10*time.Second, func() {
-		log.Println("no content timeout")
-		close(done)
-		err := updateFinalCard(*a.ctx, "Request timeout", cardId, ifNewTopic)
-		if err != nil {
-			return
-		}
+	card, err := newStreamCard(ctx, sessionId, msgId,
+		topicCardTitle(ifNewTopic))
+	if err != nil {
 		return
-	})
-	defer noContentTimeout.Stop()
-
-	go func() {
-		defer func() {
-			if err := recover(); err != nil {
-				err := updateFinalCard(*a.ctx, "Chat failed", cardId, ifNewTopic)
-				if err != nil {
-					return
-				}
-			}
-		}()
-
-		//log.Printf("UserId: %s , Request: %s", a.info.userId, msg)
-		aiMode := a.handler.sessionCache.GetAIMode(*a.info.sessionId)
-		//fmt.Println("msg: ", msg)
-		//fmt.Println("aiMode: ", aiMode)
-		if err := a.handler.gpt.StreamChat(*a.ctx, msg, aiMode,
-			chatResponseStream); err != nil {
-			err := updateFinalCard(*a.ctx, "Chat failed", cardId, ifNewTopic)
-			if err != nil {
-				return
-			}
-			close(done) // 关闭 done 信号
-		}
-
-		close(done) // 关闭 done 信号
-	}()
-	ticker := time.NewTicker(700 * time.Millisecond)
-	defer ticker.Stop() // 注意在函数结束时停止 ticker
-	go func() {
-		for {
-			select {
-			case <-done:
-				return
-			case <-ticker.C:
-				err := updateTextCard(*a.ctx, answer, cardId, ifNewTopic)
-				if err != nil {
-					return
-				}
-			}
+	}
+	card.regenerable = true
+	// a flagged answer must not be seen, not even while it streams
+	card.hold = m.moderation != nil && m.moderation.Output()
+	request, sources := m.withKnowledge(m.withDocuments(*sessionId, msg))
+	card.sources = sources
+	card.display = func(text string) string {
+		return m.restore(*sessionId, text)
+	}
+	m.replyCache.SetSession(*msgId, *sessionId)
+	defer func() {
+		for _, cardId := range card.cardIds {
+			m.replyCache.AddReply(*msgId, cardId)
 		}
 	}()
-	for {
-		select {
-		case res, ok := <-chatResponseStream:
-			if !ok {
-				return false
-			}
-			noContentTimeout.Stop()
-			answer += res
-			//pp.Println("answer", answer)
-		case <-done: // 添加 done 信号的处理
-			ticker.Stop()
-			// Save message to cache FIRST, before updating card
-			// This ensures conversation history is preserved even if card update fails
-			msg := append(msg, openai.Messages{
-				Role: "assistant", Content: answer,
-			})
-			a.handler.sessionCache.SetMsg(*a.info.sessionId, msg)
-
-			// Update card after saving (non-critical operation)
-			err := updateFinalCard(*a.ctx, answer, cardId, ifNewTopic)
-			if err != nil {
-				return false
-			}
-			close(chatResponseStream)
-			log.Printf("\n\n\n")
-			jsonByteArray, err := json.Marshal(msg)
-			if err != nil {
-				log.Println(err)
-			}
-			jsonStr := strings.ReplaceAll(string(jsonByteArray), "\\n", "")
-			jsonStr = strings.ReplaceAll(jsonStr, "\n", "")
-			log.Printf("\n\n\n")
-			return false
-		}
-	}
-}
 
-func sendOnProcess(a *ActionInfo, ifNewTopic bool) (*string, error) {
-	// send processing
-	cardId, err := sendOnProcessCard(*a.ctx, a.info.sessionId,
-		a.info.msgId, ifNewTopic)
+	//log.Printf("UserId: %s , Request: %s", a.info.userId, msg)
+	aiMode := m.sessionCache.GetAIMode(*sessionId)
+	answer, err := card.Stream(func(ctx context.Context,
+		responseStream chan string) error {
+		return m.gpt.StreamChat(ctx, request, aiMode, responseStream)
+	})
 	if err != nil {
-		return nil, err
+		logger.Ctx(ctx).Errorf("stream chat failed: %v", err)
+		return
 	}
-	return cardId, nil
-
+	if !m.moderate(ctx, stageOutput, answer, msgId) {
+		card.withhold(withheldNotice)
+		return
+	}
+	card.release()
+	m.sessionCache.SaveAnswer(*sessionId, msg, openai.Messages{
+		Role: "assistant", Content: answer,
+	})
+	m.replyVoice(ctx, sessionId, msgId, m.restore(*sessionId, answer))
 }
@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"start-feishubot/services"
+	"start-feishubot/services/openai"
+)
+
+const defaultImagePrompt = "Explain this image"
+
+type MultimodalAction struct { /*Images in regular chat*/
+}
+
+// Execute attaches the images of image and post messages to the regular
+// chat when the model accepts them, text-only models keep the vision mode
+// switch handled by VisionAction
+func (*MultimodalAction) Execute(a *ActionInfo) bool {
+	if !a.handler.gpt.Multimodal {
+		return true
+	}
+
+	mode := a.handler.sessionCache.GetMode(*a.info.sessionId)
+	if mode == services.ModePicCreate || mode == services.ModePicEdit ||
+		mode == services.ModeVision {
+		return true
+	}
+
+	var imageKeys []string
+	switch a.info.msgType {
+	case "image":
+		imageKeys = []string{a.info.imageKey}
+	case "post":
+		imageKeys = a.info.imageKeys
+	default:
+		return true
+	}
+
+	detail := a.handler.sessionCache.GetVisionDetail(*a.info.sessionId)
+	if detail == "" {
+		detail = string(services.VisionDetailAuto)
+	}
+	for _, imageKey := range imageKeys {
+		if imageKey == "" {
+			continue
+		}
+		url, err := downloadImageDataURL(*a.ctx, a.info.msgId, imageKey)
+		if err != nil {
+			replyWithErrorMsg(*a.ctx, err, a.info.msgId)
+			return false
+		}
+		a.info.images = append(a.info.images, openai.ImageURL{
+			URL:    url,
+			Detail: detail,
+		})
+	}
+
+	if len(a.info.images) > 0 && a.info.qParsed == "" {
+		a.info.qParsed = defaultImagePrompt
+	}
+	return true
+}
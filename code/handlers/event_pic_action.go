@@ -1,83 +1,71 @@
 package handlers
 
 import (
+	"bytes"
 	"context"
 	"fmt"
-	"os"
 	"start-feishubot/logger"
 
-	"start-feishubot/initialization"
 	"start-feishubot/services"
 	"start-feishubot/services/openai"
-	"start-feishubot/utils"
-
-	larkim "github.com/larksuite/oapi-sdk-go/v3/service/im/v1"
 )
 
 type PicAction struct { /*Picture*/
 }
 
+// startPicMode switches the session to picture creation
+func startPicMode(a *ActionInfo, _ commandArgs) {
+	a.handler.sessionCache.Clear(*a.info.sessionId)
+	a.handler.sessionCache.SetMode(*a.info.sessionId,
+		services.ModePicCreate)
+	a.handler.sessionCache.SetPicResolution(*a.info.sessionId,
+		services.Resolution1024)
+	sendPicCreateInstructionCard(*a.ctx, a.info.sessionId,
+		a.info.msgId)
+}
+
 func (*PicAction) Execute(a *ActionInfo) bool {
 	check := AzureModeCheck(a)
 	if !check {
 		return true
 	}
-	// Enable picture creation mode
-	if _, foundPic := utils.EitherTrimEqual(a.info.qParsed,
-		"/picture", "Picture Creation"); foundPic {
-		a.handler.sessionCache.Clear(*a.info.sessionId)
-		a.handler.sessionCache.SetMode(*a.info.sessionId,
-			services.ModePicCreate)
-		a.handler.sessionCache.SetPicResolution(*a.info.sessionId,
-			services.Resolution1024)
-		sendPicCreateInstructionCard(*a.ctx, a.info.sessionId,
-			a.info.msgId)
-		return false
-	}
-
 	mode := a.handler.sessionCache.GetMode(*a.info.sessionId)
 	//fmt.Println("mode: ", mode)
 	logger.Debug("MODE:", mode)
+	if mode == services.ModePicCreate && !a.permitted(FeaturePic) {
+		return false
+	}
 	// Received an image, and not in picture creation mode, prompt whether to switch to picture creation mode
 	if a.info.msgType == "image" && mode != services.ModePicCreate {
+		if len(a.info.images) > 0 {
+			return true
+		}
 		sendPicModeCheckCard(*a.ctx, a.info.sessionId, a.info.msgId)
 		return false
 	}
 
 	if a.info.msgType == "image" && mode == services.ModePicCreate {
-		//Save image
-		imageKey := a.info.imageKey
-		//fmt.Printf("fileKey: %s \n", imageKey)
-		msgId := a.info.msgId
-		//fmt.Println("msgId: ", *msgId)
-		req := larkim.NewGetMessageResourceReqBuilder().MessageId(
-			*msgId).FileKey(imageKey).Type("image").Build()
-		resp, err := initialization.GetLarkClient().Im.MessageResource.Get(context.Background(), req)
-		//fmt.Println(resp, err)
+		data, _, err := downloadImage(*a.ctx, *a.info.msgId, a.info.imageKey)
 		if err != nil {
-			//fmt.Println(err)
 			replyMsg(*a.ctx, fmt.Sprintf("🤖️: Image download failed, please try again later. Error message: %v", err),
 				a.info.msgId)
 			return false
 		}
-
-		f := fmt.Sprintf("%s.png", imageKey)
-		resp.WriteFile(f)
-		defer os.Remove(f)
 		resolution := a.handler.sessionCache.GetPicResolution(*a.
 			info.sessionId)
 
-		openai.ConvertJpegToPNG(f)
-		openai.ConvertToRGBA(f, f)
-
 		//Image verification
-		err = openai.VerifyPngs([]string{f})
+		image, err := openai.ConvertToRGBA(bytes.NewReader(data))
+		if err == nil {
+			err = openai.VerifyPngs([][]byte{image})
+		}
 		if err != nil {
 			replyMsg(*a.ctx, fmt.Sprintf("🤖️: Unable to parse image, please send original image and try again~"),
 				a.info.msgId)
 			return false
 		}
-		bs64, err := a.handler.gpt.GenerateOneImageVariation(f, resolution)
+		bs64, err := a.handler.gpt.WithContext(*a.ctx).GenerateOneImageVariation(
+			bytes.NewReader(image), resolution)
 		if err != nil {
 			replyMsg(*a.ctx, fmt.Sprintf(
 				"🤖️: Image generation failed, please try again later. Error message: %v", err), a.info.msgId)
@@ -90,21 +78,69 @@ func (*PicAction) Execute(a *ActionInfo) bool {
 
 	// Generate image
 	if mode == services.ModePicCreate {
-		resolution := a.handler.sessionCache.GetPicResolution(*a.
-			info.sessionId)
-		style := a.handler.sessionCache.GetPicStyle(*a.
-			info.sessionId)
-		bs64, err := a.handler.gpt.GenerateOneImage(a.info.qParsed,
-			resolution, style)
-		if err != nil {
+		if err := a.handler.replyImages(*a.ctx, a.info.sessionId,
+			a.info.msgId, a.info.qParsed); err != nil {
 			replyMsg(*a.ctx, fmt.Sprintf(
 				"🤖️: Image generation failed, please try again later. Error message: %v", err), a.info.msgId)
-			return false
 		}
-		replayImageCardByBase64(*a.ctx, bs64, a.info.msgId, a.info.sessionId,
-			a.info.qParsed)
 		return false
 	}
 
 	return true
 }
+
+// imageOptions are the image settings of the session
+func (m MessageHandler) imageOptions(sessionId string) openai.ImageOptions {
+	model := m.sessionCache.GetPicModel(sessionId)
+	if model == "" {
+		model = m.config.ImageModel
+	}
+	return openai.ImageOptions{
+		Model:   model,
+		Size:    m.sessionCache.GetPicResolution(sessionId),
+		Style:   m.sessionCache.GetPicStyle(sessionId),
+		Quality: m.sessionCache.GetPicQuality(sessionId),
+		N:       m.sessionCache.GetPicCount(sessionId),
+	}
+}
+
+// replyImages generates images of question with the session settings and
+// replies with an image card, or a gallery card for several images. The
+// question is rewritten first when the session enhances prompts
+func (m MessageHandler) replyImages(ctx context.Context, sessionId *string,
+	msgId *string, question string) error {
+	prompt := question
+	if m.sessionCache.GetPicEnhance(*sessionId) {
+		enhanced, err := m.gpt.WithContext(ctx).EnhanceImagePrompt(question)
+		if err != nil {
+			logger.Ctx(ctx).Warnf("enhance image prompt failed: %v", err)
+		} else {
+			prompt = enhanced
+		}
+	}
+
+	images, err := m.gpt.WithContext(ctx).GenerateImages(prompt,
+		m.imageOptions(*sessionId))
+	if err != nil {
+		return err
+	}
+	var imageKeys, revisedPrompts []string
+	for _, image := range images {
+		imageKey, err := uploadImage(ctx, image.Base64)
+		if err != nil {
+			return err
+		}
+		imageKeys = append(imageKeys, *imageKey)
+		revisedPrompts = append(revisedPrompts, image.RevisedPrompt)
+	}
+
+	notes := withPromptNote("✨ Enhanced prompt", prompt, question)
+	if len(imageKeys) == 1 {
+		notes = append(notes, withPromptNote("📝 Revised prompt",
+			revisedPrompts[0], prompt)...)
+		return sendImageCard(ctx, imageKeys[0], msgId, sessionId, question,
+			notes...)
+	}
+	return sendImageGalleryCard(ctx, imageKeys, revisedPrompts, msgId,
+		sessionId, question, notes...)
+}
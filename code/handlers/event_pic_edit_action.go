@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"start-feishubot/services"
+	"start-feishubot/services/openai"
+)
+
+type PicEditAction struct { /*Image editing*/
+}
+
+// imageEdit is an edit asked in a message, the image and its optional
+// mask are resources of that message
+type imageEdit struct {
+	imageKey string
+	maskKey  string
+	prompt   string
+}
+
+// startPicEditMode switches the session to image editing
+func startPicEditMode(a *ActionInfo, _ commandArgs) {
+	a.handler.sessionCache.Clear(*a.info.sessionId)
+	a.handler.sessionCache.SetMode(*a.info.sessionId,
+		services.ModePicEdit)
+	a.handler.sessionCache.SetPicResolution(*a.info.sessionId,
+		services.Resolution1024)
+	sendPicEditInstructionCard(*a.ctx, a.info.sessionId, a.info.msgId)
+}
+
+func (*PicEditAction) Execute(a *ActionInfo) bool {
+	check := AzureModeCheck(a)
+	if !check {
+		return true
+	}
+	mode := a.handler.sessionCache.GetMode(*a.info.sessionId)
+	if mode != services.ModePicEdit {
+		return true
+	}
+	if !a.permitted(FeaturePic) {
+		return false
+	}
+
+	var imageKeys []string
+	for _, key := range a.info.imageKeys {
+		if key != "" {
+			imageKeys = append(imageKeys, key)
+		}
+	}
+	prompt := strings.TrimSpace(a.info.qParsed)
+	if a.info.msgType != "post" || len(imageKeys) == 0 || prompt == "" {
+		replyMsg(*a.ctx, "🤖️: Please send the image and the change to make together in one message, with an optional second image as mask",
+			a.info.msgId)
+		return false
+	}
+	if len(imageKeys) > 2 {
+		replyMsg(*a.ctx, "🤖️: Please send at most two images, the image to edit and its mask",
+			a.info.msgId)
+		return false
+	}
+
+	edit := imageEdit{imageKey: imageKeys[0], prompt: prompt}
+	if len(imageKeys) == 2 {
+		edit.maskKey = imageKeys[1]
+	}
+	if err := a.handler.editImage(*a.ctx, a.info.sessionId, a.info.msgId,
+		edit); err != nil {
+		replyMsg(*a.ctx, fmt.Sprintf(
+			"🤖️: Image editing failed, please try again later. Error message: %v", err), a.info.msgId)
+	}
+	return false
+}
+
+// editImage downloads the image and mask of edit from msgId, edits the
+// image and replies with the result
+func (m MessageHandler) editImage(ctx context.Context, sessionId *string,
+	msgId *string, edit imageEdit) error {
+	var pngs [][]byte
+	for _, key := range []string{edit.imageKey, edit.maskKey} {
+		if key == "" {
+			continue
+		}
+		data, _, err := downloadImage(ctx, *msgId, key)
+		if err != nil {
+			return err
+		}
+		png, err := openai.ConvertToRGBA(bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		pngs = append(pngs, png)
+	}
+	if err := openai.VerifyPngs(pngs); err != nil {
+		return err
+	}
+
+	var mask io.Reader
+	if len(pngs) == 2 {
+		mask = bytes.NewReader(pngs[1])
+	}
+	resolution := m.sessionCache.GetPicResolution(*sessionId)
+	bs64, err := m.gpt.WithContext(ctx).GenerateOneImageEdit(
+		bytes.NewReader(pngs[0]), mask, edit.prompt, resolution)
+	if err != nil {
+		return err
+	}
+	imageKey, err := uploadImage(ctx, bs64)
+	if err != nil {
+		return err
+	}
+	return sendEditImageCard(ctx, *imageKey, msgId, sessionId, edit)
+}
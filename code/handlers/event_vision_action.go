@@ -3,13 +3,8 @@ package handlers
 import (
 	"context"
 	"fmt"
-	"os"
-	"start-feishubot/initialization"
 	"start-feishubot/services"
 	"start-feishubot/services/openai"
-	"start-feishubot/utils"
-
-	larkim "github.com/larksuite/oapi-sdk-go/v3/service/im/v1"
 )
 
 type VisionAction struct { /*Image Reasoning*/
@@ -20,15 +15,12 @@ func (va *VisionAction) Execute(a *ActionInfo) bool {
 		return true
 	}
 
-	if isVisionCommand(a) {
-		initializeVisionMode(a)
-		sendVisionInstructionCard(*a.ctx, a.info.sessionId, a.info.msgId)
-		return false
-	}
-
 	mode := a.handler.sessionCache.GetMode(*a.info.sessionId)
 
 	if a.info.msgType == "image" {
+		if len(a.info.images) > 0 {
+			return true
+		}
 		if mode != services.ModeVision {
 			sendVisionModeCheckCard(*a.ctx, a.info.sessionId, a.info.msgId)
 			return false
@@ -44,84 +36,77 @@ func (va *VisionAction) Execute(a *ActionInfo) bool {
 	return true
 }
 
-func isVisionCommand(a *ActionInfo) bool {
-	_, foundPic := utils.EitherTrimEqual(a.info.qParsed, "/vision", "Image Reasoning")
-	return foundPic
-}
-
-func initializeVisionMode(a *ActionInfo) {
+// startVisionMode switches the session to image reasoning
+func startVisionMode(a *ActionInfo, _ commandArgs) {
 	a.handler.sessionCache.Clear(*a.info.sessionId)
 	a.handler.sessionCache.SetMode(*a.info.sessionId, services.ModeVision)
 	a.handler.sessionCache.SetVisionDetail(*a.info.sessionId, services.VisionDetailHigh)
+	sendVisionInstructionCard(*a.ctx, a.info.sessionId, a.info.msgId)
 }
 
 func (va *VisionAction) handleVisionImage(a *ActionInfo) bool {
 	detail := a.handler.sessionCache.GetVisionDetail(*a.info.sessionId)
-	base64, err := downloadAndEncodeImage(a.info.imageKey, a.info.msgId)
+	url, err := downloadImageDataURL(*a.ctx, a.info.msgId, a.info.imageKey)
 	if err != nil {
 		replyWithErrorMsg(*a.ctx, err, a.info.msgId)
 		return false
 	}
 
-	return va.processImageAndReply(a, base64, detail)
+	return va.processImageAndReply(a, url, detail)
 }
 
 func (va *VisionAction) handleVisionPost(a *ActionInfo) bool {
 	detail := a.handler.sessionCache.GetVisionDetail(*a.info.sessionId)
-	var base64s []string
+	var urls []string
 
 	for _, imageKey := range a.info.imageKeys {
 		if imageKey == "" {
 			continue
 		}
-		base64, err := downloadAndEncodeImage(imageKey, a.info.msgId)
+		url, err := downloadImageDataURL(*a.ctx, a.info.msgId, imageKey)
 		if err != nil {
 			replyWithErrorMsg(*a.ctx, err, a.info.msgId)
 			return false
 		}
-		base64s = append(base64s, base64)
+		urls = append(urls, url)
 	}
 
-	if len(base64s) == 0 {
+	if len(urls) == 0 {
 		replyMsg(*a.ctx, "🤖️: Please send an image", a.info.msgId)
 		return false
 	}
 
-	return va.processMultipleImagesAndReply(a, base64s, detail)
+	return va.processMultipleImagesAndReply(a, urls, detail)
 }
 
-func downloadAndEncodeImage(imageKey string, msgId *string) (string, error) {
-	f := fmt.Sprintf("%s.png", imageKey)
-	defer os.Remove(f)
-
-	req := larkim.NewGetMessageResourceReqBuilder().MessageId(*msgId).FileKey(imageKey).Type("image").Build()
-	resp, err := initialization.GetLarkClient().Im.MessageResource.Get(context.Background(), req)
-	if err != nil {
-		return "", err
-	}
+func replyWithErrorMsg(ctx context.Context, err error, msgId *string) {
+	replyMsg(ctx, fmt.Sprintf("🤖️: Image download failed, please try again later. Error message: %v", err), msgId)
+}
 
-	resp.WriteFile(f)
-	return openai.GetBase64FromImage(f)
+func (va *VisionAction) processImageAndReply(a *ActionInfo, url string, detail string) bool {
+	msg := createVisionMessages(defaultImagePrompt, url, detail)
+	return va.replyVisionInfo(a, msg)
 }
 
-func replyWithErrorMsg(ctx context.Context, err error, msgId *string) {
-	replyMsg(ctx, fmt.Sprintf("🤖️: Image download failed, please try again later. Error message: %v", err), msgId)
+func (va *VisionAction) processMultipleImagesAndReply(a *ActionInfo, urls []string, detail string) bool {
+	msg := createMultipleVisionMessages(a.info.qParsed, urls, detail)
+	return va.replyVisionInfo(a, msg)
 }
 
-func (va *VisionAction) processImageAndReply(a *ActionInfo, base64 string, detail string) bool {
-	msg := createVisionMessages("Explain this image", base64, detail)
-	completions, err := a.handler.gpt.GetVisionInfo(msg)
-	if err != nil {
-		replyWithErrorMsg(*a.ctx, err, a.info.msgId)
+func (va *VisionAction) replyVisionInfo(a *ActionInfo, msg []openai.VisionMessages) bool {
+	if a.handler.config.StreamMode {
+		card, err := newStreamCard(*a.ctx, a.info.sessionId, a.info.msgId,
+			visionTopicTitle)
+		if err != nil {
+			return false
+		}
+		card.Stream(func(ctx context.Context,
+			responseStream chan string) error {
+			return a.handler.gpt.StreamVisionInfo(ctx, msg, responseStream)
+		})
 		return false
 	}
-	sendVisionTopicCard(*a.ctx, a.info.sessionId, a.info.msgId, completions.Content)
-	return false
-}
-
-func (va *VisionAction) processMultipleImagesAndReply(a *ActionInfo, base64s []string, detail string) bool {
-	msg := createMultipleVisionMessages(a.info.qParsed, base64s, detail)
-	completions, err := a.handler.gpt.GetVisionInfo(msg)
+	completions, err := a.handler.gpt.WithContext(*a.ctx).GetVisionInfo(msg)
 	if err != nil {
 		replyWithErrorMsg(*a.ctx, err, a.info.msgId)
 		return false
@@ -130,14 +115,14 @@ func (va *VisionAction) processMultipleImagesAndReply(a *ActionInfo, base64s []s
 	return false
 }
 
-func createVisionMessages(query, base64Image, detail string) []openai.VisionMessages {
+func createVisionMessages(query, imageURL, detail string) []openai.VisionMessages {
 	return []openai.VisionMessages{
 		{
 			Role: "user",
 			Content: []openai.ContentType{
 				{Type: "text", Text: query},
 				{Type: "image_url", ImageURL: &openai.ImageURL{
-					URL:    "data:image/jpeg;base64," + base64Image,
+					URL:    imageURL,
 					Detail: detail,
 				}},
 			},
@@ -145,13 +130,13 @@ func createVisionMessages(query, base64Image, detail string) []openai.VisionMess
 	}
 }
 
-func createMultipleVisionMessages(query string, base64Images []string, detail string) []openai.VisionMessages {
+func createMultipleVisionMessages(query string, imageURLs []string, detail string) []openai.VisionMessages {
 	content := []openai.ContentType{{Type: "text", Text: query}}
-	for _, base64Image := range base64Images {
+	for _, imageURL := range imageURLs {
 		content = append(content, openai.ContentType{
 			Type: "image_url",
 			ImageURL: &openai.ImageURL{
-				URL:    "data:image/jpeg;base64," + base64Image,
+				URL:    imageURL,
 				Detail: detail,
 			},
 		})
@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"fmt"
+	"time"
+
+	"start-feishubot/initialization"
+	"start-feishubot/logger"
+	"start-feishubot/services"
+	"start-feishubot/services/larkdoc"
+	"start-feishubot/services/webpage"
+	"start-feishubot/utils/document"
+)
+
+const (
+	// linked pages read from a single message
+	maxLinkedPages = 2
+	maxPageRunes   = 20000
+)
+
+func newWebFetcher(config initialization.Config) *webpage.Fetcher {
+	if !config.WebFetchOn {
+		return nil
+	}
+	fetcher, err := webpage.NewFetcher(
+		time.Duration(config.WebFetchTimeout)*time.Second,
+		int64(config.WebFetchMaxSizeKB)<<10, config.HttpProxy,
+		config.WebFetchAllowPrivate)
+	if err != nil {
+		logger.Errorf("create web fetcher failed: %v", err)
+		return nil
+	}
+	return fetcher
+}
+
+type WebPageAction struct { /*Web page links*/
+}
+
+// Execute reads the web pages linked in the message into the session
+// documents, with their title, meta description, headings, canonical and
+// hreflang ahead of the text
+func (*WebPageAction) Execute(a *ActionInfo) bool {
+	if a.handler.webFetcher == nil {
+		return true
+	}
+	if a.info.msgType != "text" && a.info.msgType != "post" {
+		return true
+	}
+	mode := a.handler.sessionCache.GetMode(*a.info.sessionId)
+	if mode == services.ModePicCreate || mode == services.ModePicEdit ||
+		mode == services.ModeVision {
+		return true
+	}
+
+	var urls []string
+	for _, u := range webpage.FindURLs(a.info.unredacted) {
+		// Lark Docs are read through the open API by LarkDocAction
+		if len(larkdoc.FindLinks(u)) == 0 {
+			urls = append(urls, u)
+		}
+	}
+	if len(urls) > maxLinkedPages {
+		urls = urls[:maxLinkedPages]
+	}
+
+	for _, u := range urls {
+		page, err := a.handler.webFetcher.Fetch(*a.ctx, u)
+		if err != nil {
+			linkFailed(a, u, fmt.Sprintf("🤖️: Could not read %s. Error message: %v", u, err), err)
+			continue
+		}
+		chunks := document.Chunk(a.handler.redact(*a.info.sessionId,
+			page.Markdown(maxPageRunes)), documentChunkBytes)
+		if len(chunks) == 0 {
+			continue
+		}
+		title := page.Title
+		if title == "" {
+			title = u
+		}
+		a.handler.sessionCache.AddDocument(*a.info.sessionId, services.Document{
+			Name: a.handler.redact(*a.info.sessionId,
+				fmt.Sprintf("Web page \"%s\" %s", title, u)),
+			Chunks: chunks,
+		})
+	}
+	return true
+}
+
+// linkFailed logs a link that could not be read. Only private chats are
+// told, in groups a link posted in passing would flood the chat with errors
+func linkFailed(a *ActionInfo, link string, reply string, err error) {
+	logger.Ctx(*a.ctx).Warnf("read %s failed: %v", link, err)
+	if a.info.handlerType == UserHandler {
+		replyMsg(*a.ctx, reply, a.info.msgId)
+	}
+}
@@ -4,12 +4,21 @@ import (
 	"context"
 	"fmt"
 	larkcore "github.com/larksuite/oapi-sdk-go/v3/core"
+	"reflect"
 	"start-feishubot/logger"
+	"start-feishubot/metrics"
+	"start-feishubot/tracing"
 	"strings"
 
 	"start-feishubot/initialization"
 	"start-feishubot/services"
+	"start-feishubot/services/access"
+	"start-feishubot/services/audit"
+	"start-feishubot/services/knowledge"
+	"start-feishubot/services/moderation"
 	"start-feishubot/services/openai"
+	"start-feishubot/services/pii"
+	"start-feishubot/services/webpage"
 
 	larkcard "github.com/larksuite/oapi-sdk-go/v3/card"
 	larkim "github.com/larksuite/oapi-sdk-go/v3/service/im/v1"
@@ -17,17 +26,72 @@ import (
 
 // Chain of responsibility pattern
 func chain(data *ActionInfo, actions ...Action) bool {
+	parent := data.ctx
+	defer func() { data.ctx = parent }()
 	for _, v := range actions {
-		if !v.Execute(data) {
+		name := actionName(v)
+		ctx, span := tracing.Start(*parent, "action "+name)
+		scope := audit.ScopeFrom(ctx)
+		scope.Action = name
+		ctx = audit.WithScope(ctx, scope)
+		data.ctx = &ctx
+		next := v.Execute(data)
+		span.End()
+		if !next {
+			metrics.Actions.WithLabelValues(name).Inc()
+			auditAction(ctx, name, data.info)
 			return false
 		}
 	}
+	metrics.Actions.WithLabelValues("none").Inc()
 	return true
 }
 
+// unaudited are the actions stopping the chain for messages the bot
+// ignores, duplicates and group messages not addressed to it
+var unaudited = map[string]bool{"ProcessedUnique": true, "ProcessMention": true}
+
+// auditAction records the message an action handled with its attachments
+func auditAction(ctx context.Context, name string, info *MsgInfo) {
+	if !audit.Enabled() || unaudited[name] {
+		return
+	}
+	var attachments []audit.Attachment
+	if info.fileKey != "" {
+		attachments = append(attachments, audit.Attachment{
+			Type: info.msgType, Key: info.fileKey, Name: info.fileName})
+	}
+	if info.imageKey != "" {
+		attachments = append(attachments, audit.Attachment{
+			Type: "image", Key: info.imageKey})
+	}
+	for _, key := range info.imageKeys {
+		attachments = append(attachments, audit.Attachment{
+			Type: "image", Key: key})
+	}
+	audit.RecordAction(ctx, audit.Record{
+		Prompt:      audit.Text(info.qParsed),
+		Attachments: attachments,
+	})
+}
+
+// actionName names an action after its type, PicAction is "Pic"
+func actionName(action Action) string {
+	name := reflect.TypeOf(action).String()
+	name = name[strings.LastIndexByte(name, '.')+1:]
+	return strings.TrimSuffix(name, "Action")
+}
+
 type MessageHandler struct {
 	sessionCache services.SessionServiceCacheInterface
 	msgCache     services.MsgCacheInterface
+	replyCache   services.ReplyCacheInterface
+	knowledge    *knowledge.Base  // nil unless the knowledge base is on
+	webFetcher   *webpage.Fetcher // nil unless web fetching is on
+	access       *access.Policy
+	moderation   *moderation.Filter // nil unless moderation is on
+	redactor     *pii.Redactor      // nil unless redaction is on
+	menuKeys     map[string]string  // bot menu keys to command names
 	gpt          *openai.ChatGPT
 	config       initialization.Config
 }
@@ -42,31 +106,39 @@ func judgeMsgType(event *larkim.P2MessageReceiveV1) (string, error) {
 	msgType := event.Event.Message.MessageType
 
 	switch *msgType {
-	case "text", "image", "audio", "post":
+	case "text", "image", "audio", "post", "file":
 		return *msgType, nil
 	default:
 		return "", fmt.Errorf("unknown message type: %v", *msgType)
 	}
 }
 
+// senderOpenId returns the open id of the user who sent the message
+func senderOpenId(event *larkim.P2MessageReceiveV1) string {
+	sender := event.Event.Sender
+	if sender == nil || sender.SenderId == nil {
+		return ""
+	}
+	return larkcore.StringValue(sender.SenderId.OpenId)
+}
+
 func (m MessageHandler) msgReceivedHandler(ctx context.Context, event *larkim.P2MessageReceiveV1) error {
 	handlerType := judgeChatType(event)
-	logger.Debug("handlerType", handlerType)
 	if handlerType == "otherChat" {
-		fmt.Println("unknown chat type")
+		logger.Ctx(ctx).Warn("unknown chat type")
 		return nil
 	}
-	logger.Debug("Received message: ", larkcore.Prettify(event.Event.Message))
 
 	msgType, err := judgeMsgType(event)
 	if err != nil {
-		fmt.Printf("error getting message type: %v\n", err)
+		logger.Ctx(ctx).Warnf("error getting message type: %v", err)
 		return nil
 	}
 
 	content := event.Event.Message.Content
 	msgId := event.Event.Message.MessageId
 	rootId := event.Event.Message.RootId
+	parentId := event.Event.Message.ParentId
 	chatId := event.Event.Message.ChatId
 	mention := event.Event.Message.Mentions
 
@@ -74,13 +146,29 @@ func (m MessageHandler) msgReceivedHandler(ctx context.Context, event *larkim.P2
 	if sessionId == nil || *sessionId == "" {
 		sessionId = msgId
 	}
+	ctx = logger.WithSessionID(ctx, *sessionId)
+	userId := senderOpenId(event)
+	ctx = audit.WithScope(ctx, audit.Scope{
+		UserID:    userId,
+		ChatID:    larkcore.StringValue(chatId),
+		SessionID: *sessionId,
+		MsgID:     *msgId,
+	})
+	logger.Ctx(ctx).WithFields(logger.Fields{
+		"msg_id":    *msgId,
+		"msg_type":  msgType,
+		"chat_type": handlerType,
+	}).Debug("Received message:", logger.UserContent(*content))
 	msgInfo := MsgInfo{
 		handlerType: handlerType,
 		msgType:     msgType,
 		msgId:       msgId,
 		chatId:      chatId,
+		userId:      userId,
+		parentId:    larkcore.StringValue(parentId),
 		qParsed:     strings.Trim(parseContent(*content, msgType), " "),
 		fileKey:     parseFileKey(*content),
+		fileName:    parseFileName(*content),
 		imageKey:    parseImageKey(*content),
 		imageKeys:   parsePostImageKeys(*content),
 		sessionId:   sessionId,
@@ -94,15 +182,18 @@ func (m MessageHandler) msgReceivedHandler(ctx context.Context, event *larkim.P2
 	actions := []Action{
 		&ProcessedUniqueAction{}, //Avoid duplicate processing
 		&ProcessMentionAction{},  //Check if bot should be invoked
+		&AccessAction{},          //Allow and deny lists
 		&AudioAction{},           //Audio processing
-		&ClearAction{},           //Clear message processing
+		&FileAction{},            //Document processing
+		&RedactAction{},          //Personal data and secrets
+		&ModerationAction{},      //Moderation of user input
+		&CommandAction{},         //Registered commands
+		&LarkDocAction{},         //Lark Docs link processing
+		&WebPageAction{},         //Web page link processing
+		&MultimodalAction{},      //Images in regular chat
+		&PicEditAction{},         //Image editing processing
 		&VisionAction{},          //Image reasoning processing
 		&PicAction{},             //Picture processing
-		&AIModeAction{},          //Mode switching processing
-		&RoleListAction{},        //Role list processing
-		&HelpAction{},            //Help processing
-		&BalanceAction{},         //Balance processing
-		&RolePlayAction{},        //Role play processing
 		&MessageAction{},         //Message processing
 		&EmptyAction{},           //Empty message processing
 		&StreamMessageAction{},   //Stream message processing
@@ -118,6 +209,13 @@ func NewMessageHandler(gpt *openai.ChatGPT,
 	return &MessageHandler{
 		sessionCache: services.GetSessionCache(),
 		msgCache:     services.GetMsgCache(),
+		replyCache:   services.GetReplyCache(),
+		knowledge:    openKnowledgeBase(gpt, config),
+		webFetcher:   newWebFetcher(config),
+		access:       openAccessPolicy(config),
+		moderation:   newModerationFilter(config),
+		redactor:     newRedactor(config),
+		menuKeys:     menuCommands(config.BotMenuKeys),
 		gpt:          gpt,
 		config:       config,
 	}
@@ -3,16 +3,21 @@ package handlers
 import (
 	"context"
 	"start-feishubot/logger"
+	"start-feishubot/metrics"
 
 	"start-feishubot/initialization"
 	"start-feishubot/services/openai"
 
 	larkcard "github.com/larksuite/oapi-sdk-go/v3/card"
+	larkevent "github.com/larksuite/oapi-sdk-go/v3/event"
 	larkim "github.com/larksuite/oapi-sdk-go/v3/service/im/v1"
 )
 
 type MessageHandlerInterface interface {
 	msgReceivedHandler(ctx context.Context, event *larkim.P2MessageReceiveV1) error
+	msgRecalledHandler(ctx context.Context, event *larkim.P2MessageRecalledV1) error
+	msgUpdatedHandler(ctx context.Context, req *larkevent.EventReq) error
+	botMenuHandler(ctx context.Context, req *larkevent.EventReq) error
 	cardHandler(ctx context.Context, cardAction *larkcard.CardAction) (interface{}, error)
 }
 
@@ -31,10 +36,27 @@ func InitHandlers(gpt *openai.ChatGPT, config initialization.Config) {
 }
 
 func Handler(ctx context.Context, event *larkim.P2MessageReceiveV1) error {
+	metrics.WebhookEvents.WithLabelValues("im.message.receive_v1").Inc()
 	return handlers.msgReceivedHandler(ctx, event)
 }
 
+func RecallHandler(ctx context.Context, event *larkim.P2MessageRecalledV1) error {
+	metrics.WebhookEvents.WithLabelValues("im.message.recalled_v1").Inc()
+	return handlers.msgRecalledHandler(ctx, event)
+}
+
+func UpdateHandler(ctx context.Context, req *larkevent.EventReq) error {
+	metrics.WebhookEvents.WithLabelValues(MessageUpdatedEventType).Inc()
+	return handlers.msgUpdatedHandler(ctx, req)
+}
+
+func BotMenuHandler(ctx context.Context, req *larkevent.EventReq) error {
+	metrics.WebhookEvents.WithLabelValues(BotMenuEventType).Inc()
+	return handlers.botMenuHandler(ctx, req)
+}
+
 func ReadHandler(ctx context.Context, event *larkim.P2MessageReadV1) error {
+	metrics.WebhookEvents.WithLabelValues("im.message.message_read_v1").Inc()
 	readerId := event.Event.Reader.ReaderId.OpenId
 	//fmt.Printf("msg is read by : %v \n", *readerId)
 	logger.Debugf("msg is read by : %v \n", *readerId)
@@ -45,32 +67,36 @@ func ReadHandler(ctx context.Context, event *larkim.P2MessageReadV1) error {
 func CardHandler() func(ctx context.Context,
 	cardAction *larkcard.CardAction) (interface{}, error) {
 	return func(ctx context.Context, cardAction *larkcard.CardAction) (interface{}, error) {
+		metrics.WebhookEvents.WithLabelValues("card.action.trigger").Inc()
+		log := logger.Ctx(ctx)
 		defer func() {
 			if err := recover(); err != nil {
-				logger.Error("❌ Card handler PANIC:", err)
+				log.Error("❌ Card handler PANIC:", err)
 			}
 		}()
 
-		logger.Info("✓ CardHandler called - SDK decryption successful")
+		log.Info("✓ CardHandler called - SDK decryption successful")
 
 		if handlers == nil {
-			logger.Error("❌ Handlers not initialized!")
+			log.Error("❌ Handlers not initialized!")
 			return nil, nil
 		}
 
 		if cardAction == nil {
-			logger.Error("❌ cardAction is nil!")
+			log.Error("❌ cardAction is nil!")
 			return nil, nil
 		}
 
-		logger.Info("Card action type:", cardAction.Action)
-		logger.Debugf("Full card action: %+v", cardAction)
+		if cardAction.Action != nil {
+			log.Info("Card action tag:", cardAction.Action.Tag)
+		}
+		log.Debugf("Full card action: %v", logger.UserContent(cardAction))
 
 		result, err := handlers.cardHandler(ctx, cardAction)
 		if err != nil {
-			logger.Error("❌ Card handler error:", err)
+			log.Error("❌ Card handler error:", err)
 		} else {
-			logger.Info("✓ Card handler completed successfully")
+			log.Info("✓ Card handler completed successfully")
 		}
 		return result, err
 	}
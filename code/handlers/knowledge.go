@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"start-feishubot/initialization"
+	"start-feishubot/logger"
+	"start-feishubot/services/knowledge"
+	"start-feishubot/services/openai"
+
+	larkcard "github.com/larksuite/oapi-sdk-go/v3/card"
+)
+
+// openKnowledgeBase loads the knowledge base index and refreshes it from
+// the knowledge base directory in the background
+func openKnowledgeBase(gpt *openai.ChatGPT,
+	config initialization.Config) *knowledge.Base {
+	if !config.KnowledgeBaseOn {
+		return nil
+	}
+	base, err := knowledge.Open(gpt, config.EmbeddingModel,
+		config.KnowledgeBaseIndex)
+	if err != nil {
+		logger.Errorf("open knowledge base failed: %v", err)
+		return nil
+	}
+	if config.KnowledgeBaseDir != "" {
+		go func() {
+			defer func() {
+				if err := recover(); err != nil {
+					logger.Errorf("ingest knowledge base panic: %v", err)
+				}
+			}()
+			ingested, err := base.IngestDir(config.KnowledgeBaseDir)
+			if err != nil {
+				logger.Errorf("ingest knowledge base failed: %v", err)
+			}
+			logger.Info(fmt.Sprintf("knowledge base ingested %d documents, "+
+				"%d chunks in total", ingested, base.Len()))
+		}()
+	}
+	return base
+}
+
+// withKnowledge adds the knowledge base chunks closest to the last question
+// and returns the documents they come from, the chunks are not kept in the
+// history
+func (m MessageHandler) withKnowledge(msg []openai.Messages) (
+	[]openai.Messages, []string) {
+	if m.knowledge == nil || len(msg) == 0 {
+		return msg, nil
+	}
+	results, err := m.knowledge.Retrieve(msg[len(msg)-1].Content,
+		m.config.KnowledgeBaseTopK)
+	if err != nil {
+		logger.Errorf("retrieve knowledge failed: %v", err)
+		return msg, nil
+	}
+	if len(results) == 0 {
+		return msg, nil
+	}
+
+	var excerpts strings.Builder
+	excerpts.WriteString("Answer using the following excerpts from the team " +
+		"knowledge base when they are relevant, and mention the source " +
+		"in brackets.\n")
+	var sources []string
+	seen := map[string]bool{}
+	for _, result := range results {
+		fmt.Fprintf(&excerpts, "\n[%s]\n%s\n", result.Source, result.Text)
+		if !seen[result.Source] {
+			seen[result.Source] = true
+			sources = append(sources, result.Source)
+		}
+	}
+
+	request := append([]openai.Messages{}, msg[:len(msg)-1]...)
+	request = append(request, openai.Messages{
+		Role: "system", Content: excerpts.String(),
+	}, msg[len(msg)-1])
+	return request, sources
+}
+
+func NewKnowledgeBaseAddHandler(cardMsg CardMsg,
+	m MessageHandler) CardHandlerFunc {
+	return func(ctx context.Context, cardAction *larkcard.CardAction) (interface{}, error) {
+		if cardMsg.Kind == KnowledgeBaseAddKind {
+			go func() {
+				m.CommonProcessKnowledgeBaseAdd(ctx, cardMsg)
+			}()
+			return nil, nil
+		}
+		return nil, ErrNextHandler
+	}
+}
+
+// CommonProcessKnowledgeBaseAdd embeds a document sent in the session into
+// the knowledge base
+func (m MessageHandler) CommonProcessKnowledgeBaseAdd(ctx context.Context,
+	cardMsg CardMsg) {
+	if m.knowledge == nil {
+		replyMsg(ctx, "🤖️: The knowledge base is not enabled", &cardMsg.MsgId)
+		return
+	}
+	name, _ := cardMsg.Value.(string)
+	for _, doc := range m.sessionCache.GetDocuments(cardMsg.SessionId) {
+		if doc.Name != name {
+			continue
+		}
+		if err := m.knowledge.AddChunks(doc.Name, doc.Chunks); err != nil {
+			replyWithErrorMsg(ctx, err, &cardMsg.MsgId)
+			return
+		}
+		replyMsg(ctx, fmt.Sprintf("📚 Added %s to the knowledge base", doc.Name),
+			&cardMsg.MsgId)
+		return
+	}
+	replyMsg(ctx, "🤖️: The document has expired, please send it again", &cardMsg.MsgId)
+}
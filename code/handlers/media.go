@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+
+	"start-feishubot/initialization"
+
+	larkim "github.com/larksuite/oapi-sdk-go/v3/service/im/v1"
+)
+
+const (
+	// largest image the vision and image APIs accept
+	maxImageBytes = 20 << 20
+	maxAudioBytes = 100 << 20
+)
+
+// imageTypes are the sniffed content types the OpenAI APIs accept
+var imageTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// downloadResource fetches a resource attached to msgId into memory,
+// refusing resources larger than maxSize. resourceType is "image" for
+// images and "file" for files, audio and video
+func downloadResource(ctx context.Context, msgId, key, resourceType string,
+	maxSize int64) (_ []byte, err error) {
+	ctx, done := larkCall(ctx, "download_resource")
+	defer done(&err)
+	req := larkim.NewGetMessageResourceReqBuilder().MessageId(msgId).
+		FileKey(key).Type(resourceType).Build()
+	resp, err := initialization.GetLarkClient().Im.MessageResource.Get(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if !resp.Success() {
+		return nil, fmt.Errorf("download %s failed: %s", resourceType, resp.Msg)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.File, maxSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("read %s failed: %w", resourceType, err)
+	}
+	if int64(len(data)) > maxSize {
+		return nil, fmt.Errorf("%s is larger than %d MB", resourceType,
+			maxSize>>20)
+	}
+	return data, nil
+}
+
+// downloadImage fetches an image attached to msgId and returns it with its
+// sniffed content type
+func downloadImage(ctx context.Context, msgId, imageKey string) ([]byte,
+	string, error) {
+	data, err := downloadResource(ctx, msgId, imageKey, "image", maxImageBytes)
+	if err != nil {
+		return nil, "", err
+	}
+	contentType := http.DetectContentType(data)
+	if !imageTypes[contentType] {
+		return nil, "", fmt.Errorf("unsupported image type %s", contentType)
+	}
+	return data, contentType, nil
+}
+
+// downloadUploadedImage fetches an image the bot uploaded itself, such as
+// a generated image shown on a card
+func downloadUploadedImage(ctx context.Context, imageKey string) (_ []byte,
+	err error) {
+	ctx, done := larkCall(ctx, "get_image")
+	defer done(&err)
+	req := larkim.NewGetImageReqBuilder().ImageKey(imageKey).Build()
+	resp, err := initialization.GetLarkClient().Im.Image.Get(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if !resp.Success() {
+		return nil, fmt.Errorf("download image failed: %s", resp.Msg)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.File, maxImageBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("read image failed: %w", err)
+	}
+	if len(data) > maxImageBytes {
+		return nil, fmt.Errorf("image is larger than %d MB", maxImageBytes>>20)
+	}
+	return data, nil
+}
+
+// downloadImageDataURL fetches an image attached to msgId as a data URL,
+// the form images are sent to the vision models in
+func downloadImageDataURL(ctx context.Context, msgId *string,
+	imageKey string) (string, error) {
+	data, contentType, err := downloadImage(ctx, *msgId, imageKey)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("data:%s;base64,%s", contentType,
+		base64.StdEncoding.EncodeToString(data)), nil
+}
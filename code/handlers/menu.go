@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"start-feishubot/logger"
+	"start-feishubot/services/audit"
+
+	larkevent "github.com/larksuite/oapi-sdk-go/v3/event"
+)
+
+// BotMenuEventType is sent when a user clicks an item of the bot menu, the
+// SDK has no typed handler for it yet
+const BotMenuEventType = "application.bot.menu_v6"
+
+// menuNewTopic is the menu key starting a new topic, it runs no command
+const menuNewTopic = "new_topic"
+
+type botMenuEvent struct {
+	Header struct {
+		EventId string `json:"event_id"`
+	} `json:"header"`
+	Event struct {
+		Operator struct {
+			OperatorId struct {
+				OpenId string `json:"open_id"`
+			} `json:"operator_id"`
+		} `json:"operator"`
+		EventKey string `json:"event_key"`
+	} `json:"event"`
+}
+
+// menuCommands maps the keys of the bot menu to commands. Every command is
+// also reachable by its name without the slash, such as picture
+func menuCommands(keys []string) map[string]string {
+	mapping := map[string]string{"usage": "/balance"}
+	for _, key := range keys {
+		key, name, found := strings.Cut(key, "=")
+		if !found || commands.lookup(strings.TrimSpace(name)) == nil {
+			logger.Warnf("ignoring bot menu key %q, want key=/command", key)
+			continue
+		}
+		mapping[strings.TrimSpace(key)] = strings.TrimSpace(name)
+	}
+	return mapping
+}
+
+// menuCommand returns the command the menu key runs
+func (m MessageHandler) menuCommand(key string) *command {
+	if name, ok := m.menuKeys[key]; ok {
+		return commands.lookup(name)
+	}
+	return commands.lookup("/" + key)
+}
+
+// botMenuHandler runs the command of the clicked menu item. Menu events
+// have no message to reply to, so the bot first writes to the user and the
+// command replies to that message, starting its topic
+func (m MessageHandler) botMenuHandler(ctx context.Context,
+	req *larkevent.EventReq) error {
+	var event botMenuEvent
+	if err := decodeCustomizedEvent(req, m.config.FeishuAppEncryptKey,
+		&event); err != nil {
+		return err
+	}
+	key := event.Event.EventKey
+	userId := event.Event.Operator.OperatorId.OpenId
+	if userId == "" {
+		return fmt.Errorf("bot menu event %s has no operator", key)
+	}
+	if eventId := event.Header.EventId; eventId != "" {
+		// Lark resends events it did not get an answer to in time
+		if m.msgCache.IfProcessed(eventId) {
+			return nil
+		}
+		m.msgCache.TagProcessed(eventId)
+	}
+	log := logger.Ctx(ctx).WithField("menu_key", key)
+
+	var c *command
+	title := "🆕 New topic, reply to this message to start it."
+	if key != menuNewTopic {
+		if c = m.menuCommand(key); c == nil {
+			log.Warn("bot menu key maps to no command")
+			return nil
+		}
+		title = fmt.Sprintf("🤖️: %s", c.title)
+	}
+	if !m.access.Allowed(m.subject(ctx, userId, "")) {
+		log.Infof("access denied to %s", userId)
+		return nil
+	}
+
+	anchor, err := sendMsgToUser(ctx, title, userId)
+	if err != nil {
+		return fmt.Errorf("send bot menu message failed: %w", err)
+	}
+	msgId, chatId := anchor.MessageId, anchor.ChatId
+	ctx = logger.WithSessionID(ctx, *msgId)
+	ctx = audit.WithScope(ctx, audit.Scope{
+		UserID:    userId,
+		ChatID:    *chatId,
+		SessionID: *msgId,
+		MsgID:     *msgId,
+		Action:    "menu " + key,
+	})
+	info := &MsgInfo{
+		handlerType: UserHandler,
+		msgType:     "text",
+		msgId:       msgId,
+		chatId:      chatId,
+		userId:      userId,
+		sessionId:   msgId,
+	}
+	if c != nil {
+		info.qParsed = c.name
+		a := &ActionInfo{ctx: &ctx, handler: &m, info: info}
+		a.runCommand(c, commandArgs{})
+	}
+	auditAction(ctx, "menu "+key, info)
+	return nil
+}
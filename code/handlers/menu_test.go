@@ -0,0 +1,29 @@
+package handlers
+
+import "testing"
+
+func TestMenuCommand(t *testing.T) {
+	m := MessageHandler{menuKeys: menuCommands([]string{
+		"draw = /picture", "broken", "weather=/weather"})}
+
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{"draw", "/picture"},
+		{"vision", "/vision"},
+		{"usage", "/balance"},
+		{"roles", "/roles"},
+		{"weather", ""},
+		{"broken", ""},
+	}
+	for _, tt := range tests {
+		got := ""
+		if c := m.menuCommand(tt.key); c != nil {
+			got = c.name
+		}
+		if got != tt.want {
+			t.Errorf("menuCommand(%q) = %q, want %q", tt.key, got, tt.want)
+		}
+	}
+}
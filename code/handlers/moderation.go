@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"context"
+	"strings"
+
+	"start-feishubot/initialization"
+	"start-feishubot/logger"
+	"start-feishubot/metrics"
+	"start-feishubot/services/moderation"
+)
+
+// Moderation stages, as named on notices and metrics
+const (
+	stageInput  = "message"
+	stageOutput = "answer"
+)
+
+func newModerationFilter(config initialization.Config) *moderation.Filter {
+	if !config.ModerationOn {
+		return nil
+	}
+	filter, err := moderation.New(moderation.Options{
+		Provider:     config.ModerationProvider,
+		Model:        config.ModerationModel,
+		Action:       config.ModerationAction,
+		Keywords:     config.ModerationKeywords,
+		PatternsFile: config.ModerationPatternsFile,
+		Output:       config.ModerationOutput,
+	})
+	if err != nil {
+		// moderation was asked for, running without it is not an option
+		logger.Fatalf("create moderation filter failed: %v", err)
+	}
+	return filter
+}
+
+type ModerationAction struct { /* Moderation of user input */
+}
+
+func (*ModerationAction) Execute(a *ActionInfo) bool {
+	return a.handler.moderate(*a.ctx, stageInput, a.info.qParsed,
+		a.info.msgId)
+}
+
+// moderate checks text of stage and tells the user when it is flagged,
+// it returns false when the text is blocked. Moderation failures are
+// logged and let the text through so an outage of the endpoint does not
+// stop the bot
+func (m MessageHandler) moderate(ctx context.Context, stage string,
+	text string, msgId *string) bool {
+	if m.moderation == nil || (stage == stageOutput &&
+		!m.moderation.Output()) {
+		return true
+	}
+	verdict, err := m.moderation.Check(m.gpt.WithContext(ctx), text)
+	if err != nil {
+		logger.Ctx(ctx).Warnf("moderation of %s skipped: %v", stage, err)
+		return true
+	}
+	if !verdict.Flagged {
+		return true
+	}
+
+	action := m.moderation.Action()
+	metrics.ModerationFlags.WithLabelValues(stage, verdict.Source, action).Inc()
+	logger.Ctx(ctx).WithFields(logger.Fields{
+		"stage":      stage,
+		"source":     verdict.Source,
+		"categories": strings.Join(verdict.Categories, ","),
+		"action":     action,
+	}).Warn("content flagged by moderation")
+
+	switch action {
+	case moderation.ActionBlock:
+		sendModerationCard(ctx, msgId, stage, verdict.Categories, true)
+		return false
+	case moderation.ActionWarn:
+		sendModerationCard(ctx, msgId, stage, verdict.Categories, false)
+	}
+	return true
+}
+
+// withheldNotice replaces a streamed answer blocked once complete
+const withheldNotice = "🚫 The answer was withheld by the usage policy."
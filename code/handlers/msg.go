@@ -7,31 +7,47 @@ import (
 	"errors"
 	"fmt"
 	"start-feishubot/logger"
+	"strconv"
+	"strings"
+	"time"
 
 	"start-feishubot/initialization"
+	"start-feishubot/metrics"
 	"start-feishubot/services"
 	"start-feishubot/services/openai"
+	"start-feishubot/tracing"
 
 	"github.com/google/uuid"
 	larkcard "github.com/larksuite/oapi-sdk-go/v3/card"
 	larkim "github.com/larksuite/oapi-sdk-go/v3/service/im/v1"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type CardKind string
 type CardChatType string
 
 var (
-	ClearCardKind        = CardKind("clear")            // Clear context
-	PicModeChangeKind    = CardKind("pic_mode_change")  // Switch image creation mode
-	VisionModeChangeKind = CardKind("vision_mode")      // Switch image analysis mode
-	PicResolutionKind    = CardKind("pic_resolution")   // Image resolution adjustment
-	PicStyleKind         = CardKind("pic_style")        // Image style adjustment
-	VisionStyleKind      = CardKind("vision_style")     // Image reasoning level adjustment
-	PicTextMoreKind      = CardKind("pic_text_more")    // Regenerate image from text
-	PicVarMoreKind       = CardKind("pic_var_more")     // Variant image
-	RoleTagsChooseKind   = CardKind("role_tags_choose") // Built-in role tag selection
-	RoleChooseKind       = CardKind("role_choose")      // Built-in role selection
-	AIModeChooseKind     = CardKind("ai_mode_choose")   // AI mode selection
+	ClearCardKind        = CardKind("clear")              // Clear context
+	PicModeChangeKind    = CardKind("pic_mode_change")    // Switch image creation mode
+	VisionModeChangeKind = CardKind("vision_mode")        // Switch image analysis mode
+	PicResolutionKind    = CardKind("pic_resolution")     // Image resolution adjustment
+	PicStyleKind         = CardKind("pic_style")          // Image style adjustment
+	VisionStyleKind      = CardKind("vision_style")       // Image reasoning level adjustment
+	PicTextMoreKind      = CardKind("pic_text_more")      // Regenerate image from text
+	PicVarMoreKind       = CardKind("pic_var_more")       // Variant image
+	PicEditMoreKind      = CardKind("pic_edit_more")      // Edit the image again
+	PicModelKind         = CardKind("pic_model")          // Image model selection
+	PicQualityKind       = CardKind("pic_quality")        // Image quality adjustment
+	PicCountKind         = CardKind("pic_count")          // Images per prompt adjustment
+	PicEnhanceKind       = CardKind("pic_enhance")        // Toggle prompt enhancement
+	RoleTagsChooseKind   = CardKind("role_tags_choose")   // Built-in role tag selection
+	RoleChooseKind       = CardKind("role_choose")        // Built-in role selection
+	AIModeChooseKind     = CardKind("ai_mode_choose")     // AI mode selection
+	StopGenerationKind   = CardKind("stop_generation")    // Stop a streamed answer
+	RegenerateKind       = CardKind("regenerate")         // Regenerate the last answer
+	KnowledgeBaseAddKind = CardKind("knowledge_base_add") // Add a document to the knowledge base
+	VoiceReplyKind       = CardKind("voice_reply")        // Toggle voice replies
 )
 
 var (
@@ -45,6 +61,8 @@ type CardMsg struct {
 	Value     interface{}
 	SessionId string
 	MsgId     string
+	ImageKey  string // image edited by PicEditMoreKind
+	MaskKey   string // mask of the edit, empty when the image had none
 }
 
 type MenuOption struct {
@@ -52,10 +70,33 @@ type MenuOption struct {
 	label string
 }
 
+// larkCall starts the span of a Lark API call. The returned function ends
+// it and records the call's metrics, err points at the caller's error
+// result so failures reported by the server are counted too
+func larkCall(ctx context.Context, api string) (context.Context,
+	func(err *error)) {
+	start := time.Now()
+	ctx, span := tracing.Start(ctx, "lark "+api,
+		trace.WithAttributes(attribute.String("lark.api", api)))
+	return ctx, func(err *error) {
+		status := "ok"
+		if *err != nil {
+			status = "error"
+			tracing.Fail(span, *err)
+		}
+		span.End()
+		metrics.LarkRequests.WithLabelValues(api, status).Inc()
+		metrics.LarkDuration.WithLabelValues(api).
+			Observe(time.Since(start).Seconds())
+	}
+}
+
 func replyCard(ctx context.Context,
 	msgId *string,
 	cardContent string,
-) error {
+) (err error) {
+	ctx, done := larkCall(ctx, "reply_card")
+	defer done(&err)
 	client := initialization.GetLarkClient()
 	resp, err := client.Im.Message.Reply(ctx, larkim.NewReplyMessageReqBuilder().
 		MessageId(*msgId).
@@ -347,6 +388,64 @@ func withOneBtn(btn *larkcard.MessageCardEmbedButton) larkcard.
 	return actions
 }
 
+// withSources cites the knowledge base documents an answer draws on
+func withSources(sources []string) larkcard.MessageCardElement {
+	return withMainMd("📚 **Sources**: " + strings.Join(sources, " · "))
+}
+
+func withKnowledgeBaseAddBtn(sessionId *string, msgId *string,
+	name string) larkcard.MessageCardElement {
+	return withOneBtn(newBtn("Add to Knowledge Base", map[string]interface{}{
+		"value":     name,
+		"kind":      KnowledgeBaseAddKind,
+		"chatType":  UserChatType,
+		"msgId":     *msgId,
+		"sessionId": *sessionId,
+	}, larkcard.MessageCardButtonTypeDefault))
+}
+
+func withStopBtn(sessionId *string, msgId *string) larkcard.
+	MessageCardElement {
+	return withOneBtn(newBtn("Stop", map[string]interface{}{
+		"value":     "1",
+		"kind":      StopGenerationKind,
+		"chatType":  UserChatType,
+		"msgId":     *msgId,
+		"sessionId": *sessionId,
+	}, larkcard.MessageCardButtonTypeDanger))
+}
+
+func withRegenerateBtn(sessionId *string, msgId *string) larkcard.
+	MessageCardElement {
+	return withOneBtn(newBtn("Regenerate", map[string]interface{}{
+		"value":     "1",
+		"kind":      RegenerateKind,
+		"chatType":  UserChatType,
+		"msgId":     *msgId,
+		"sessionId": *sessionId,
+	}, larkcard.MessageCardButtonTypeDefault))
+}
+
+func withVoiceReplyBtn(sessionID *string) larkcard.MessageCardElement {
+	onBtn := newBtn("Turn On", map[string]interface{}{
+		"value":     "1",
+		"kind":      VoiceReplyKind,
+		"chatType":  UserChatType,
+		"sessionId": *sessionID,
+	}, larkcard.MessageCardButtonTypePrimary)
+	offBtn := newBtn("Turn Off", map[string]interface{}{
+		"value":     "0",
+		"kind":      VoiceReplyKind,
+		"chatType":  UserChatType,
+		"sessionId": *sessionID,
+	}, larkcard.MessageCardButtonTypeDefault)
+
+	return larkcard.NewMessageCardAction().
+		Actions([]larkcard.MessageCardActionElement{onBtn, offBtn}).
+		Layout(larkcard.MessageCardActionLayoutBisected.Ptr()).
+		Build()
+}
+
 // New conversation button
 
 func withPicResolutionBtn(sessionID *string) larkcard.
@@ -358,28 +457,102 @@ func withPicResolutionBtn(sessionID *string) larkcard.
 			"sessionId": *sessionID,
 			"msgId":     *sessionID,
 		},
-		// dall-e-2 256, 512, 1024
-		//MenuOption{
-		//	label: "256x256",
-		//	value: string(services.Resolution256),
-		//},
-		//MenuOption{
-		//	label: "512x512",
-		//	value: string(services.Resolution512),
-		//},
-		// dall-e-3
+		// sizes a model does not accept fall back to its default
 		MenuOption{
 			label: "1024x1024",
 			value: string(services.Resolution1024),
 		},
 		MenuOption{
-			label: "1024x1792",
+			label: "512x512 (DALL·E 2)",
+			value: string(services.Resolution512),
+		},
+		MenuOption{
+			label: "256x256 (DALL·E 2)",
+			value: string(services.Resolution256),
+		},
+		MenuOption{
+			label: "1024x1792 (DALL·E 3)",
 			value: string(services.Resolution10241792),
 		},
 		MenuOption{
-			label: "1792x1024",
+			label: "1792x1024 (DALL·E 3)",
 			value: string(services.Resolution17921024),
 		},
+		MenuOption{
+			label: "1024x1536 (GPT Image)",
+			value: string(services.Resolution10241536),
+		},
+		MenuOption{
+			label: "1536x1024 (GPT Image)",
+			value: string(services.Resolution15361024),
+		},
+	)
+
+	modelMenu := newMenu("Model",
+		map[string]interface{}{
+			"value":     "0",
+			"kind":      PicModelKind,
+			"sessionId": *sessionID,
+			"msgId":     *sessionID,
+		},
+		MenuOption{
+			label: "DALL·E 2",
+			value: openai.ImageModelDallE2,
+		},
+		MenuOption{
+			label: "DALL·E 3",
+			value: openai.ImageModelDallE3,
+		},
+		MenuOption{
+			label: "GPT Image",
+			value: openai.ImageModelGPTImage,
+		},
+	)
+
+	qualityMenu := newMenu("Quality",
+		map[string]interface{}{
+			"value":     "0",
+			"kind":      PicQualityKind,
+			"sessionId": *sessionID,
+			"msgId":     *sessionID,
+		},
+		MenuOption{
+			label: "Standard (DALL·E 3)",
+			value: "standard",
+		},
+		MenuOption{
+			label: "HD (DALL·E 3)",
+			value: "hd",
+		},
+		MenuOption{
+			label: "Low (GPT Image)",
+			value: "low",
+		},
+		MenuOption{
+			label: "Medium (GPT Image)",
+			value: "medium",
+		},
+		MenuOption{
+			label: "High (GPT Image)",
+			value: "high",
+		},
+	)
+
+	var countOptions []MenuOption
+	for n := 1; n <= openai.MaxImageCount; n++ {
+		countOptions = append(countOptions, MenuOption{
+			label: fmt.Sprintf("%d images", n),
+			value: strconv.Itoa(n),
+		})
+	}
+	countMenu := newMenu("Images per prompt",
+		map[string]interface{}{
+			"value":     "0",
+			"kind":      PicCountKind,
+			"sessionId": *sessionID,
+			"msgId":     *sessionID,
+		},
+		countOptions...,
 	)
 
 	styleMenu := newMenu("Style",
@@ -399,8 +572,26 @@ func withPicResolutionBtn(sessionID *string) larkcard.
 		},
 	)
 
+	enhanceMenu := newMenu("Prompt enhancement",
+		map[string]interface{}{
+			"value":     "0",
+			"kind":      PicEnhanceKind,
+			"sessionId": *sessionID,
+			"msgId":     *sessionID,
+		},
+		MenuOption{
+			label: "Enhance prompts",
+			value: "on",
+		},
+		MenuOption{
+			label: "Send prompts as written",
+			value: "off",
+		},
+	)
+
 	actions := larkcard.NewMessageCardAction().
-		Actions([]larkcard.MessageCardActionElement{resolutionMenu, styleMenu}).
+		Actions([]larkcard.MessageCardActionElement{modelMenu, resolutionMenu,
+			styleMenu, qualityMenu, countMenu, enhanceMenu}).
 		Layout(larkcard.MessageCardActionLayoutFlow.Ptr()).
 		Build()
 	return actions
@@ -512,7 +703,9 @@ func withAIModeBtn(sessionID *string, aiModeStrs []string) larkcard.MessageCardE
 	return actions
 }
 
-func replyMsg(ctx context.Context, msg string, msgId *string) error {
+func replyMsg(ctx context.Context, msg string, msgId *string) (err error) {
+	ctx, done := larkCall(ctx, "reply_message")
+	defer done(&err)
 	msg, i := processMessage(msg)
 	if i != nil {
 		return i
@@ -545,14 +738,98 @@ func replyMsg(ctx context.Context, msg string, msgId *string) error {
 	return nil
 }
 
-func uploadImage(base64Str string) (*string, error) {
+// uploadFile uploads data to Lark and returns its file key, duration is
+// the length in milliseconds of audio files
+func uploadFile(ctx context.Context, fileType string, name string,
+	duration int, data []byte) (_ string, err error) {
+	ctx, done := larkCall(ctx, "upload_file")
+	defer done(&err)
+	body := larkim.NewCreateFileReqBodyBuilder().
+		FileType(fileType).
+		FileName(name).
+		File(bytes.NewReader(data))
+	if duration > 0 {
+		body.Duration(duration)
+	}
+	resp, err := initialization.GetLarkClient().Im.File.Create(ctx,
+		larkim.NewCreateFileReqBuilder().Body(body.Build()).Build())
+	if err != nil {
+		return "", err
+	}
+	if !resp.Success() {
+		return "", errors.New(resp.Msg)
+	}
+	return *resp.Data.FileKey, nil
+}
+
+// replyContent replies to msgId with a message of msgType and returns the
+// id of the reply
+func replyContent(ctx context.Context, msgId *string, msgType string,
+	content string) (_ *string, err error) {
+	ctx, done := larkCall(ctx, "reply_message")
+	defer done(&err)
+	resp, err := initialization.GetLarkClient().Im.Message.Reply(ctx,
+		larkim.NewReplyMessageReqBuilder().
+			MessageId(*msgId).
+			Body(larkim.NewReplyMessageReqBodyBuilder().
+				MsgType(msgType).
+				Uuid(uuid.New().String()).
+				Content(content).
+				Build()).
+			Build())
+	if err != nil {
+		return nil, err
+	}
+	if !resp.Success() {
+		return nil, errors.New(resp.Msg)
+	}
+	return resp.Data.MessageId, nil
+}
+
+// replyFile uploads data as a file named name and replies with it
+func replyFile(ctx context.Context, msgId *string, name string,
+	data []byte) error {
+	fileKey, err := uploadFile(ctx, larkim.FileTypeStream, name, 0, data)
+	if err != nil {
+		return err
+	}
+	msgFile := larkim.MessageFile{FileKey: fileKey}
+	content, err := msgFile.String()
+	if err != nil {
+		return err
+	}
+	_, err = replyContent(ctx, msgId, larkim.MsgTypeFile, content)
+	return err
+}
+
+// replyAudio uploads Ogg Opus speech and replies with it as a voice
+// message, returning the id of the reply
+func replyAudio(ctx context.Context, msgId *string, name string,
+	duration time.Duration, data []byte) (*string, error) {
+	fileKey, err := uploadFile(ctx, larkim.FileTypeOpus, name,
+		int(duration/time.Millisecond), data)
+	if err != nil {
+		return nil, err
+	}
+	msgAudio := larkim.MessageAudio{FileKey: fileKey}
+	content, err := msgAudio.String()
+	if err != nil {
+		return nil, err
+	}
+	return replyContent(ctx, msgId, larkim.MsgTypeAudio, content)
+}
+
+func uploadImage(ctx context.Context, base64Str string) (_ *string,
+	err error) {
+	ctx, done := larkCall(ctx, "upload_image")
+	defer done(&err)
 	imageBytes, err := base64.StdEncoding.DecodeString(base64Str)
 	if err != nil {
 		fmt.Println(err)
 		return nil, err
 	}
 	client := initialization.GetLarkClient()
-	resp, err := client.Im.Image.Create(context.Background(),
+	resp, err := client.Im.Image.Create(ctx,
 		larkim.NewCreateImageReqBuilder().
 			Body(larkim.NewCreateImageReqBodyBuilder().
 				ImageType(larkim.ImageTypeMessage).
@@ -575,7 +852,9 @@ func uploadImage(base64Str string) (*string, error) {
 }
 
 func replyImage(ctx context.Context, ImageKey *string,
-	msgId *string) error {
+	msgId *string) (err error) {
+	ctx, done := larkCall(ctx, "reply_message")
+	defer done(&err)
 	//fmt.Println("sendMsg", ImageKey, msgId)
 
 	msgImage := larkim.MessageImage{ImageKey: *ImageKey}
@@ -611,7 +890,7 @@ func replyImage(ctx context.Context, ImageKey *string,
 
 func replayImageCardByBase64(ctx context.Context, base64Str string,
 	msgId *string, sessionId *string, question string) error {
-	imageKey, err := uploadImage(base64Str)
+	imageKey, err := uploadImage(ctx, base64Str)
 	if err != nil {
 		return err
 	}
@@ -627,7 +906,7 @@ func replayImageCardByBase64(ctx context.Context, base64Str string,
 
 func replayImagePlainByBase64(ctx context.Context, base64Str string,
 	msgId *string) error {
-	imageKey, err := uploadImage(base64Str)
+	imageKey, err := uploadImage(ctx, base64Str)
 	if err != nil {
 		return err
 	}
@@ -643,7 +922,7 @@ func replayImagePlainByBase64(ctx context.Context, base64Str string,
 
 func replayVariantImageByBase64(ctx context.Context, base64Str string,
 	msgId *string, sessionId *string) error {
-	imageKey, err := uploadImage(base64Str)
+	imageKey, err := uploadImage(ctx, base64Str)
 	if err != nil {
 		return err
 	}
@@ -657,7 +936,9 @@ func replayVariantImageByBase64(ctx context.Context, base64Str string,
 	return nil
 }
 
-func sendMsg(ctx context.Context, msg string, chatId *string) error {
+func sendMsg(ctx context.Context, msg string, chatId *string) (err error) {
+	ctx, done := larkCall(ctx, "create_message")
+	defer done(&err)
 	//fmt.Println("sendMsg", msg, chatId)
 	msg, i := processMessage(msg)
 	if i != nil {
@@ -693,6 +974,35 @@ func sendMsg(ctx context.Context, msg string, chatId *string) error {
 	return nil
 }
 
+// sendMsgToUser sends msg to the user with openId in their chat with the
+// bot, it returns the message sent
+func sendMsgToUser(ctx context.Context, msg string, openId string) (
+	message *larkim.CreateMessageRespData, err error) {
+	ctx, done := larkCall(ctx, "create_message")
+	defer done(&err)
+	msg, err = processMessage(msg)
+	if err != nil {
+		return nil, err
+	}
+	client := initialization.GetLarkClient()
+	resp, err := client.Im.Message.Create(ctx, larkim.NewCreateMessageReqBuilder().
+		ReceiveIdType(larkim.ReceiveIdTypeOpenId).
+		Body(larkim.NewCreateMessageReqBodyBuilder().
+			MsgType(larkim.MsgTypeText).
+			ReceiveId(openId).
+			Content(larkim.NewTextMsgBuilder().Text(msg).Build()).
+			Uuid(uuid.New().String()).
+			Build()).
+		Build())
+	if err != nil {
+		return nil, err
+	}
+	if !resp.Success() {
+		return nil, errors.New(resp.Msg)
+	}
+	return resp.Data, nil
+}
+
 func sendClearCacheCheckCard(ctx context.Context,
 	sessionId *string, msgId *string) {
 	newCard, _ := newSendCard(
@@ -712,6 +1022,29 @@ func sendSystemInstructionCard(ctx context.Context,
 	replyCard(ctx, msgId, newCard)
 }
 
+func sendDocumentCard(ctx context.Context, sessionId *string,
+	msgId *string, name string, summary string, preview string,
+	truncated bool, knowledgeBase bool) {
+	note := "Reminder: Reply in this topic to ask questions about the document"
+	if truncated {
+		note = "The document is too long, only its beginning was read. " + note
+	}
+	elements := []larkcard.MessageCardElement{
+		withMainMd(fmt.Sprintf("**%s**\n%s", name, summary)),
+		withSplitLine(),
+		withMainText(preview),
+		withNote(note),
+	}
+	if knowledgeBase {
+		elements = append(elements, withKnowledgeBaseAddBtn(sessionId, msgId,
+			name))
+	}
+	newCard, _ := newSendCard(
+		withHeader("📄 Document Received", larkcard.TemplateBlue),
+		elements...)
+	replyCard(ctx, msgId, newCard)
+}
+
 func sendPicCreateInstructionCard(ctx context.Context,
 	sessionId *string, msgId *string) {
 	newCard, _ := newSendCard(
@@ -721,6 +1054,17 @@ func sendPicCreateInstructionCard(ctx context.Context,
 	replyCard(ctx, msgId, newCard)
 }
 
+func sendPicEditInstructionCard(ctx context.Context,
+	sessionId *string, msgId *string) {
+	newCard, _ := newSendCard(
+		withHeader("🖌️ Entered Image Edit Mode", larkcard.TemplateBlue),
+		withMainMd("Send one message with the image to edit and a description of the change. "+
+			"Add a second image as mask to choose what to repaint: its transparent areas are edited."),
+		withPicResolutionBtn(sessionId),
+		withNote("Without a mask the transparent areas of the image are edited. Images must be square PNG or JPEG under 4MB."))
+	replyCard(ctx, msgId, newCard)
+}
+
 func sendVisionInstructionCard(ctx context.Context,
 	sessionId *string, msgId *string) {
 	newCard, _ := newSendCard(
@@ -730,6 +1074,25 @@ func sendVisionInstructionCard(ctx context.Context,
 	replyCard(ctx, msgId, newCard)
 }
 
+// newVoiceReplyCard shows whether answers are read aloud in the session
+func newVoiceReplyCard(sessionId *string, on bool) (string, error) {
+	state := "off"
+	if on {
+		state = "on"
+	}
+	return newSendCard(
+		withHeader("🔊 Voice Reply", larkcard.TemplateBlue),
+		withMainMd(fmt.Sprintf("Voice replies are **%s**", state)),
+		withVoiceReplyBtn(sessionId),
+		withNote("When on, every answer is also sent as a voice message."))
+}
+
+func sendVoiceReplyCard(ctx context.Context,
+	sessionId *string, msgId *string, on bool) {
+	newCard, _ := newVoiceReplyCard(sessionId, on)
+	replyCard(ctx, msgId, newCard)
+}
+
 func sendPicModeCheckCard(ctx context.Context,
 	sessionId *string, msgId *string) {
 	newCard, _ := newSendCard(
@@ -750,86 +1113,140 @@ func sendVisionModeCheckCard(ctx context.Context,
 }
 
 func sendNewTopicCard(ctx context.Context,
-	sessionId *string, msgId *string, content string) {
+	sessionId *string, msgId *string, content string,
+	sources []string) (*string, error) {
+	elements := []larkcard.MessageCardElement{withMainText(content)}
+	if len(sources) > 0 {
+		elements = append(elements, withSources(sources))
+	}
+	elements = append(elements,
+		withNote("Reminder: Click the dialogue box to reply and maintain topic continuity"),
+		withRegenerateBtn(sessionId, msgId))
 	newCard, _ := newSendCard(
-		withHeader("Started New Topic", larkcard.TemplateBlue),
-		withMainText(content),
-		withNote("Reminder: Click the dialogue box to reply and maintain topic continuity"))
-	replyCard(ctx, msgId, newCard)
+		withHeader("Started New Topic", larkcard.TemplateBlue), elements...)
+	return replyCardWithBackId(ctx, msgId, newCard)
 }
 
 func sendOldTopicCard(ctx context.Context,
-	sessionId *string, msgId *string, content string) {
+	sessionId *string, msgId *string, content string,
+	sources []string) (*string, error) {
+	elements := []larkcard.MessageCardElement{withMainText(content)}
+	if len(sources) > 0 {
+		elements = append(elements, withSources(sources))
+	}
+	elements = append(elements,
+		withNote("Reminder: Click the dialogue box to reply and maintain topic continuity"),
+		withRegenerateBtn(sessionId, msgId))
 	newCard, _ := newSendCard(
-		withHeader("Contextual Topic", larkcard.TemplateBlue),
-		withMainText(content),
-		withNote("Reminder: Click the dialogue box to reply and maintain topic continuity"))
-	replyCard(ctx, msgId, newCard)
+		withHeader("Contextual Topic", larkcard.TemplateBlue), elements...)
+	return replyCardWithBackId(ctx, msgId, newCard)
 }
 
 func sendVisionTopicCard(ctx context.Context,
 	sessionId *string, msgId *string, content string) {
 	newCard, _ := newSendCard(
-		withHeader("🕵️ Image Analysis Result", larkcard.TemplateBlue),
+		withHeader(visionTopicTitle, larkcard.TemplateBlue),
 		withMainText(content),
 		withNote("Let the LLM analyze the image content with you~"))
 	replyCard(ctx, msgId, newCard)
 }
 
-func sendHelpCard(ctx context.Context,
-	sessionId *string, msgId *string) {
-	newCard, _ := newSendCard(
-		withHeader("🎒 Need Help?", larkcard.TemplateBlue),
+// sendHelpCard replies with the commands, described by elements
+func sendHelpCard(ctx context.Context, msgId *string,
+	elements []larkcard.MessageCardElement) {
+	elements = append([]larkcard.MessageCardElement{
 		withMainMd("**🤠 Hello! I'm an intelligent assistant based on OpenAI!**"),
 		withSplitLine(),
-		withMdAndExtraBtn(
-			"** 🆑 Clear Topic Context**\nReply with *clear* or */clear*",
-			newBtn("Clear Now", map[string]interface{}{
-				"value":     "1",
-				"kind":      ClearCardKind,
-				"chatType":  UserChatType,
-				"sessionId": *sessionId,
-			}, larkcard.MessageCardButtonTypeDanger)),
-		withSplitLine(),
-		withMainMd("🤖 **Divergent Mode Selection**\nReply with *ai mode* or */ai_mode*"),
-		withSplitLine(),
-		withMainMd("🛖 **Built-in Role List**\nReply with *roles* or */roles*"),
-		withSplitLine(),
-		withMainMd("🥷 **Role-Playing Mode**\nReply with *role play* or */system* + space + role info"),
-		withSplitLine(),
-		withMainMd("🎤 **AI Voice Chat**\nDirectly send voice messages in private chat mode"),
-		withSplitLine(),
-		withMainMd("🎨 **Image Creation Mode**\nReply with *picture* or */picture*"),
-		withSplitLine(),
-		withMainMd("🕵️ **Image Analysis Mode**\nReply with *vision* or */vision*"),
-		withSplitLine(),
-		withMainMd("🎰 **Token Balance Query**\nReply with *balance* or */balance*"),
-		withSplitLine(),
-		withMainMd("🔃️ **History Topic Restore** 🚧\nEnter topic reply details page, reply with *restore* or */reload*"),
-		withSplitLine(),
-		withMainMd("📤 **Export Topic Content** 🚧\nReply with *export* or */export*"),
-		withSplitLine(),
-		withMainMd("🎰 **Continuous Dialogue & Multi-Topic Mode**\nClick the dialogue box to reply and maintain topic continuity. Meanwhile, ask separately to start a new topic"),
-		withSplitLine(),
-		withMainMd("🎒 **Need More Help?**\nReply with *help* or */help*"),
-	)
+	}, elements...)
+	newCard, _ := newSendCard(
+		withHeader("🎒 Need Help?", larkcard.TemplateBlue),
+		elements...)
 	replyCard(ctx, msgId, newCard)
 }
 
 func sendImageCard(ctx context.Context, imageKey string,
-	msgId *string, sessionId *string, question string) error {
-	newCard, _ := newSimpleSendCard(
-		withImageDiv(imageKey),
+	msgId *string, sessionId *string, question string,
+	notes ...larkcard.MessageCardElement) error {
+	return sendImageCardWithMore(ctx, imageKey, msgId,
+		map[string]interface{}{
+			"value":     question,
+			"kind":      PicTextMoreKind,
+			"chatType":  UserChatType,
+			"msgId":     *msgId,
+			"sessionId": *sessionId,
+		}, notes...)
+}
+
+// sendImageCardWithMore replies with an image, notes about it and a "One
+// More" button sending more as its value
+func sendImageCardWithMore(ctx context.Context, imageKey string,
+	msgId *string, more map[string]interface{},
+	notes ...larkcard.MessageCardElement) error {
+	elements := append([]larkcard.MessageCardElement{withImageDiv(imageKey)},
+		notes...)
+	elements = append(elements,
 		withSplitLine(),
 		// One more
+		withOneBtn(newBtn("One More", more,
+			larkcard.MessageCardButtonTypePrimary)),
+	)
+	newCard, _ := newSimpleSendCard(elements...)
+	replyCard(ctx, msgId, newCard)
+	return nil
+}
+
+// withPromptNote shows the prompt an image was drawn from when it differs
+// from what the user wrote
+func withPromptNote(label, prompt, question string) []larkcard.MessageCardElement {
+	if prompt == "" || prompt == question {
+		return nil
+	}
+	return []larkcard.MessageCardElement{withNote(label + ": " + prompt)}
+}
+
+func sendEditImageCard(ctx context.Context, imageKey string,
+	msgId *string, sessionId *string, edit imageEdit) error {
+	return sendImageCardWithMore(ctx, imageKey, msgId,
+		map[string]interface{}{
+			"value":     edit.prompt,
+			"kind":      PicEditMoreKind,
+			"chatType":  UserChatType,
+			"msgId":     *msgId,
+			"sessionId": *sessionId,
+			"imageKey":  edit.imageKey,
+			"maskKey":   edit.maskKey,
+		})
+}
+
+// sendImageGalleryCard replies with the images generated for question,
+// each can be varied on its own
+func sendImageGalleryCard(ctx context.Context, imageKeys []string,
+	revisedPrompts []string, msgId *string, sessionId *string,
+	question string, notes ...larkcard.MessageCardElement) error {
+	elements := notes
+	for i, imageKey := range imageKeys {
+		elements = append(elements, withImageDiv(imageKey))
+		elements = append(elements, withPromptNote("📝 Revised prompt",
+			revisedPrompts[i], question)...)
+		elements = append(elements,
+			withOneBtn(newBtn(fmt.Sprintf("Vary #%d", i+1), map[string]interface{}{
+				"value":     imageKey,
+				"kind":      PicVarMoreKind,
+				"chatType":  UserChatType,
+				"msgId":     *msgId,
+				"sessionId": *sessionId,
+			}, larkcard.MessageCardButtonTypeDefault)))
+	}
+	elements = append(elements,
+		withSplitLine(),
 		withOneBtn(newBtn("One More", map[string]interface{}{
 			"value":     question,
 			"kind":      PicTextMoreKind,
 			"chatType":  UserChatType,
 			"msgId":     *msgId,
 			"sessionId": *sessionId,
-		}, larkcard.MessageCardButtonTypePrimary)),
-	)
+		}, larkcard.MessageCardButtonTypePrimary)))
+	newCard, _ := newSimpleSendCard(elements...)
 	replyCard(ctx, msgId, newCard)
 	return nil
 }
@@ -867,6 +1284,28 @@ func sendBalanceCard(ctx context.Context, msgId *string,
 	replyCard(ctx, msgId, newCard)
 }
 
+// newModerationCard tells why content was flagged, blocked content was
+// not sent or shown
+func newModerationCard(stage string, categories []string,
+	blocked bool) (string, error) {
+	title, color := "⚠️ Content Warning", larkcard.TemplateOrange
+	text := "This %s may break the usage policy, please keep it appropriate."
+	if blocked {
+		title, color = "🚫 Content Blocked", larkcard.TemplateRed
+		text = "This %s was blocked by the usage policy and not processed."
+	}
+	return newSendCard(
+		withHeader(title, color),
+		withMainMd(fmt.Sprintf(text, stage)),
+		withNote("Flagged for: "+strings.Join(categories, ", ")))
+}
+
+func sendModerationCard(ctx context.Context, msgId *string, stage string,
+	categories []string, blocked bool) {
+	newCard, _ := newModerationCard(stage, categories, blocked)
+	replyCard(ctx, msgId, newCard)
+}
+
 func SendRoleTagsCard(ctx context.Context,
 	sessionId *string, msgId *string, roleTags []string) {
 	newCard, _ := newSendCard(
@@ -897,19 +1336,25 @@ func SendAIModeListsCard(ctx context.Context,
 	replyCard(ctx, msgId, newCard)
 }
 
-func sendOnProcessCard(ctx context.Context,
-	sessionId *string, msgId *string, ifNewTopic bool) (*string,
-	error) {
-	var newCard string
+// Titles of the streamed answer cards
+const (
+	newTopicTitle     = "👻️ Started New Topic"
+	contextTopicTitle = "🔃️ Contextual Topic"
+	visionTopicTitle  = "🕵️ Image Analysis Result"
+)
+
+func topicCardTitle(ifNewTopic bool) string {
 	if ifNewTopic {
-		newCard, _ = newSendCard(
-			withHeader("👻️ Started New Topic", larkcard.TemplateBlue),
-			withNote("Thinking, please wait..."))
-	} else {
-		newCard, _ = newSendCard(
-			withHeader("🔃️ Contextual Topic", larkcard.TemplateBlue),
-			withNote("Thinking, please wait..."))
+		return newTopicTitle
 	}
+	return contextTopicTitle
+}
+
+func sendOnProcessCard(ctx context.Context,
+	msgId *string, title string) (*string, error) {
+	newCard, _ := newSendCard(
+		withHeader(title, larkcard.TemplateBlue),
+		withNote("Thinking, please wait..."))
 
 	id, err := replyCardWithBackId(ctx, msgId, newCard)
 	if err != nil {
@@ -918,45 +1363,32 @@ func sendOnProcessCard(ctx context.Context,
 	return id, nil
 }
 
+// Footnotes of the streamed answer cards
+const (
+	generatingNote = "Generating, please wait..."
+	completedNote  = "Completed, you can continue asking questions or choose other functions."
+	continuedNote  = "The answer continues in the next card."
+	stoppedNote    = "Generation stopped, you can continue asking questions or regenerate the answer."
+)
+
 func updateTextCard(ctx context.Context, msg string,
-	msgId *string, ifNewTopic bool) error {
-	var newCard string
-	if ifNewTopic {
-		newCard, _ = newSendCard(
-			withHeader("👻️ Started New Topic", larkcard.TemplateBlue),
-			withMainText(msg),
-			withNote("Generating, please wait..."))
-	} else {
-		newCard, _ = newSendCard(
-			withHeader("🔃️ Contextual Topic", larkcard.TemplateBlue),
-			withMainText(msg),
-			withNote("Generating, please wait..."))
-	}
-	err := PatchCard(ctx, msgId, newCard)
-	if err != nil {
-		return err
-	}
-	return nil
+	msgId *string, title string) error {
+	return updateStreamCard(ctx, msg, msgId, title, generatingNote)
+}
+
+func updateFinalCard(ctx context.Context, msg string,
+	msgId *string, title string) error {
+	return updateStreamCard(ctx, msg, msgId, title, completedNote)
 }
-func updateFinalCard(
-	ctx context.Context,
-	msg string,
-	msgId *string,
-	ifNewSession bool,
-) error {
-	var newCard string
-	if ifNewSession {
-		newCard, _ = newSendCard(
-			withHeader("👻️ Started New Topic", larkcard.TemplateBlue),
-			withMainText(msg),
-			withNote("Completed, you can continue asking questions or choose other functions."))
-	} else {
-		newCard, _ = newSendCard(
-			withHeader("🔃️ Contextual Topic", larkcard.TemplateBlue),
 
+func updateStreamCard(ctx context.Context, msg string,
+	msgId *string, title string, note string,
+	actions ...larkcard.MessageCardElement) error {
+	newCard, _ := newSendCard(
+		withHeader(title, larkcard.TemplateBlue),
+		append([]larkcard.MessageCardElement{
 			withMainText(msg),
-			withNote("Completed, you can continue asking questions or choose other functions."))
-	}
+			withNote(note)}, actions...)...)
 	err := PatchCard(ctx, msgId, newCard)
 	if err != nil {
 		return err
@@ -983,8 +1415,33 @@ func newSendCardWithOutHeader(
 	return cardContent, err
 }
 
+// recallMessage withdraws a message sent by the bot
+func recallMessage(ctx context.Context, msgId string) (err error) {
+	ctx, done := larkCall(ctx, "delete_message")
+	defer done(&err)
+	client := initialization.GetLarkClient()
+	resp, err := client.Im.Message.Delete(ctx, larkim.NewDeleteMessageReqBuilder().
+		MessageId(msgId).
+		Build())
+
+	// Handle errors
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	// Server-side error handling
+	if !resp.Success() {
+		fmt.Println(resp.Code, resp.Msg, resp.RequestId())
+		return errors.New(resp.Msg)
+	}
+	return nil
+}
+
 func PatchCard(ctx context.Context, msgId *string,
-	cardContent string) error {
+	cardContent string) (err error) {
+	ctx, done := larkCall(ctx, "patch_card")
+	defer done(&err)
 	//fmt.Println("sendMsg", msg, chatId)
 	client := initialization.GetLarkClient()
 	//content := larkim.NewTextMsgBuilder().
@@ -1017,7 +1474,9 @@ func PatchCard(ctx context.Context, msgId *string,
 func replyCardWithBackId(ctx context.Context,
 	msgId *string,
 	cardContent string,
-) (*string, error) {
+) (_ *string, err error) {
+	ctx, done := larkCall(ctx, "reply_card")
+	defer done(&err)
 	client := initialization.GetLarkClient()
 	resp, err := client.Im.Message.Reply(ctx, larkim.NewReplyMessageReqBuilder().
 		MessageId(*msgId).
@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"start-feishubot/initialization"
+	"start-feishubot/logger"
+	"start-feishubot/services/pii"
+)
+
+func newRedactor(config initialization.Config) *pii.Redactor {
+	if !config.PIIRedactionOn {
+		return nil
+	}
+	redactor, err := pii.New(pii.Options{
+		Kinds:        config.PIIKinds,
+		PatternsFile: config.PIIPatternsFile,
+		Restore:      config.PIIRestore,
+	})
+	if err != nil {
+		// redaction was asked for, running without it is not an option
+		logger.Fatalf("create redactor failed: %v", err)
+	}
+	return redactor
+}
+
+type RedactAction struct { /* Personal data and secrets */
+}
+
+// Execute masks the question before any action sends it to a provider.
+// The text as written is kept for the link actions, which read the links
+// in it
+func (*RedactAction) Execute(a *ActionInfo) bool {
+	a.info.unredacted = a.info.qParsed
+	a.info.qParsed = a.handler.redact(*a.info.sessionId, a.info.qParsed)
+	return true
+}
+
+// redact replaces personal data and secrets in text with placeholders
+func (m MessageHandler) redact(sessionId string, text string) string {
+	if m.redactor == nil {
+		return text
+	}
+	return m.redactor.Redact(sessionId, text)
+}
+
+// restore puts the values masked in a session back into an answer
+func (m MessageHandler) restore(sessionId string, text string) string {
+	if m.redactor == nil {
+		return text
+	}
+	return m.redactor.Restore(sessionId, text)
+}
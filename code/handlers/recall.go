@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"start-feishubot/logger"
+	"strings"
+
+	larkevent "github.com/larksuite/oapi-sdk-go/v3/event"
+	larkim "github.com/larksuite/oapi-sdk-go/v3/service/im/v1"
+)
+
+// MessageUpdatedEventType is sent when a user edits a message, the SDK has
+// no typed handler for it yet
+const MessageUpdatedEventType = "im.message.updated_v1"
+
+type messageUpdatedEvent struct {
+	Event struct {
+		MessageId   string `json:"message_id"`
+		MessageType string `json:"message_type"`
+		Content     string `json:"content"`
+	} `json:"event"`
+}
+
+// msgRecalledHandler drops the recalled question and its answer from the
+// session history
+func (m MessageHandler) msgRecalledHandler(ctx context.Context,
+	event *larkim.P2MessageRecalledV1) error {
+	msgId := *event.Event.MessageId
+	turn := m.replyCache.Get(msgId)
+	if turn == nil {
+		return nil
+	}
+	if m.sessionCache.RemoveTurn(turn.SessionId, msgId) {
+		logger.Debugf("removed recalled message %s from session %s",
+			msgId, turn.SessionId)
+	}
+	m.recallReplies(ctx, turn.ReplyIds)
+	m.replyCache.Clear(msgId)
+	return nil
+}
+
+// msgUpdatedHandler asks the edited question again in place of the old
+// one, the replies to the old question are recalled
+func (m MessageHandler) msgUpdatedHandler(ctx context.Context,
+	req *larkevent.EventReq) error {
+	var event messageUpdatedEvent
+	if err := decodeCustomizedEvent(req, m.config.FeishuAppEncryptKey,
+		&event); err != nil {
+		return err
+	}
+	msgId := event.Event.MessageId
+	turn := m.replyCache.Get(msgId)
+	if turn == nil {
+		return nil
+	}
+	m.recallReplies(ctx, turn.ReplyIds)
+	// the answer to the edited question adds its own replies
+	m.replyCache.Clear(msgId)
+
+	content := m.redact(turn.SessionId, strings.Trim(parseContent(
+		event.Event.Content, event.Event.MessageType), " "))
+	if !m.moderate(ctx, stageInput, content, &msgId) {
+		m.sessionCache.RemoveTurn(turn.SessionId, msgId)
+		return nil
+	}
+	if !m.sessionCache.EditTurn(turn.SessionId, msgId, content) {
+		return nil
+	}
+	logger.Debugf("rewrote edited message %s in session %s",
+		msgId, turn.SessionId)
+	go m.replyTurn(ctx, turn.SessionId, msgId)
+	return nil
+}
+
+func (m MessageHandler) recallReplies(ctx context.Context, replyIds []string) {
+	if !m.config.RecallBotReplies {
+		return
+	}
+	for _, replyId := range replyIds {
+		if err := recallMessage(ctx, replyId); err != nil {
+			logger.Errorf("recall reply %s failed: %v", replyId, err)
+		}
+	}
+}
+
+// decodeCustomizedEvent unmarshals the body of an event registered with
+// OnCustomizedEvent, which the dispatcher hands over still encrypted
+func decodeCustomizedEvent(req *larkevent.EventReq, encryptKey string,
+	event interface{}) error {
+	body := req.Body
+	if encryptKey != "" {
+		var encrypted larkevent.EventEncryptMsg
+		if err := json.Unmarshal(body, &encrypted); err != nil {
+			return fmt.Errorf("event unmarshal failed: %w", err)
+		}
+		decrypted, err := larkevent.EventDecrypt(encrypted.Encrypt, encryptKey)
+		if err != nil {
+			return fmt.Errorf("event decrypt failed: %w", err)
+		}
+		body = decrypted
+	}
+	return json.Unmarshal(body, event)
+}
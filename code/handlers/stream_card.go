@@ -0,0 +1,332 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"start-feishubot/logger"
+
+	larkcard "github.com/larksuite/oapi-sdk-go/v3/card"
+)
+
+const (
+	// Lark limits how often a single message can be patched, the interval
+	// grows when a patch fails and shrinks back while patches succeed
+	minPatchInterval = 700 * time.Millisecond
+	maxPatchInterval = 5 * time.Second
+	noContentTimeout = 10 * time.Second
+	// Cards are limited to 30KB of JSON, longer answers continue in a
+	// new card
+	maxCardTextBytes = 20000
+)
+
+var errNoContentTimeout = errors.New("request timeout")
+
+// generations holds the cancel functions of in-flight streams, keyed by the
+// id of the message being answered
+var generations sync.Map
+
+// stopGeneration cancels the stream answering msgId, the card keeps the
+// partial answer
+func stopGeneration(msgId string) bool {
+	cancel, ok := generations.Load(msgId)
+	if !ok {
+		return false
+	}
+	cancel.(context.CancelFunc)()
+	return true
+}
+
+// streamCardClient sends and patches the cards of a streamed answer
+type streamCardClient interface {
+	reply(ctx context.Context, msgId *string, title string) (*string, error)
+	patch(ctx context.Context, cardId *string, title, msg, note string,
+		actions ...larkcard.MessageCardElement) error
+}
+
+type larkStreamCardClient struct{}
+
+func (larkStreamCardClient) reply(ctx context.Context, msgId *string,
+	title string) (*string, error) {
+	return sendOnProcessCard(ctx, msgId, title)
+}
+
+func (larkStreamCardClient) patch(ctx context.Context, cardId *string,
+	title, msg, note string, actions ...larkcard.MessageCardElement) error {
+	return updateStreamCard(ctx, msg, cardId, title, note, actions...)
+}
+
+// streamCard is an answer card that is patched while the reply is generated.
+// Deltas are only touched by the goroutine running Stream, the generator
+// blocks on the unbuffered stream while a patch is in flight
+type streamCard struct {
+	ctx       context.Context
+	client    streamCardClient
+	sessionId *string
+	msgId     *string
+	title     string
+	// regenerable cards offer to regenerate the answer once it completes
+	regenerable bool
+	sources     []string // knowledge base documents cited below the answer
+	// display turns the answer into the text shown, such as by restoring
+	// redacted values, nil shows it as generated
+	display func(text string) string
+	// hold keeps the answer off the card until release, so an answer that
+	// is checked once complete is never shown before it passes
+	hold     bool
+	held     string // the note release ends the card with
+	cardId   *string
+	cardIds  []string // every card of the answer, continuations included
+	answer   strings.Builder
+	offset   int // start of the text shown on the current card
+	rendered string
+	interval time.Duration
+	timeout  time.Duration
+}
+
+func newStreamCard(ctx context.Context, sessionId *string, msgId *string,
+	title string) (*streamCard, error) {
+	return newStreamCardWithClient(ctx, larkStreamCardClient{}, sessionId,
+		msgId, title)
+}
+
+func newStreamCardWithClient(ctx context.Context, client streamCardClient,
+	sessionId *string, msgId *string, title string) (*streamCard, error) {
+	cardId, err := client.reply(ctx, msgId, title)
+	if err != nil {
+		return nil, err
+	}
+	return &streamCard{
+		ctx:       ctx,
+		client:    client,
+		sessionId: sessionId,
+		msgId:     msgId,
+		title:     title,
+		cardId:    cardId,
+		cardIds:   []string{*cardId},
+		interval:  minPatchInterval,
+		timeout:   noContentTimeout,
+	}, nil
+}
+
+// Stream runs generate and renders the tokens it sends into the card.
+// The full answer is returned once generation completes, or the partial
+// answer when the user stops it
+func (c *streamCard) Stream(generate func(ctx context.Context,
+	responseStream chan string) error) (string, error) {
+	ctx, cancel := context.WithCancel(c.ctx)
+	defer cancel()
+	generations.Store(*c.msgId, cancel)
+	defer generations.Delete(*c.msgId)
+
+	responseStream := make(chan string)
+	result := make(chan error, 1)
+	go func() {
+		defer func() {
+			if err := recover(); err != nil {
+				result <- fmt.Errorf("stream panic: %v", err)
+			}
+		}()
+		result <- generate(ctx, responseStream)
+	}()
+
+	timeout := time.NewTimer(c.timeout)
+	defer timeout.Stop()
+	patchTimer := time.NewTimer(c.interval)
+	defer patchTimer.Stop()
+
+	received := false
+	for {
+		select {
+		case delta := <-responseStream:
+			received = true
+			timeout.Stop()
+			c.answer.WriteString(delta)
+		case <-patchTimer.C:
+			if !c.hold {
+				c.render(generatingNote)
+			}
+			patchTimer.Reset(c.interval)
+		case <-timeout.C:
+			// the timer may have fired while the first delta was received
+			if received {
+				continue
+			}
+			cancel()
+			go drain(responseStream, result)
+			c.fail("Request timeout")
+			return "", errNoContentTimeout
+		case err := <-result:
+			if err != nil && ctx.Err() != nil && c.ctx.Err() == nil {
+				c.finish(stoppedNote)
+				return c.answer.String(), nil
+			}
+			if err != nil {
+				c.fail("Chat failed")
+				return "", err
+			}
+			// The answer is returned even if the final patch fails, callers
+			// still keep it in the conversation history
+			c.finish(completedNote)
+			return c.answer.String(), nil
+		}
+	}
+}
+
+// finish ends the card with note once generation is over, a held answer
+// waits for release
+func (c *streamCard) finish(note string) {
+	if c.hold {
+		c.held = note
+		return
+	}
+	c.render(note)
+}
+
+// release shows the held answer, it does nothing unless the answer was
+// held
+func (c *streamCard) release() {
+	if c.held == "" {
+		return
+	}
+	note := c.held
+	c.held = ""
+	c.render(note)
+}
+
+// render patches the current card with the answer, moving the overflow of
+// long answers to continuation cards. While generating, a failed call is
+// retried on the next patch, otherwise the current card is still ended
+// with note so it does not stay generating
+func (c *streamCard) render(note string) {
+	for c.answer.Len()-c.offset > maxCardTextBytes {
+		text := c.answer.String()[c.offset:]
+		cut := splitIndex(text, maxCardTextBytes)
+		if err := c.patch(text[:cut], continuedNote); err != nil {
+			c.settle(text[:cut], note)
+			return
+		}
+		cardId, err := c.client.reply(c.ctx, c.msgId, c.title+" (continued)")
+		if err != nil {
+			logger.Ctx(c.ctx).Errorf("send continuation card failed: %v", err)
+			c.settle(text[:cut], note)
+			return
+		}
+		c.cardId = cardId
+		c.cardIds = append(c.cardIds, *cardId)
+		c.offset += cut
+		c.rendered = ""
+	}
+
+	text := c.answer.String()[c.offset:]
+	if note != generatingNote {
+		c.settle(text, note)
+	} else if text != "" && text != c.rendered {
+		c.patch(text, note)
+	}
+}
+
+// settle ends the current card with text and note. While generating it
+// does nothing, the next patch retries; a final patch that fails leaves
+// the card failed rather than generating
+func (c *streamCard) settle(text, note string) {
+	if note == generatingNote {
+		return
+	}
+	if err := c.patch(text, note); err != nil {
+		c.fail("Failed to show the answer, please regenerate it")
+	}
+}
+
+// actions are the buttons shown below the answer
+func (c *streamCard) actions(note string) []larkcard.MessageCardElement {
+	if note == generatingNote {
+		return []larkcard.MessageCardElement{withStopBtn(c.sessionId, c.msgId)}
+	}
+	if note == continuedNote {
+		return nil
+	}
+	var actions []larkcard.MessageCardElement
+	if len(c.sources) > 0 {
+		actions = append(actions, withSources(c.sources))
+	}
+	if c.regenerable {
+		actions = append(actions, withRegenerateBtn(c.sessionId, c.msgId))
+	}
+	return actions
+}
+
+func (c *streamCard) patch(text, note string) error {
+	shown := text
+	if c.display != nil {
+		shown = c.display(text)
+	}
+	err := c.client.patch(c.ctx, c.cardId, c.title, shown, note,
+		c.actions(note)...)
+	if err != nil {
+		logger.Ctx(c.ctx).Errorf("patch stream card failed: %v", err)
+		c.interval *= 2
+		if c.interval > maxPatchInterval {
+			c.interval = maxPatchInterval
+		}
+		return err
+	}
+	c.rendered = text
+	c.interval -= c.interval / 4
+	if c.interval < minPatchInterval {
+		c.interval = minPatchInterval
+	}
+	return nil
+}
+
+func (c *streamCard) fail(msg string) {
+	c.sources = nil
+	if err := c.client.patch(c.ctx, c.cardId, c.title, msg,
+		completedNote, c.actions(completedNote)...); err != nil {
+		logger.Ctx(c.ctx).Errorf("patch stream card failed: %v", err)
+	}
+}
+
+// withhold replaces the answer on every card it spans with msg, for an
+// answer that must not stay visible
+func (c *streamCard) withhold(msg string) {
+	for _, cardId := range c.cardIds {
+		cardId := cardId
+		if err := c.client.patch(c.ctx, &cardId, c.title, msg,
+			completedNote); err != nil {
+			logger.Ctx(c.ctx).Errorf("patch stream card failed: %v", err)
+		}
+	}
+}
+
+// splitIndex finds where to cut text so the head fits in limit bytes,
+// preferring a line break and never splitting a rune
+func splitIndex(text string, limit int) int {
+	if len(text) <= limit {
+		return len(text)
+	}
+	if i := strings.LastIndexByte(text[:limit], '\n'); i > limit/2 {
+		return i + 1
+	}
+	cut := limit
+	for cut > 0 && !utf8.RuneStart(text[cut]) {
+		cut--
+	}
+	return cut
+}
+
+// drain unblocks a generator that ignores cancellation until it returns
+func drain(responseStream chan string, result chan error) {
+	for {
+		select {
+		case <-responseStream:
+		case <-result:
+			return
+		}
+	}
+}
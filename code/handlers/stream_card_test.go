@@ -0,0 +1,230 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	larkcard "github.com/larksuite/oapi-sdk-go/v3/card"
+)
+
+type fakeStreamCardClient struct {
+	mu      sync.Mutex
+	cards   []string
+	notes   []string
+	patches int
+	failAt  int
+	// failReply fails sending continuation cards
+	failReply bool
+}
+
+func (f *fakeStreamCardClient) reply(ctx context.Context, msgId *string,
+	title string) (*string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.failReply && len(f.cards) > 0 {
+		return nil, errors.New("send failed")
+	}
+	f.cards = append(f.cards, "")
+	f.notes = append(f.notes, "")
+	id := string(rune('0' + len(f.cards) - 1))
+	return &id, nil
+}
+
+func (f *fakeStreamCardClient) patch(ctx context.Context, cardId *string,
+	title, msg, note string, actions ...larkcard.MessageCardElement) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.patches++
+	if f.failAt == f.patches {
+		return errors.New("frequency limit")
+	}
+	i := int((*cardId)[0] - '0')
+	f.cards[i] = msg
+	f.notes[i] = note
+	return nil
+}
+
+func TestStreamCardSplitsLongAnswers(t *testing.T) {
+	client := &fakeStreamCardClient{}
+	sessionId, msgId := "om_root", "om_test"
+	card, err := newStreamCardWithClient(context.Background(), client,
+		&sessionId, &msgId, "title")
+	if err != nil {
+		t.Fatal(err)
+	}
+	line := strings.Repeat("x", 99) + "\n"
+	answer, err := card.Stream(func(ctx context.Context,
+		responseStream chan string) error {
+		for i := 0; i < 450; i++ {
+			responseStream <- line
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if answer != strings.Repeat(line, 450) {
+		t.Errorf("unexpected answer length %d", len(answer))
+	}
+	if len(client.cards) != 3 {
+		t.Fatalf("expected 3 cards, got %d", len(client.cards))
+	}
+	if strings.Join(client.cards, "") != answer {
+		t.Errorf("cards do not add up to the answer")
+	}
+	for i, card := range client.cards {
+		if len(card) > maxCardTextBytes {
+			t.Errorf("card %d exceeds the size limit: %d", i, len(card))
+		}
+	}
+	if client.notes[0] != continuedNote || client.notes[2] != completedNote {
+		t.Errorf("unexpected notes %v", client.notes)
+	}
+}
+
+func TestStreamCardEndsWhenContinuationFails(t *testing.T) {
+	client := &fakeStreamCardClient{failReply: true}
+	sessionId, msgId := "om_root", "om_test"
+	card, _ := newStreamCardWithClient(context.Background(), client,
+		&sessionId, &msgId, "title")
+	card.answer.WriteString(strings.Repeat("x", maxCardTextBytes+10))
+	card.render(completedNote)
+	if len(client.cards) != 1 || client.notes[0] != completedNote {
+		t.Errorf("card should end completed, got notes %v", client.notes)
+	}
+
+	client = &fakeStreamCardClient{failAt: 1}
+	card, _ = newStreamCardWithClient(context.Background(), client,
+		&sessionId, &msgId, "title")
+	card.answer.WriteString("answer")
+	card.render(completedNote)
+	if client.notes[0] != completedNote || !strings.HasPrefix(client.cards[0], "Failed") {
+		t.Errorf("card should end failed, got %q, %q", client.cards[0],
+			client.notes[0])
+	}
+}
+
+func TestStreamCardHoldsAnswerUntilReleased(t *testing.T) {
+	client := &fakeStreamCardClient{}
+	sessionId, msgId := "om_root", "om_test"
+	card, _ := newStreamCardWithClient(context.Background(), client,
+		&sessionId, &msgId, "title")
+	card.hold = true
+	card.interval = time.Millisecond
+	answer, err := card.Stream(func(ctx context.Context,
+		responseStream chan string) error {
+		for i := 0; i < 5; i++ {
+			responseStream <- "flagged "
+			time.Sleep(5 * time.Millisecond)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.patches != 0 {
+		t.Fatalf("held answer was patched %d times: %q", client.patches,
+			client.cards)
+	}
+	card.release()
+	if client.cards[0] != answer || client.notes[0] != completedNote {
+		t.Errorf("released card = %q, %q, want the answer completed",
+			client.cards[0], client.notes[0])
+	}
+}
+
+func TestStreamCardNoContentTimeout(t *testing.T) {
+	client := &fakeStreamCardClient{}
+	sessionId, msgId := "om_root", "om_test"
+	card, _ := newStreamCardWithClient(context.Background(), client,
+		&sessionId, &msgId, "title")
+	card.timeout = 50 * time.Millisecond
+	_, err := card.Stream(func(ctx context.Context,
+		responseStream chan string) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	if err != errNoContentTimeout {
+		t.Errorf("expected timeout error, got %v", err)
+	}
+	if client.cards[0] != "Request timeout" {
+		t.Errorf("unexpected card content %q", client.cards[0])
+	}
+}
+
+func TestStreamCardBacksOffOnPatchErrors(t *testing.T) {
+	client := &fakeStreamCardClient{failAt: 1}
+	sessionId, msgId := "om_root", "om_test"
+	card, _ := newStreamCardWithClient(context.Background(), client,
+		&sessionId, &msgId, "title")
+	card.answer.WriteString("hello")
+	card.render(generatingNote)
+	if card.interval != 2*minPatchInterval {
+		t.Errorf("interval should double after a failed patch, got %v",
+			card.interval)
+	}
+	card.answer.WriteString(" world")
+	card.render(generatingNote)
+	if card.interval >= 2*minPatchInterval {
+		t.Errorf("interval should shrink after a patch succeeds, got %v",
+			card.interval)
+	}
+}
+
+func TestStreamCardStop(t *testing.T) {
+	client := &fakeStreamCardClient{}
+	sessionId, msgId := "om_root", "om_stop"
+	card, _ := newStreamCardWithClient(context.Background(), client,
+		&sessionId, &msgId, "title")
+	answer, err := card.Stream(func(ctx context.Context,
+		responseStream chan string) error {
+		responseStream <- "partial"
+		if !stopGeneration(msgId) {
+			t.Error("stream was not registered")
+		}
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	if err != nil || answer != "partial" {
+		t.Errorf("expected the partial answer, got %q, %v", answer, err)
+	}
+	if client.notes[0] != stoppedNote {
+		t.Errorf("unexpected note %q", client.notes[0])
+	}
+	if stopGeneration(msgId) {
+		t.Error("finished stream is still registered")
+	}
+}
+
+func TestStreamCardWithholdsEveryCard(t *testing.T) {
+	client := &fakeStreamCardClient{}
+	sessionId, msgId := "om_root", "om_withheld"
+	card, err := newStreamCardWithClient(context.Background(), client,
+		&sessionId, &msgId, "title")
+	if err != nil {
+		t.Fatal(err)
+	}
+	line := strings.Repeat("x", 99) + "\n"
+	if _, err := card.Stream(func(ctx context.Context,
+		responseStream chan string) error {
+		for i := 0; i < 300; i++ {
+			responseStream <- line
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	card.withhold(withheldNotice)
+	if len(client.cards) < 2 {
+		t.Fatalf("expected a continuation card, got %d cards", len(client.cards))
+	}
+	for i, text := range client.cards {
+		if text != withheldNotice {
+			t.Errorf("card %d still shows %d bytes of the answer", i, len(text))
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"start-feishubot/initialization"
+	"start-feishubot/services/openai"
+	"start-feishubot/utils/audio"
+
+	larkim "github.com/larksuite/oapi-sdk-go/v3/service/im/v1"
+)
+
+const (
+	// the transcription endpoint accepts files up to 25MB
+	maxTranscriptionBytes = 20 << 20
+	// tail of the previous segment given as prompt for the next one
+	transcriptPromptRunes = 200
+)
+
+// transcript is the text of a recording stitched from its segments
+type transcript struct {
+	Text     string
+	Segments []openai.TranscriptSegment
+}
+
+// transcribe downloads the audio of msgId and transcribes it, recordings
+// too large for a single request are split and their transcripts stitched
+func (m MessageHandler) transcribe(ctx context.Context, msgId string,
+	fileKey string, language string) (*transcript, error) {
+	data, err := downloadResource(ctx, msgId, fileKey, "file",
+		maxAudioBytes)
+	if err != nil {
+		return nil, err
+	}
+	pcm, err := audio.Decode(bytes.NewReader(data),
+		audio.TranscriptionSampleRate)
+	if err != nil {
+		return nil, fmt.Errorf("decode audio failed: %w", err)
+	}
+
+	var parts []*openai.AudioToTextResponseBody
+	var offsets []float64
+	offset, prompt := 0.0, ""
+	segments := audio.SplitPCM(pcm, audio.TranscriptionSampleRate, maxTranscriptionBytes-44)
+	gpt := m.gpt.WithContext(ctx)
+	for i, segment := range segments {
+		part, err := gpt.Transcribe(fmt.Sprintf("%s-%d.wav", fileKey, i),
+			bytes.NewReader(audio.EncodeWav(segment, audio.TranscriptionSampleRate)),
+			language, prompt)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, part)
+		offsets = append(offsets, offset)
+		offset += audio.Duration(segment, audio.TranscriptionSampleRate)
+		prompt = lastRunes(part.Text, transcriptPromptRunes)
+	}
+	return stitchTranscripts(parts, offsets), nil
+}
+
+// stitchTranscripts joins the transcripts of consecutive segments, moving
+// the segment times by the offset of the part they belong to
+func stitchTranscripts(parts []*openai.AudioToTextResponseBody,
+	offsets []float64) *transcript {
+	t := &transcript{}
+	var texts []string
+	for i, part := range parts {
+		if text := strings.TrimSpace(part.Text); text != "" {
+			texts = append(texts, text)
+		}
+		for _, segment := range part.Segments {
+			segment.Start += offsets[i]
+			segment.End += offsets[i]
+			segment.Text = strings.TrimSpace(segment.Text)
+			t.Segments = append(t.Segments, segment)
+		}
+	}
+	t.Text = strings.Join(texts, " ")
+	return t
+}
+
+// timestamped renders the transcript with the time range of each segment
+func (t *transcript) timestamped() string {
+	if len(t.Segments) == 0 {
+		return t.Text
+	}
+	var text strings.Builder
+	for _, segment := range t.Segments {
+		fmt.Fprintf(&text, "[%s - %s] %s\n", formatTimestamp(segment.Start),
+			formatTimestamp(segment.End), segment.Text)
+	}
+	return text.String()
+}
+
+func formatTimestamp(seconds float64) string {
+	total := int(seconds)
+	return fmt.Sprintf("%02d:%02d:%02d", total/3600, total/60%60, total%60)
+}
+
+func lastRunes(text string, n int) string {
+	runes := []rune(text)
+	if len(runes) <= n {
+		return text
+	}
+	return string(runes[len(runes)-n:])
+}
+
+// exportTranscript replies with the timestamped transcript as a text file
+func exportTranscript(ctx context.Context, msgId *string, fileKey string,
+	t *transcript) error {
+	return replyFile(ctx, msgId, fmt.Sprintf("transcript-%s.txt", fileKey),
+		[]byte(t.timestamped()))
+}
+
+// repliedAudio returns the file key of the audio message parentId, or an
+// empty key when the parent is not an audio message
+func repliedAudio(ctx context.Context, parentId string) (_ string, err error) {
+	ctx, done := larkCall(ctx, "get_message")
+	defer done(&err)
+	req := larkim.NewGetMessageReqBuilder().MessageId(parentId).Build()
+	resp, err := initialization.GetLarkClient().Im.Message.Get(ctx, req)
+	if err != nil {
+		return "", err
+	}
+	if !resp.Success() {
+		return "", errors.New(resp.Msg)
+	}
+	if len(resp.Data.Items) == 0 {
+		return "", nil
+	}
+	parent := resp.Data.Items[0]
+	if parent.MsgType == nil || *parent.MsgType != larkim.MsgTypeAudio ||
+		parent.Body == nil || parent.Body.Content == nil {
+		return "", nil
+	}
+	var content struct {
+		FileKey string `json:"file_key"`
+	}
+	if err := json.Unmarshal([]byte(*parent.Body.Content), &content); err != nil {
+		return "", err
+	}
+	return content.FileKey, nil
+}
+
+// transcribeCommand holds the options of "/transcribe [language]
+// [timestamps]", ok is false when text is not the command
+type transcribeCommand struct {
+	language   string
+	timestamps bool
+}
+
+func parseTranscribeCommand(text string) (transcribeCommand, bool) {
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return transcribeCommand{}, true
+	}
+	if fields[0] != "/transcribe" && fields[0] != "transcribe" {
+		return transcribeCommand{}, false
+	}
+	var cmd transcribeCommand
+	for _, field := range fields[1:] {
+		switch strings.ToLower(field) {
+		case "timestamps", "timestamp", "srt":
+			cmd.timestamps = true
+		default:
+			if len(field) == 2 {
+				cmd.language = strings.ToLower(field)
+			}
+		}
+	}
+	return cmd, true
+}
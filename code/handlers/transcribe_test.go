@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"testing"
+
+	"start-feishubot/services/openai"
+)
+
+func TestStitchTranscripts(t *testing.T) {
+	parts := []*openai.AudioToTextResponseBody{
+		{Text: " hello there ", Segments: []openai.TranscriptSegment{
+			{Start: 0, End: 2.5, Text: " hello there"},
+		}},
+		{Text: "general kenobi", Segments: []openai.TranscriptSegment{
+			{Start: 1, End: 3, Text: "general kenobi"},
+		}},
+	}
+	got := stitchTranscripts(parts, []float64{0, 3600})
+	if got.Text != "hello there general kenobi" {
+		t.Errorf("text = %q", got.Text)
+	}
+	want := "[00:00:00 - 00:00:02] hello there\n" +
+		"[01:00:01 - 01:00:03] general kenobi\n"
+	if ts := got.timestamped(); ts != want {
+		t.Errorf("timestamped = %q, want %q", ts, want)
+	}
+}
+
+func TestParseTranscribeCommand(t *testing.T) {
+	tests := []struct {
+		text      string
+		isCommand bool
+		want      transcribeCommand
+	}{
+		{"", true, transcribeCommand{}},
+		{"/transcribe", true, transcribeCommand{}},
+		{"transcribe VI timestamps", true,
+			transcribeCommand{language: "vi", timestamps: true}},
+		{"what is this about?", false, transcribeCommand{}},
+	}
+	for _, tt := range tests {
+		got, isCommand := parseTranscribeCommand(tt.text)
+		if isCommand != tt.isCommand || got != tt.want {
+			t.Errorf("parseTranscribeCommand(%q) = %+v, %v", tt.text, got,
+				isCommand)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"start-feishubot/logger"
+	"start-feishubot/utils/audio"
+
+	larkcard "github.com/larksuite/oapi-sdk-go/v3/card"
+)
+
+// showVoiceReply lets the user turn voice replies on or off
+func showVoiceReply(a *ActionInfo, _ commandArgs) {
+	sendVoiceReplyCard(*a.ctx, a.info.sessionId, a.info.msgId,
+		a.handler.sessionCache.GetVoiceReply(*a.info.sessionId))
+}
+
+// NewVoiceReplyHandler turns voice replies on or off from the voice reply
+// card
+func NewVoiceReplyHandler(cardMsg CardMsg,
+	m MessageHandler) CardHandlerFunc {
+	return func(ctx context.Context, cardAction *larkcard.CardAction) (interface{}, error) {
+		if cardMsg.Kind == VoiceReplyKind {
+			on := cardMsg.Value == "1"
+			m.sessionCache.SetVoiceReply(cardMsg.SessionId, on)
+			return newVoiceReplyCard(&cardMsg.SessionId, on)
+		}
+		return nil, ErrNextHandler
+	}
+}
+
+// replyVoice reads answer aloud as a voice reply to msgId when the session
+// has voice replies on
+func (m MessageHandler) replyVoice(ctx context.Context, sessionId *string,
+	msgId *string, answer string) {
+	if m.config.AzureOn || answer == "" ||
+		!m.sessionCache.GetVoiceReply(*sessionId) {
+		return
+	}
+	speech, err := m.gpt.WithContext(ctx).TextToSpeech(m.config.TTSModel,
+		m.config.TTSVoice, answer)
+	if err != nil {
+		logger.Ctx(ctx).Warnf("text to speech failed: %v", err)
+		return
+	}
+	duration, err := audio.OggOpusDuration(bytes.NewReader(speech))
+	if err != nil {
+		logger.Ctx(ctx).Warnf("text to speech returned bad audio: %v", err)
+		return
+	}
+	replyId, err := replyAudio(ctx, msgId, fmt.Sprintf("%s.opus", *msgId),
+		duration, speech)
+	if err != nil {
+		logger.Ctx(ctx).Warnf("send voice reply failed: %v", err)
+		return
+	}
+	m.replyCache.AddReply(*msgId, *replyId)
+}
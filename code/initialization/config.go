@@ -31,6 +31,7 @@ type Config struct {
 	OpenaiModel                string
 	OpenAIHttpClientTimeOut    int
 	OpenaiMaxTokens            int
+	OpenaiMultimodal           string
 	HttpProxy                  string
 	AzureOn                    bool
 	AzureApiVersion            string
@@ -38,6 +39,54 @@ type Config struct {
 	AzureResourceName          string
 	AzureOpenaiToken           string
 	StreamMode                 bool
+	RecallBotReplies           bool
+	AudioLanguage              string
+	AudioTranscriptExport      bool
+	TTSModel                   string
+	ImageModel                 string
+	TTSVoice                   string
+	DocumentMaxSizeMB          int
+	DocumentContextTokens      int
+	KnowledgeBaseOn            bool
+	KnowledgeBaseDir           string
+	KnowledgeBaseIndex         string
+	KnowledgeBaseTopK          int
+	EmbeddingModel             string
+	WebFetchOn                 bool
+	WebFetchTimeout            int
+	WebFetchMaxSizeKB          int
+	WebFetchAllowPrivate       bool
+	OtelExporterEndpoint       string
+	OtelServiceName            string
+	LogLevel                   string
+	LogFormat                  string
+	LogAuditContent            bool
+	AuditLogOn                 bool
+	AuditLogDir                string
+	AuditLogContent            string
+	AuditRetentionDays         int
+	AuditExportToken           string
+	BotAdmins                  []string
+	AccessAllowUsers           []string
+	AccessDenyUsers            []string
+	AccessAllowChats           []string
+	AccessDenyChats            []string
+	AccessAllowDepartments     []string
+	AccessDenyDepartments      []string
+	AccessFeatures             string
+	AccessRulesFile            string
+	ModerationOn               bool
+	ModerationProvider         string
+	ModerationModel            string
+	ModerationAction           string
+	ModerationKeywords         []string
+	ModerationPatternsFile     string
+	ModerationOutput           bool
+	PIIRedactionOn             bool
+	PIIKinds                   []string
+	PIIPatternsFile            string
+	PIIRestore                 bool
+	BotMenuKeys                []string
 }
 
 var (
@@ -114,6 +163,7 @@ func LoadConfig(cfg string) *Config {
 		OpenaiModel:                getViperStringValue("OPENAI_MODEL", "gpt-3.5-turbo"),
 		OpenAIHttpClientTimeOut:    getViperIntValue("OPENAI_HTTP_CLIENT_TIMEOUT", 550),
 		OpenaiMaxTokens:            getViperIntValue("OPENAI_MAX_TOKENS", 2000),
+		OpenaiMultimodal:           getViperStringValue("OPENAI_MULTIMODAL", "auto"),
 		HttpPort:                   httpPort,
 		HttpsPort:                  getViperIntValue("HTTPS_PORT", 9001),
 		UseHttps:                   getViperBoolValue("USE_HTTPS", false),
@@ -127,6 +177,54 @@ func LoadConfig(cfg string) *Config {
 		AzureResourceName:          getViperStringValue("AZURE_RESOURCE_NAME", ""),
 		AzureOpenaiToken:           getViperStringValue("AZURE_OPENAI_TOKEN", ""),
 		StreamMode:                 getViperBoolValue("STREAM_MODE", false),
+		RecallBotReplies:           getViperBoolValue("RECALL_BOT_REPLIES", false),
+		AudioLanguage:              getViperStringValue("AUDIO_LANGUAGE", ""),
+		AudioTranscriptExport:      getViperBoolValue("AUDIO_TRANSCRIPT_EXPORT", false),
+		TTSModel:                   getViperStringValue("TTS_MODEL", "tts-1"),
+		ImageModel:                 getViperStringValue("IMAGE_MODEL", "dall-e-3"),
+		TTSVoice:                   getViperStringValue("TTS_VOICE", "alloy"),
+		DocumentMaxSizeMB:          getViperIntValue("DOCUMENT_MAX_SIZE_MB", 10),
+		DocumentContextTokens:      getViperIntValue("DOCUMENT_CONTEXT_TOKENS", 2000),
+		KnowledgeBaseOn:            getViperBoolValue("KNOWLEDGE_BASE_ON", false),
+		KnowledgeBaseDir:           getViperStringValue("KNOWLEDGE_BASE_DIR", ""),
+		KnowledgeBaseIndex:         getViperStringValue("KNOWLEDGE_BASE_INDEX", "./data/knowledge.json"),
+		KnowledgeBaseTopK:          getViperIntValue("KNOWLEDGE_BASE_TOP_K", 4),
+		EmbeddingModel:             getViperStringValue("EMBEDDING_MODEL", "text-embedding-3-small"),
+		WebFetchOn:                 getViperBoolValue("WEB_FETCH_ON", true),
+		WebFetchTimeout:            getViperIntValue("WEB_FETCH_TIMEOUT", 10),
+		WebFetchMaxSizeKB:          getViperIntValue("WEB_FETCH_MAX_SIZE_KB", 2048),
+		WebFetchAllowPrivate:       getViperBoolValue("WEB_FETCH_ALLOW_PRIVATE", false),
+		OtelExporterEndpoint:       getViperStringValue("OTEL_EXPORTER_OTLP_ENDPOINT", ""),
+		OtelServiceName:            getViperStringValue("OTEL_SERVICE_NAME", "feishu-bot"),
+		LogLevel:                   getViperStringValue("LOG_LEVEL", "info"),
+		LogFormat:                  getViperStringValue("LOG_FORMAT", "text"),
+		LogAuditContent:            getViperBoolValue("LOG_AUDIT_CONTENT", false),
+		AuditLogOn:                 getViperBoolValue("AUDIT_LOG_ON", false),
+		AuditLogDir:                getViperStringValue("AUDIT_LOG_DIR", "./data/audit"),
+		AuditLogContent:            getViperStringValue("AUDIT_LOG_CONTENT", "hash"),
+		AuditRetentionDays:         getViperIntValue("AUDIT_RETENTION_DAYS", 90),
+		AuditExportToken:           getViperStringValue("AUDIT_EXPORT_TOKEN", ""),
+		BotAdmins:                  getViperStringList("BOT_ADMINS"),
+		AccessAllowUsers:           getViperStringList("ACCESS_ALLOW_USERS"),
+		AccessDenyUsers:            getViperStringList("ACCESS_DENY_USERS"),
+		AccessAllowChats:           getViperStringList("ACCESS_ALLOW_CHATS"),
+		AccessDenyChats:            getViperStringList("ACCESS_DENY_CHATS"),
+		AccessAllowDepartments:     getViperStringList("ACCESS_ALLOW_DEPARTMENTS"),
+		AccessDenyDepartments:      getViperStringList("ACCESS_DENY_DEPARTMENTS"),
+		AccessFeatures:             getViperStringValue("ACCESS_FEATURES", ""),
+		AccessRulesFile:            getViperStringValue("ACCESS_RULES_FILE", "./data/access.json"),
+		ModerationOn:               getViperBoolValue("MODERATION_ON", false),
+		ModerationProvider:         getViperStringValue("MODERATION_PROVIDER", "openai"),
+		ModerationModel:            getViperStringValue("MODERATION_MODEL", "omni-moderation-latest"),
+		ModerationAction:           getViperStringValue("MODERATION_ACTION", "block"),
+		ModerationKeywords:         getViperStringList("MODERATION_KEYWORDS"),
+		ModerationPatternsFile:     getViperStringValue("MODERATION_PATTERNS_FILE", ""),
+		ModerationOutput:           getViperBoolValue("MODERATION_OUTPUT", false),
+		PIIRedactionOn:             getViperBoolValue("PII_REDACTION_ON", false),
+		PIIKinds:                   getViperStringList("PII_KINDS"),
+		PIIPatternsFile:            getViperStringValue("PII_PATTERNS_FILE", ""),
+		PIIRestore:                 getViperBoolValue("PII_RESTORE", true),
+		BotMenuKeys:                getViperStringList("BOT_MENU_KEYS"),
 	}
 
 	return config
@@ -151,6 +249,18 @@ func getViperStringArray(key string, defaultValue []string) []string {
 	return filterFormatKey(raw)
 }
 
+// BOT_ADMINS: ou_xxx, ou_yyy
+// result:[ou_xxx ou_yyy]
+func getViperStringList(key string) []string {
+	var result []string
+	for _, value := range strings.Split(viper.GetString(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			result = append(result, value)
+		}
+	}
+	return result
+}
+
 func getViperIntValue(key string, defaultValue int) int {
 	value := viper.GetString(key)
 	if value == "" {
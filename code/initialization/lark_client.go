@@ -1,6 +1,9 @@
 package initialization
 
 import (
+	"net/http"
+	"start-feishubot/tracing"
+
 	lark "github.com/larksuite/oapi-sdk-go/v3"
 	larkcore "github.com/larksuite/oapi-sdk-go/v3/core"
 )
@@ -10,6 +13,10 @@ var larkClient *lark.Client
 func LoadLarkClient(config Config) {
 	options := []lark.ClientOptionFunc{
 		lark.WithLogLevel(larkcore.LogLevelDebug),
+		// carries the trace of each call to the Lark API
+		lark.WithHttpClient(&http.Client{
+			Transport: tracing.Transport(http.DefaultTransport),
+		}),
 	}
 	if config.FeishuBaseUrl != "" {
 		options = append(options, lark.WithOpenBaseUrl(config.FeishuBaseUrl))
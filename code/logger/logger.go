@@ -2,120 +2,196 @@ package logger
 
 import (
 	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
-	"strings"
+
+	"start-feishubot/tracing"
 )
 
 var logger = logrus.New()
 
 func init() {
+	logger.SetFormatter(&redactingFormatter{inner: &textFormatter{}})
+	logger.SetOutput(os.Stdout)
+	logger.Level = logrus.InfoLevel
 
-	logger.SetFormatter(&formatter{})
+	gin.DefaultWriter = logger.Out
+}
 
-	logger.SetReportCaller(true)
+// Init applies the logging settings of the config. level is one of debug,
+// info, warn or error, format is text or json. User message content is
+// only written when auditContent is set, secrets are always redacted
+func Init(level string, format string, auditContent bool) error {
+	parsed, err := logrus.ParseLevel(level)
+	if err != nil {
+		return fmt.Errorf("invalid log level %q: %w", level, err)
+	}
+	var inner logrus.Formatter
+	switch strings.ToLower(format) {
+	case "", "text":
+		inner = &textFormatter{}
+	case "json":
+		inner = &logrus.JSONFormatter{TimestampFormat: "2006-01-02T15:04:05.000Z07:00"}
+	default:
+		return fmt.Errorf("invalid log format %q", format)
+	}
+	logger.SetFormatter(&redactingFormatter{inner: inner})
+	logger.SetLevel(parsed)
+	setAuditContent(auditContent)
+	return nil
+}
 
-	gin.DefaultWriter = logger.Out
+type Fields logrus.Fields
 
-	// Set log level. Supported levels:
-	//PanicLevel
-	//FatalLevel
-	//ErrorLevel
-	//WarnLevel
-	//InfoLevel
-	//DebugLevel
-	logger.Level = logrus.DebugLevel  // Changed to Debug to see all logs
+type contextKey int
 
+const (
+	requestIDKey contextKey = iota
+	sessionIDKey
+)
+
+// WithRequestID returns a context whose log entries carry the request id
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
 }
 
-type Fields logrus.Fields
+// WithSessionID returns a context whose log entries carry the session id
+func WithSessionID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, sessionIDKey, id)
+}
+
+// RequestID is the request id carried by ctx, empty when there is none
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// Entry is a log entry with fields, such as the ids of the request it is
+// written for
+type Entry struct {
+	entry *logrus.Entry
+}
+
+// Ctx returns an entry carrying the request, session and trace ids of ctx
+func Ctx(ctx context.Context) *Entry {
+	fields := logrus.Fields{}
+	if id, ok := ctx.Value(requestIDKey).(string); ok && id != "" {
+		fields["request_id"] = id
+	}
+	if id, ok := ctx.Value(sessionIDKey).(string); ok && id != "" {
+		fields["session_id"] = id
+	}
+	if id := tracing.TraceID(ctx); id != "" {
+		fields["trace_id"] = id
+	}
+	return &Entry{entry: logger.WithFields(fields)}
+}
+
+// WithField returns a copy of the entry with one more field
+func (e *Entry) WithField(key string, value interface{}) *Entry {
+	return &Entry{entry: e.entry.WithField(key, value)}
+}
+
+// WithFields returns a copy of the entry with more fields
+func (e *Entry) WithFields(fields Fields) *Entry {
+	return &Entry{entry: e.entry.WithFields(logrus.Fields(fields))}
+}
+
+func (e *Entry) Debug(args ...interface{}) { e.entry.Debugln(args...) }
+func (e *Entry) Info(args ...interface{})  { e.entry.Infoln(args...) }
+func (e *Entry) Warn(args ...interface{})  { e.entry.Warnln(args...) }
+func (e *Entry) Error(args ...interface{}) { e.entry.Errorln(args...) }
+
+func (e *Entry) Debugf(format string, args ...interface{}) { e.entry.Debugf(format, args...) }
+func (e *Entry) Infof(format string, args ...interface{})  { e.entry.Infof(format, args...) }
+func (e *Entry) Warnf(format string, args ...interface{})  { e.entry.Warnf(format, args...) }
+func (e *Entry) Errorf(format string, args ...interface{}) { e.entry.Errorf(format, args...) }
 
 // Debugf logs a message at level Debug on the standard logger.
 func Debugf(format string, args ...interface{}) {
-	if logger.Level >= logrus.DebugLevel {
-		entry := logger.WithFields(logrus.Fields{})
-		entry.Debugf(format, args...)
-	}
+	logger.Debugf(format, args...)
+}
+
+// Infof logs a message at level Info on the standard logger.
+func Infof(format string, args ...interface{}) {
+	logger.Infof(format, args...)
 }
 
 // Warnf logs a message at level Warn on the standard logger.
 func Warnf(format string, args ...interface{}) {
-	if logger.Level >= logrus.WarnLevel {
-		entry := logger.WithFields(logrus.Fields{})
-		entry.Warnf(format, args...)
-	}
+	logger.Warnf(format, args...)
 }
 
 // Errorf logs a message at level Error on the standard logger.
 func Errorf(format string, args ...interface{}) {
-	if logger.Level >= logrus.ErrorLevel {
-		entry := logger.WithFields(logrus.Fields{})
-		entry.Errorf(format, args...)
-	}
+	logger.Errorf(format, args...)
 }
 
 // Fatalf logs a message at level Fatal on the standard logger.
 func Fatalf(format string, args ...interface{}) {
-	if logger.Level >= logrus.FatalLevel {
-		entry := logger.WithFields(logrus.Fields{})
-		entry.Fatalf(format, args...)
-	}
+	logger.Fatalf(format, args...)
 }
 
-func Debug(format string, args ...interface{}) {
-	if logger.Level >= logrus.DebugLevel {
-		entry := logger.WithFields(logrus.Fields{})
-		entry.Debug(format, args)
-	}
+// Debug logs its arguments separated by spaces at level Debug on the
+// standard logger.
+func Debug(args ...interface{}) {
+	logger.Debugln(args...)
 }
 
-// Info logs a message at level Info on the standard logger.
-func Info(format string, args ...interface{}) {
-	if logger.Level >= logrus.InfoLevel {
-		entry := logger.WithFields(logrus.Fields{})
-		entry.Info(format, args)
-	}
+// Info logs its arguments separated by spaces at level Info on the
+// standard logger.
+func Info(args ...interface{}) {
+	logger.Infoln(args...)
 }
 
-// Warn logs a message at level Warn on the standard logger.
-func Warn(format string, args ...interface{}) {
-	if logger.Level >= logrus.WarnLevel {
-		entry := logger.WithFields(logrus.Fields{})
-		entry.Warn(format, args)
-	}
+// Warn logs its arguments separated by spaces at level Warn on the
+// standard logger.
+func Warn(args ...interface{}) {
+	logger.Warnln(args...)
 }
 
-// Error logs a message at level Error on the standard logger.
-func Error(format string, args ...interface{}) {
-	if logger.Level >= logrus.ErrorLevel {
-		entry := logger.WithFields(logrus.Fields{})
-		entry.Error(format, args)
-	}
+// Error logs its arguments separated by spaces at level Error on the
+// standard logger.
+func Error(args ...interface{}) {
+	logger.Errorln(args...)
 }
 
-// Fatal logs a message at level Fatal on the standard logger.
-func Fatal(format string, args ...interface{}) {
-	if logger.Level >= logrus.FatalLevel {
-		entry := logger.WithFields(logrus.Fields{})
-		entry.Fatal(format, args)
-	}
+// Fatal logs its arguments separated by spaces at level Fatal on the
+// standard logger.
+func Fatal(args ...interface{}) {
+	logger.Fatalln(args...)
 }
 
-// Formatter implements logrus.Formatter interface.
-type formatter struct {
-	prefix string
-}
+// textFormatter writes "[LEVEL]time message key=value ..." lines
+type textFormatter struct{}
 
 // Format building log message.
-func (f *formatter) Format(entry *logrus.Entry) ([]byte, error) {
+func (f *textFormatter) Format(entry *logrus.Entry) ([]byte, error) {
 	var sb bytes.Buffer
 
 	sb.WriteString("[" + strings.ToUpper(entry.Level.String()) + "]")
 	sb.WriteString(entry.Time.Format("2006-01-02 15:04:05"))
 	sb.WriteString(" ")
-	//sb.WriteString(" ")
-	//sb.WriteString(f.prefix)
 	sb.WriteString(entry.Message)
+
+	keys := make([]string, 0, len(entry.Data))
+	for key := range entry.Data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value := fmt.Sprint(entry.Data[key])
+		if strings.ContainsAny(value, " \"=\n") {
+			value = fmt.Sprintf("%q", value)
+		}
+		sb.WriteString(" " + key + "=" + value)
+	}
 	sb.WriteString("\n")
 
 	return sb.Bytes(), nil
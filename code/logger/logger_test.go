@@ -0,0 +1,94 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func capture(t *testing.T, format string, audit bool) *bytes.Buffer {
+	t.Helper()
+	if err := Init("debug", format, audit); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	out := logger.Out
+	logger.SetOutput(&buf)
+	t.Cleanup(func() {
+		logger.SetOutput(out)
+		Init("info", "text", false)
+	})
+	return &buf
+}
+
+func TestInfoJoinsArguments(t *testing.T) {
+	buf := capture(t, "text", false)
+	Info("handlers initialized:", 3, "actions")
+	if !strings.HasSuffix(buf.String(), " handlers initialized: 3 actions\n") {
+		t.Errorf("got %q", buf.String())
+	}
+}
+
+func TestRedactsSecrets(t *testing.T) {
+	buf := capture(t, "text", false)
+	RegisterSecret("vt-0123456789", "ek")
+	Info("Verification token is vt-0123456789, key", "sk-abcdefghijklmnopqrstu")
+	Infof("headers map[Authorization:[Bearer abc.def-ghi]]")
+	Infof(`{"app_secret":"xyz","max_tokens":100}`)
+
+	got := buf.String()
+	for _, leaked := range []string{"vt-0123456789", "sk-abcdefghijklmnopqrstu",
+		"abc.def-ghi", "xyz"} {
+		if strings.Contains(got, leaked) {
+			t.Errorf("%q leaked into\n%s", leaked, got)
+		}
+	}
+	if !strings.Contains(got, `"max_tokens":100`) {
+		t.Errorf("unrelated field redacted:\n%s", got)
+	}
+}
+
+func TestUserContentNeedsAudit(t *testing.T) {
+	buf := capture(t, "text", false)
+	Debug("message", UserContent("my salary is 42"))
+	Debugf(`body {"text":"my salary is 42"}`)
+	if strings.Contains(buf.String(), "salary") {
+		t.Errorf("content logged without audit:\n%s", buf.String())
+	}
+
+	buf = capture(t, "text", true)
+	Debug("message", UserContent("my salary is 42"))
+	if !strings.Contains(buf.String(), "my salary is 42") {
+		t.Errorf("content missing with audit on:\n%s", buf.String())
+	}
+}
+
+func TestJSONEntryCarriesContextIDs(t *testing.T) {
+	buf := capture(t, "json", false)
+	ctx := WithSessionID(WithRequestID(context.Background(), "req-1"), "om_1")
+	Ctx(ctx).WithField("action", "Pic").Warnf("failed: %s",
+		"token=abcdef123456")
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("%v: %s", err, buf.String())
+	}
+	if entry["request_id"] != "req-1" || entry["session_id"] != "om_1" ||
+		entry["action"] != "Pic" || entry["level"] != "warning" {
+		t.Errorf("unexpected entry %v", entry)
+	}
+	if msg := entry["msg"].(string); strings.Contains(msg, "abcdef123456") {
+		t.Errorf("secret leaked into %q", msg)
+	}
+}
+
+func TestInitRejectsUnknownSettings(t *testing.T) {
+	if err := Init("loud", "text", false); err == nil {
+		t.Error("expected an invalid level error")
+	}
+	if err := Init("info", "xml", false); err == nil {
+		t.Error("expected an invalid format error")
+	}
+}
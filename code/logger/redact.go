@@ -0,0 +1,140 @@
+package logger
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+const redacted = "[REDACTED]"
+
+var redaction struct {
+	sync.RWMutex
+	secrets      []string
+	replacer     *regexp.Regexp
+	auditContent bool
+}
+
+// secretPatterns match credentials by their shape, wherever they appear
+var secretPatterns = []*regexp.Regexp{
+	// OpenAI style API keys
+	regexp.MustCompile(`\bsk-[A-Za-z0-9_\-]{16,}`),
+	// Authorization header values
+	regexp.MustCompile(`(?i)\b(bearer|basic)\s+[A-Za-z0-9\-._~+/]+=*`),
+	// Lark access tokens
+	regexp.MustCompile(`\b[tu]-[A-Za-z0-9_\-.]{20,}`),
+}
+
+// secretFields match JSON or key=value pairs whose value is a credential
+var secretFields = regexp.MustCompile(`(?i)("?\b(?:app_secret|secret|token|` +
+	`tenant_access_token|encrypt|encrypt_key|api[-_]?key|password|` +
+	`authorization)"?\s*[:=]\s*)("[^"]*"|[^\s,}\]]+)`)
+
+// contentFields match JSON fields carrying what users wrote, they are
+// redacted unless content auditing is on
+var contentFields = regexp.MustCompile(`(?i)("(?:content|text|question|` +
+	`prompt|value|input)"\s*:\s*)("(?:[^"\\]|\\.)*")`)
+
+// RegisterSecret redacts every occurrence of the given values from the
+// logs, such as the app secret and API keys read from the config
+func RegisterSecret(secrets ...string) {
+	redaction.Lock()
+	defer redaction.Unlock()
+	for _, secret := range secrets {
+		// short values would redact ordinary words
+		if len(secret) >= 6 {
+			redaction.secrets = append(redaction.secrets, secret)
+		}
+	}
+	if len(redaction.secrets) == 0 {
+		return
+	}
+	// longest first so a secret containing another is replaced whole
+	sort.Slice(redaction.secrets, func(i, j int) bool {
+		return len(redaction.secrets[i]) > len(redaction.secrets[j])
+	})
+	quoted := make([]string, len(redaction.secrets))
+	for i, secret := range redaction.secrets {
+		quoted[i] = regexp.QuoteMeta(secret)
+	}
+	pattern := quoted[0]
+	for _, q := range quoted[1:] {
+		pattern += "|" + q
+	}
+	redaction.replacer = regexp.MustCompile(pattern)
+}
+
+func setAuditContent(on bool) {
+	redaction.Lock()
+	redaction.auditContent = on
+	redaction.Unlock()
+}
+
+// Redact removes secrets from s, and user content unless it is audited
+func Redact(s string) string {
+	redaction.RLock()
+	replacer, auditContent := redaction.replacer, redaction.auditContent
+	redaction.RUnlock()
+
+	if replacer != nil {
+		s = replacer.ReplaceAllString(s, redacted)
+	}
+	for _, pattern := range secretPatterns {
+		s = pattern.ReplaceAllString(s, redacted)
+	}
+	s = secretFields.ReplaceAllString(s, "${1}"+redacted)
+	if !auditContent {
+		s = contentFields.ReplaceAllString(s, `${1}"`+redacted+`"`)
+	}
+	return s
+}
+
+// userContent is a value written by a user, logged only when content
+// auditing is on
+type userContent struct {
+	value interface{}
+}
+
+// UserContent wraps what a user wrote, such as a message or card action,
+// so logging it writes a placeholder unless content auditing is on
+func UserContent(value interface{}) fmt.Stringer {
+	return userContent{value: value}
+}
+
+func (c userContent) String() string {
+	redaction.RLock()
+	auditContent := redaction.auditContent
+	redaction.RUnlock()
+	if !auditContent {
+		return fmt.Sprintf("[user content, %d bytes]", len(fmt.Sprint(c.value)))
+	}
+	return fmt.Sprint(c.value)
+}
+
+// redactingFormatter redacts the message and field values of entries
+// before the inner formatter writes them
+type redactingFormatter struct {
+	inner logrus.Formatter
+}
+
+func (f *redactingFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	clean := *entry
+	clean.Message = Redact(entry.Message)
+	clean.Data = make(logrus.Fields, len(entry.Data))
+	for key, value := range entry.Data {
+		switch v := value.(type) {
+		case error:
+			clean.Data[key] = Redact(v.Error())
+		case string:
+			clean.Data[key] = Redact(v)
+		case fmt.Stringer:
+			clean.Data[key] = Redact(v.String())
+		default:
+			clean.Data[key] = value
+		}
+	}
+	return f.inner.Format(&clean)
+}
@@ -8,16 +8,26 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"net/http"
 	"start-feishubot/handlers"
 	"start-feishubot/initialization"
 	"start-feishubot/logger"
+	"start-feishubot/metrics"
+	"start-feishubot/tracing"
 
 	"github.com/gin-gonic/gin"
-	sdkginext "github.com/larksuite/oapi-sdk-gin"
+	"github.com/google/uuid"
 	larkcard "github.com/larksuite/oapi-sdk-go/v3/card"
+	larkevent "github.com/larksuite/oapi-sdk-go/v3/event"
 	"github.com/larksuite/oapi-sdk-go/v3/event/dispatcher"
 	larkim "github.com/larksuite/oapi-sdk-go/v3/service/im/v1"
 	"github.com/spf13/pflag"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/semconv/v1.17.0/httpconv"
+	"go.opentelemetry.io/otel/trace"
+	"start-feishubot/services/audit"
 	"start-feishubot/services/openai"
 )
 
@@ -97,6 +107,68 @@ func pkcs7Unpad(data []byte) ([]byte, error) {
 	return data[:len(data)-padding], nil
 }
 
+// requestID tags each request with an id, taken from the X-Request-Id
+// header when the caller sent one, that its log entries carry
+func requestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader("X-Request-Id")
+		if id == "" {
+			id = uuid.New().String()
+		}
+		c.Header("X-Request-Id", id)
+		c.Request = c.Request.WithContext(
+			logger.WithRequestID(c.Request.Context(), id))
+		c.Next()
+	}
+}
+
+// traceRequest wraps a webhook request in a server span, the handlers
+// find it in the request context
+func traceRequest() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := otel.GetTextMapPropagator().Extract(c.Request.Context(),
+			propagation.HeaderCarrier(c.Request.Header))
+		ctx, span := tracing.Start(ctx, c.Request.Method+" "+c.FullPath(),
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(httpconv.ServerRequest("", c.Request)...),
+			trace.WithAttributes(semconv.HTTPRoute(c.FullPath())))
+		defer span.End()
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+		span.SetAttributes(semconv.HTTPStatusCode(c.Writer.Status()))
+		span.SetStatus(httpconv.ServerStatus(c.Writer.Status()))
+	}
+}
+
+// eventHandlerFunc serves the event webhook like sdkginext does, but hands
+// the dispatcher the traced request context; sdkginext always passes
+// context.Background, which would start each handler span a new trace.
+// Cancellation is dropped so a reply keeps going when Lark stops waiting
+// for the response
+func eventHandlerFunc(eventDispatcher *dispatcher.EventDispatcher) gin.HandlerFunc {
+	eventDispatcher.InitConfig()
+	return func(c *gin.Context) {
+		ctx := tracing.Detach(c.Request.Context())
+		body, err := c.GetRawData()
+		if err != nil {
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		eventResp := eventDispatcher.Handle(ctx, &larkevent.EventReq{
+			Header:     c.Request.Header,
+			Body:       body,
+			RequestURI: c.Request.RequestURI,
+		})
+		for k, vs := range eventResp.Header {
+			for _, v := range vs {
+				c.Writer.Header().Add(k, v)
+			}
+		}
+		c.Writer.WriteHeader(eventResp.StatusCode)
+		c.Writer.Write(eventResp.Body)
+	}
+}
+
 func main() {
 	logger.Info("========================================")
 	logger.Info("Starting Feishu Bot with ENHANCED LOGGING")
@@ -105,12 +177,37 @@ func main() {
 	initialization.InitRoleList()
 	pflag.Parse()
 	config := initialization.GetConfig()
+	if err := logger.Init(config.LogLevel, config.LogFormat,
+		config.LogAuditContent); err != nil {
+		logger.Fatalf("invalid logging config: %v", err)
+	}
+	logger.RegisterSecret(append([]string{config.FeishuAppSecret,
+		config.FeishuAppVerificationToken, config.FeishuAppEncryptKey,
+		config.AzureOpenaiToken, config.AuditExportToken},
+		config.OpenaiApiKeys...)...)
 
 	logger.Info("Configuration loaded")
-	logger.Info("Verification Token:", config.FeishuAppVerificationToken)
-	logger.Info("Encrypt Key:", config.FeishuAppEncryptKey)
+	logger.Info("Verification token set:", config.FeishuAppVerificationToken != "")
+	logger.Info("Encrypt key set:", config.FeishuAppEncryptKey != "")
 
 	initialization.LoadLarkClient(*config)
+	if err := tracing.Init(config.OtelExporterEndpoint,
+		config.OtelServiceName); err != nil {
+		logger.Fatalf("invalid tracing config: %v", err)
+	}
+	defer tracing.Shutdown(context.Background())
+	if config.AuditLogOn {
+		if err := audit.Init(audit.Options{
+			Dir:           config.AuditLogDir,
+			Content:       config.AuditLogContent,
+			RetentionDays: config.AuditRetentionDays,
+		}); err != nil {
+			logger.Fatalf("failed to open audit log: %v", err)
+		}
+		defer audit.Default().Close()
+		logger.Infof("Audit log enabled in %s, content kept as %s",
+			config.AuditLogDir, config.AuditLogContent)
+	}
 	gpt := openai.NewChatGPT(*config)
 	handlers.InitHandlers(gpt, *config)
 
@@ -122,12 +219,13 @@ func main() {
 		OnP2MessageReadV1(func(ctx context.Context, event *larkim.P2MessageReadV1) error {
 			logger.Debugf("Received request %v", event.RequestURI)
 			return handlers.ReadHandler(ctx, event)
-		})
-
-	logger.Info("Card webhook verification token:", config.FeishuAppVerificationToken)
-	logger.Info("Card webhook encrypt key length:", len(config.FeishuAppEncryptKey))
+		}).
+		OnP2MessageRecalledV1(handlers.RecallHandler).
+		OnCustomizedEvent(handlers.MessageUpdatedEventType, handlers.UpdateHandler).
+		OnCustomizedEvent(handlers.BotMenuEventType, handlers.BotMenuHandler)
 
 	r := gin.Default()
+	r.Use(requestID())
 
 	// Add recovery middleware with logging
 	r.Use(gin.CustomRecovery(func(c *gin.Context, err interface{}) {
@@ -141,6 +239,11 @@ func main() {
 		})
 	})
 
+	r.GET("/metrics", gin.WrapH(metrics.Handler()))
+	if config.AuditLogOn && config.AuditExportToken != "" {
+		r.GET("/admin/audit/export", gin.WrapH(audit.ExportHandler(config.AuditExportToken)))
+	}
+
 	// Test endpoint to verify deployment
 	r.GET("/test-card-logging", func(c *gin.Context) {
 		logger.Info("Test endpoint called - logging is working!")
@@ -150,42 +253,42 @@ func main() {
 			"timestamp": "2025-10-27",
 		})
 	})
-	r.POST("/webhook/event",
-		sdkginext.NewEventHandlerFunc(eventHandler))
+	r.POST("/webhook/event", traceRequest(), eventHandlerFunc(eventHandler))
 
 	// Card webhook - Hybrid approach: use event handler for verification, custom handler for actions
 	logger.Info("Registering card webhook handler...")
 	logger.Info("WORKAROUND: Using hybrid handler since SDK card handler has signature issues")
 
-	r.POST("/webhook/card", func(c *gin.Context) {
-		logger.Info("========== CARD WEBHOOK RECEIVED ==========")
+	r.POST("/webhook/card", traceRequest(), func(c *gin.Context) {
+		log := logger.Ctx(c.Request.Context())
+		log.Info("========== CARD WEBHOOK RECEIVED ==========")
 
 		// Read body once
 		bodyBytes, err := c.GetRawData()
 		if err != nil {
-			logger.Error("Failed to read body:", err)
+			log.Error("Failed to read body:", err)
 			c.JSON(500, gin.H{"error": "failed to read body"})
 			return
 		}
 
-		logger.Debug("Raw body:", string(bodyBytes))
+		log.Debug("Raw body bytes:", len(bodyBytes))
 
 		// Try to decrypt using the event crypto package (which works)
 		decryptedBody, err := decryptCardWebhook(bodyBytes, config.FeishuAppEncryptKey, config.FeishuAppVerificationToken)
 		if err != nil {
-			logger.Error("Failed to decrypt:", err)
+			log.Error("Failed to decrypt:", err)
 			c.JSON(500, gin.H{"error": "decryption failed"})
 			return
 		}
 
-		logger.Info("Successfully decrypted card webhook")
-		logger.Debug("Decrypted body:", string(decryptedBody))
+		log.Info("Successfully decrypted card webhook")
+		log.Debug("Decrypted body:", logger.UserContent(string(decryptedBody)))
 
 		// Check if it's a challenge
 		var challengeBody map[string]interface{}
 		if err := json.Unmarshal(decryptedBody, &challengeBody); err == nil {
 			if challenge, ok := challengeBody["challenge"].(string); ok {
-				logger.Info("✓ Challenge verified:", challenge)
+				log.Info("✓ Challenge verified:", challenge)
 				c.JSON(200, gin.H{"challenge": challenge})
 				return
 			}
@@ -194,20 +297,21 @@ func main() {
 		// It's a card action - parse and handle it
 		var cardAction larkcard.CardAction
 		if err := json.Unmarshal(decryptedBody, &cardAction); err != nil {
-			logger.Error("Failed to parse card action:", err)
+			log.Error("Failed to parse card action:", err)
 			c.JSON(500, gin.H{"error": "invalid card action"})
 			return
 		}
 
-		logger.Info("Processing card action...")
-		result, err := handlers.CardHandler()(context.Background(), &cardAction)
+		log.Info("Processing card action...")
+		result, err := handlers.CardHandler()(
+			tracing.Detach(c.Request.Context()), &cardAction)
 		if err != nil {
-			logger.Error("Card handler error:", err)
+			log.Error("Card handler error:", err)
 			c.JSON(500, gin.H{"error": err.Error()})
 			return
 		}
 
-		logger.Info("✓ Card action processed successfully")
+		log.Info("✓ Card action processed successfully")
 		c.JSON(200, result)
 	})
 
@@ -0,0 +1,29 @@
+package metrics
+
+// Metrics recorded by the bot. Gauges over state owned by other packages
+// are registered by those packages with NewGaugeFunc
+var (
+	WebhookEvents = newCounterVec("feishubot_webhook_events_total",
+		"Webhook events received, by event type.", "type")
+	Actions = newCounterVec("feishubot_actions_total",
+		"Messages by the action of the chain that handled them.", "action")
+	Commands = newCounterVec("feishubot_commands_total",
+		"Commands received by name, unknown for unregistered ones.",
+		"command")
+
+	OpenAIRequests = newCounterVec("feishubot_openai_requests_total",
+		"OpenAI API requests, retries included, by endpoint, model, "+
+			"HTTP status and API key.", "endpoint", "model", "status", "key")
+	OpenAIDuration = newHistogramVec("feishubot_openai_request_duration_seconds",
+		"Latency of OpenAI API requests, whole streams for streamed "+
+			"completions.", DefaultBuckets, "endpoint", "model")
+
+	LarkRequests = newCounterVec("feishubot_lark_requests_total",
+		"Lark API calls by API and outcome.", "api", "status")
+	LarkDuration = newHistogramVec("feishubot_lark_request_duration_seconds",
+		"Latency of Lark API calls.", DefaultBuckets, "api")
+
+	ModerationFlags = newCounterVec("feishubot_moderation_flags_total",
+		"Inputs and outputs flagged by moderation, by stage, source and "+
+			"the action taken.", "stage", "source", "action")
+)
@@ -0,0 +1,73 @@
+// Package metrics keeps the bot's Prometheus collectors in a registry of
+// its own and serves them for scraping
+package metrics
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// DefaultBuckets are the latency buckets in seconds, sized for API calls
+// that take from a few milliseconds up to minutes of streaming
+var DefaultBuckets = []float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 120}
+
+// registry holds the bot's metrics along with the Go runtime and process
+// collectors
+var registry = prometheus.NewRegistry()
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+}
+
+// Handler serves the registered metrics to Prometheus scrapes
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+func newCounterVec(name, help string, labels ...string) *prometheus.CounterVec {
+	c := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: name,
+		Help: help,
+	}, labels)
+	registry.MustRegister(c)
+	return c
+}
+
+func newHistogramVec(name, help string, buckets []float64,
+	labels ...string) *prometheus.HistogramVec {
+	h := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    name,
+		Help:    help,
+		Buckets: buckets,
+	}, labels)
+	registry.MustRegister(h)
+	return h
+}
+
+var gauges = struct {
+	sync.Mutex
+	byName map[string]prometheus.Collector
+}{byName: map[string]prometheus.Collector{}}
+
+// NewGaugeFunc registers a gauge whose value is read from fn at scrape
+// time. Registering a name again replaces the earlier gauge, so state that
+// is rebuilt, such as a new load balancer, is reported rather than the old
+func NewGaugeFunc(name, help string, fn func() float64) {
+	gauges.Lock()
+	defer gauges.Unlock()
+	if previous, ok := gauges.byName[name]; ok {
+		registry.Unregister(previous)
+	}
+	g := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: name,
+		Help: help,
+	}, fn)
+	registry.MustRegister(g)
+	gauges.byName[name] = g
+}
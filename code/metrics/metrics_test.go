@@ -0,0 +1,78 @@
+package metrics
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCounterVecRegistered(t *testing.T) {
+	c := newCounterVec("test_requests_total", "Requests by path.",
+		"path", "status")
+	c.WithLabelValues("/b", "200").Inc()
+	c.WithLabelValues("/a", "500").Add(2)
+	c.WithLabelValues("/b", "200").Inc()
+
+	want := `# HELP test_requests_total Requests by path.
+# TYPE test_requests_total counter
+test_requests_total{path="/a",status="500"} 2
+test_requests_total{path="/b",status="200"} 2
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(want),
+		"test_requests_total"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestHistogramVecBuckets(t *testing.T) {
+	h := newHistogramVec("test_duration_seconds", "Durations.",
+		[]float64{0.5, 1}, "api")
+	h.WithLabelValues("x").Observe(0.2)
+	h.WithLabelValues("x").Observe(1)
+	h.WithLabelValues("x").Observe(3)
+
+	want := `# HELP test_duration_seconds Durations.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{api="x",le="0.5"} 1
+test_duration_seconds_bucket{api="x",le="1"} 2
+test_duration_seconds_bucket{api="x",le="+Inf"} 3
+test_duration_seconds_sum{api="x"} 4.2
+test_duration_seconds_count{api="x"} 3
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(want),
+		"test_duration_seconds"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestGaugeFuncReplaced(t *testing.T) {
+	NewGaugeFunc("test_items", "Items.", func() float64 { return 1 })
+	NewGaugeFunc("test_items", "Items.", func() float64 { return 7 })
+
+	want := `# HELP test_items Items.
+# TYPE test_items gauge
+test_items 7
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(want),
+		"test_items"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestHandlerServesBotAndRuntimeMetrics(t *testing.T) {
+	WebhookEvents.WithLabelValues("test").Inc()
+	w := httptest.NewRecorder()
+	Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := ioutil.ReadAll(w.Body)
+	for _, want := range []string{
+		`feishubot_webhook_events_total{type="test"} 1`,
+		"# TYPE go_goroutines gauge",
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("exposition lacks %q\n%s", want, body)
+		}
+	}
+}
@@ -0,0 +1,383 @@
+// Package access decides who may use the bot and which of its features.
+// Rules come from the config and from bot admins, the latter are kept in
+// a JSON file so they survive restarts.
+package access
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Lists of the rules, as named in admin commands
+const (
+	AllowUsers       = "allow_users"
+	DenyUsers        = "deny_users"
+	AllowChats       = "allow_chats"
+	DenyChats        = "deny_chats"
+	AllowDepartments = "allow_departments"
+	DenyDepartments  = "deny_departments"
+	Admins           = "admins"
+)
+
+// Rules are allow and deny lists of open ids, chat ids and department ids,
+// per-feature lists of who may use a feature, and the bot admins
+type Rules struct {
+	AllowUsers       []string            `json:"allow_users,omitempty"`
+	DenyUsers        []string            `json:"deny_users,omitempty"`
+	AllowChats       []string            `json:"allow_chats,omitempty"`
+	DenyChats        []string            `json:"deny_chats,omitempty"`
+	AllowDepartments []string            `json:"allow_departments,omitempty"`
+	DenyDepartments  []string            `json:"deny_departments,omitempty"`
+	Features         map[string][]string `json:"features,omitempty"`
+	Admins           []string            `json:"admins,omitempty"`
+}
+
+func (r *Rules) list(name string) *[]string {
+	switch name {
+	case AllowUsers:
+		return &r.AllowUsers
+	case DenyUsers:
+		return &r.DenyUsers
+	case AllowChats:
+		return &r.AllowChats
+	case DenyChats:
+		return &r.DenyChats
+	case AllowDepartments:
+		return &r.AllowDepartments
+	case DenyDepartments:
+		return &r.DenyDepartments
+	case Admins:
+		return &r.Admins
+	}
+	return nil
+}
+
+// ParseFeatures parses per-feature permissions written as
+// "Pic=ou_1|od_2;Role=ou_3", each feature lists the open ids, chat ids or
+// department ids allowed to use it
+func ParseFeatures(value string) (map[string][]string, error) {
+	features := map[string][]string{}
+	for _, entry := range strings.Split(value, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, ids, found := strings.Cut(entry, "=")
+		name = strings.TrimSpace(name)
+		if !found || name == "" {
+			return nil, fmt.Errorf("invalid feature permission %q, expected "+
+				"feature=id|id", entry)
+		}
+		for _, id := range strings.Split(ids, "|") {
+			if id = strings.TrimSpace(id); id != "" {
+				features[name] = append(features[name], id)
+			}
+		}
+	}
+	return features, nil
+}
+
+// Subject is who asks to use the bot
+type Subject struct {
+	UserID      string // open id
+	ChatID      string
+	Departments []string // open department ids of the user
+}
+
+func (s Subject) in(ids []string) bool {
+	for _, id := range ids {
+		if id == s.UserID || id == s.ChatID {
+			return true
+		}
+		for _, department := range s.Departments {
+			if id == department {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Policy applies the rules of the config together with the rules set by
+// admins. Config rules cannot be removed by admins so a misconfigured
+// command never locks the operators out
+type Policy struct {
+	path   string
+	config Rules
+
+	mu    sync.RWMutex
+	local Rules
+}
+
+// Open returns the policy of the config rules and of the admin rules kept
+// at path, path may be empty to keep admin changes in memory only
+func Open(config Rules, path string) (*Policy, error) {
+	p := &Policy{path: path, config: config}
+	if path == "" {
+		return p, nil
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return p, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read access rules failed: %w", err)
+	}
+	if err := json.Unmarshal(data, &p.local); err != nil {
+		return nil, fmt.Errorf("parse access rules failed: %w", err)
+	}
+	return p, nil
+}
+
+// IsAdmin tells whether userID is a bot admin
+func (p *Policy) IsAdmin(userID string) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return userID != "" && (contains(p.config.Admins, userID) ||
+		contains(p.local.Admins, userID))
+}
+
+// HasAdmins tells whether anyone can manage the rules
+func (p *Policy) HasAdmins() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return len(p.config.Admins)+len(p.local.Admins) > 0
+}
+
+// Allowed tells whether s may use the bot. Admins always may, then deny
+// lists win over allow lists, and when any allow list is set only those
+// on one may
+func (p *Policy) Allowed(s Subject) bool {
+	if p.IsAdmin(s.UserID) {
+		return true
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	for _, rules := range []*Rules{&p.config, &p.local} {
+		if s.in(rules.DenyUsers) || s.in(rules.DenyChats) ||
+			s.in(rules.DenyDepartments) {
+			return false
+		}
+	}
+	restricted := false
+	for _, rules := range []*Rules{&p.config, &p.local} {
+		if len(rules.AllowUsers)+len(rules.AllowChats)+
+			len(rules.AllowDepartments) > 0 {
+			restricted = true
+		}
+		if s.in(rules.AllowUsers) || s.in(rules.AllowChats) ||
+			s.in(rules.AllowDepartments) {
+			return true
+		}
+	}
+	return !restricted
+}
+
+// Permitted tells whether s may use feature, features without a list are
+// open to everyone allowed to use the bot. A list emptied by revoking is
+// still a list, the feature stays closed until it is unrestricted
+func (p *Policy) Permitted(s Subject, feature string) bool {
+	if p.IsAdmin(s.UserID) {
+		return true
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	configIds, inConfig := p.config.Features[feature]
+	localIds, inLocal := p.local.Features[feature]
+	return (!inConfig && !inLocal) || s.in(configIds) || s.in(localIds)
+}
+
+// NeedsDepartments tells whether any rule names a department, looking up
+// the departments of users is only worth it then. Ids of departments
+// start with "od-"
+func (p *Policy) NeedsDepartments() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	for _, rules := range []*Rules{&p.config, &p.local} {
+		if len(rules.AllowDepartments)+len(rules.DenyDepartments) > 0 {
+			return true
+		}
+		for _, ids := range rules.Features {
+			for _, id := range ids {
+				if strings.HasPrefix(id, "od-") {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// NeedsChats tells whether any rule may name a chat, ids of chats start
+// with "oc_"
+func (p *Policy) NeedsChats() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	for _, rules := range []*Rules{&p.config, &p.local} {
+		if len(rules.AllowChats)+len(rules.DenyChats) > 0 {
+			return true
+		}
+		for _, ids := range rules.Features {
+			for _, id := range ids {
+				if strings.HasPrefix(id, "oc_") {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// Add puts ids on the list named name
+func (p *Policy) Add(name string, ids ...string) error {
+	return p.update(func(local *Rules) error {
+		list := local.list(name)
+		if list == nil {
+			return fmt.Errorf("unknown list %q", name)
+		}
+		*list = union(*list, ids)
+		return nil
+	})
+}
+
+// Remove takes ids off the list named name, ids set in the config stay
+func (p *Policy) Remove(name string, ids ...string) error {
+	if list := p.config.list(name); list != nil {
+		for _, id := range ids {
+			if contains(*list, id) {
+				return fmt.Errorf("%s is on %s in the config", id, name)
+			}
+		}
+	}
+	return p.update(func(local *Rules) error {
+		list := local.list(name)
+		if list == nil {
+			return fmt.Errorf("unknown list %q", name)
+		}
+		*list = subtract(*list, ids)
+		return nil
+	})
+}
+
+// Grant lets ids use feature, which is then closed to everyone else
+func (p *Policy) Grant(feature string, ids ...string) error {
+	return p.update(func(local *Rules) error {
+		if local.Features == nil {
+			local.Features = map[string][]string{}
+		}
+		local.Features[feature] = union(local.Features[feature], ids)
+		return nil
+	})
+}
+
+// Revoke takes ids off the list of feature. A list left empty is kept so
+// the feature stays closed, Unrestrict lifts the restriction
+func (p *Policy) Revoke(feature string, ids ...string) error {
+	for _, id := range ids {
+		if contains(p.config.Features[feature], id) {
+			return fmt.Errorf("%s is granted %s in the config", id, feature)
+		}
+	}
+	return p.update(func(local *Rules) error {
+		granted, ok := local.Features[feature]
+		if !ok {
+			return nil
+		}
+		local.Features[feature] = append([]string{},
+			subtract(granted, ids)...)
+		return nil
+	})
+}
+
+// Unrestrict drops the list of feature, opening it to everyone allowed to
+// use the bot. Lists set in the config stay
+func (p *Policy) Unrestrict(feature string) error {
+	if _, ok := p.config.Features[feature]; ok {
+		return fmt.Errorf("%s is restricted in the config", feature)
+	}
+	return p.update(func(local *Rules) error {
+		delete(local.Features, feature)
+		return nil
+	})
+}
+
+// Rules returns the rules in effect, those of the config and of admins
+func (p *Policy) Rules() Rules {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	rules := Rules{Features: map[string][]string{}}
+	for _, name := range []string{AllowUsers, DenyUsers, AllowChats,
+		DenyChats, AllowDepartments, DenyDepartments, Admins} {
+		list := rules.list(name)
+		*list = union(*p.config.list(name), *p.local.list(name))
+	}
+	for _, features := range []map[string][]string{p.config.Features,
+		p.local.Features} {
+		for feature, ids := range features {
+			rules.Features[feature] = union(rules.Features[feature], ids)
+		}
+	}
+	return rules
+}
+
+func (p *Policy) update(change func(local *Rules) error) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := change(&p.local); err != nil {
+		return err
+	}
+	return p.save()
+}
+
+func (p *Policy) save() error {
+	if p.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(p.local, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p.path), 0o755); err != nil {
+		return fmt.Errorf("create access rules dir failed: %w", err)
+	}
+	tmp := p.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("write access rules failed: %w", err)
+	}
+	return os.Rename(tmp, p.path)
+}
+
+func contains(ids []string, id string) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
+func union(ids []string, more []string) []string {
+	result := append([]string{}, ids...)
+	for _, id := range more {
+		if !contains(result, id) {
+			result = append(result, id)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+func subtract(ids []string, removed []string) []string {
+	var result []string
+	for _, id := range ids {
+		if !contains(removed, id) {
+			result = append(result, id)
+		}
+	}
+	return result
+}
@@ -0,0 +1,128 @@
+package access
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestAllowedAppliesDenyThenAllowLists(t *testing.T) {
+	p, _ := Open(Rules{
+		AllowChats:      []string{"oc_team"},
+		DenyUsers:       []string{"ou_banned"},
+		DenyDepartments: []string{"od-contractors"},
+		Admins:          []string{"ou_admin"},
+	}, "")
+
+	tests := []struct {
+		name    string
+		subject Subject
+		want    bool
+	}{
+		{"allowed chat", Subject{UserID: "ou_1", ChatID: "oc_team"}, true},
+		{"other chat", Subject{UserID: "ou_1", ChatID: "oc_other"}, false},
+		{"denied user", Subject{UserID: "ou_banned", ChatID: "oc_team"}, false},
+		{"denied department", Subject{UserID: "ou_2", ChatID: "oc_team",
+			Departments: []string{"od-contractors"}}, false},
+		{"admin anywhere", Subject{UserID: "ou_admin", ChatID: "oc_other"}, true},
+	}
+	for _, tt := range tests {
+		if got := p.Allowed(tt.subject); got != tt.want {
+			t.Errorf("%s: Allowed = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	open, _ := Open(Rules{}, "")
+	if !open.Allowed(Subject{UserID: "ou_1"}) {
+		t.Error("a policy without rules should allow everyone")
+	}
+}
+
+func TestPermittedRestrictsListedFeatures(t *testing.T) {
+	features, err := ParseFeatures("Pic=ou_artist|od-design; Role=oc_ops")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, _ := Open(Rules{Features: features}, "")
+
+	if !p.Permitted(Subject{UserID: "ou_artist"}, "Pic") ||
+		!p.Permitted(Subject{UserID: "ou_1", Departments: []string{"od-design"}}, "Pic") {
+		t.Error("listed users and departments should be permitted")
+	}
+	if p.Permitted(Subject{UserID: "ou_1"}, "Pic") {
+		t.Error("unlisted user permitted a restricted feature")
+	}
+	if !p.Permitted(Subject{UserID: "ou_1"}, "Balance") {
+		t.Error("unrestricted feature denied")
+	}
+	if !p.NeedsDepartments() || !p.NeedsChats() {
+		t.Error("rules naming departments and chats not detected")
+	}
+
+	if _, err := ParseFeatures("Pic"); err == nil {
+		t.Error("expected an error for a feature without ids")
+	}
+}
+
+func TestAdminChangesPersist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.json")
+	p, err := Open(Rules{Admins: []string{"ou_root"}}, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Add(DenyUsers, "ou_spam"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Add(Admins, "ou_helper"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Grant("Pic", "ou_artist"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Remove(Admins, "ou_root"); err == nil {
+		t.Error("an admin from the config was removed")
+	}
+	if err := p.Add("everyone", "ou_1"); err == nil {
+		t.Error("expected an error for an unknown list")
+	}
+
+	reopened, err := Open(Rules{Admins: []string{"ou_root"}}, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reopened.IsAdmin("ou_helper") ||
+		reopened.Allowed(Subject{UserID: "ou_spam"}) ||
+		reopened.Permitted(Subject{UserID: "ou_1"}, "Pic") {
+		t.Errorf("changes lost after reopening: %+v", reopened.Rules())
+	}
+
+	if err := reopened.Revoke("Pic", "ou_artist"); err != nil {
+		t.Fatal(err)
+	}
+	if err := reopened.Remove(DenyUsers, "ou_spam"); err != nil {
+		t.Fatal(err)
+	}
+	rules := reopened.Rules()
+	if ids, ok := rules.Features["Pic"]; !ok || len(ids) != 0 ||
+		len(rules.DenyUsers) != 0 ||
+		!reflect.DeepEqual(rules.Admins, []string{"ou_helper", "ou_root"}) {
+		t.Errorf("unexpected rules %+v", rules)
+	}
+
+	// revoking the last id keeps the feature closed, also after reopening
+	reopened, err = Open(Rules{}, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, user := range []string{"ou_artist", "ou_1"} {
+		if reopened.Permitted(Subject{UserID: user}, "Pic") {
+			t.Errorf("%s may use Pic after its last grant was revoked", user)
+		}
+	}
+	if err := reopened.Unrestrict("Pic"); err != nil {
+		t.Fatal(err)
+	}
+	if !reopened.Permitted(Subject{UserID: "ou_1"}, "Pic") {
+		t.Error("Pic is still restricted after Unrestrict")
+	}
+}
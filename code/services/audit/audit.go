@@ -0,0 +1,202 @@
+// Package audit keeps an append-only record of what the bot sent to AI
+// providers and on whose behalf. Records are JSON lines in one file per
+// day, each chained to the previous one by a SHA-256 hash so edits and
+// deletions inside the retention window can be detected.
+package audit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"start-feishubot/logger"
+)
+
+// Content modes tell how prompts and responses are kept
+const (
+	ContentHash = "hash" // only the SHA-256 and length
+	ContentFull = "full" // the text as well
+)
+
+// maxTextBytes bounds the text kept in full mode, larger or binary bodies
+// such as images are only hashed
+const maxTextBytes = 256 << 10
+
+// Kinds of records
+const (
+	KindAction       = "action"        // a message handled by the action chain
+	KindProviderCall = "provider_call" // a request sent to an AI provider
+)
+
+// Record is one audited interaction
+type Record struct {
+	Time        time.Time    `json:"time"`
+	Kind        string       `json:"kind"`
+	RequestID   string       `json:"request_id,omitempty"`
+	UserID      string       `json:"user_id,omitempty"`
+	ChatID      string       `json:"chat_id,omitempty"`
+	SessionID   string       `json:"session_id,omitempty"`
+	MsgID       string       `json:"msg_id,omitempty"`
+	Action      string       `json:"action,omitempty"`
+	Endpoint    string       `json:"endpoint,omitempty"`
+	Model       string       `json:"model,omitempty"`
+	Prompt      *Content     `json:"prompt,omitempty"`
+	Response    *Content     `json:"response,omitempty"`
+	Attachments []Attachment `json:"attachments,omitempty"`
+	Error       string       `json:"error,omitempty"`
+	PrevHash    string       `json:"prev_hash"`
+	Hash        string       `json:"hash"`
+}
+
+// Content describes a prompt or response
+type Content struct {
+	SHA256 string `json:"sha256"`
+	Bytes  int    `json:"bytes"`
+	Text   string `json:"text,omitempty"`
+}
+
+// Attachment describes a file, image or audio sent with a message
+type Attachment struct {
+	Type string `json:"type"`
+	Key  string `json:"key"`
+	Name string `json:"name,omitempty"`
+}
+
+// Scope identifies who an interaction is for, records made under a
+// context carrying it are filled from it
+type Scope struct {
+	RequestID string
+	UserID    string
+	ChatID    string
+	SessionID string
+	MsgID     string
+	Action    string
+}
+
+type scopeKey struct{}
+
+// WithScope returns a context whose records carry scope
+func WithScope(ctx context.Context, scope Scope) context.Context {
+	return context.WithValue(ctx, scopeKey{}, scope)
+}
+
+// ScopeFrom returns the scope carried by ctx
+func ScopeFrom(ctx context.Context) Scope {
+	scope, _ := ctx.Value(scopeKey{}).(Scope)
+	return scope
+}
+
+// Options configure the audit log
+type Options struct {
+	Dir           string
+	Content       string // ContentHash or ContentFull
+	RetentionDays int    // 0 keeps records forever
+}
+
+var active struct {
+	sync.RWMutex
+	log *Log
+}
+
+// Init opens the audit log in opts.Dir, the Record functions write to it
+// from then on
+func Init(opts Options) error {
+	log, err := Open(opts)
+	if err != nil {
+		return err
+	}
+	active.Lock()
+	previous := active.log
+	active.log = log
+	active.Unlock()
+	if previous != nil {
+		previous.Close()
+	}
+	return nil
+}
+
+// Default returns the log opened by Init, or nil when auditing is off
+func Default() *Log {
+	active.RLock()
+	defer active.RUnlock()
+	return active.log
+}
+
+// Enabled tells whether records are kept
+func Enabled() bool {
+	return Default() != nil
+}
+
+// RecordAction appends record to the default log, filling the ids from the
+// scope of ctx. It does nothing when auditing is off
+func RecordAction(ctx context.Context, record Record) {
+	log := Default()
+	if log == nil {
+		return
+	}
+	record.Kind = KindAction
+	log.append(ctx, record)
+}
+
+// RecordProviderCall audits a request sent to an AI provider with the
+// body sent and the body received
+func RecordProviderCall(ctx context.Context, endpoint, model string,
+	request, response []byte, err error) {
+	log := Default()
+	if log == nil {
+		return
+	}
+	record := Record{
+		Kind:     KindProviderCall,
+		Endpoint: endpoint,
+		Model:    model,
+		Prompt:   log.content(request),
+		Response: log.content(response),
+	}
+	if err != nil {
+		record.Error = err.Error()
+	}
+	log.append(ctx, record)
+}
+
+// Text describes a prompt given as text, such as the message a user sent
+func Text(text string) *Content {
+	log := Default()
+	if log == nil {
+		return nil
+	}
+	return log.content([]byte(text))
+}
+
+func (l *Log) content(data []byte) *Content {
+	if data == nil {
+		return nil
+	}
+	sum := sha256.Sum256(data)
+	content := &Content{SHA256: hex.EncodeToString(sum[:]), Bytes: len(data)}
+	if l.contentMode == ContentFull && len(data) <= maxTextBytes &&
+		utf8.Valid(data) {
+		content.Text = string(data)
+	}
+	return content
+}
+
+func withScope(ctx context.Context, record Record) Record {
+	scope := ScopeFrom(ctx)
+	fill := func(field *string, value string) {
+		if *field == "" {
+			*field = value
+		}
+	}
+	fill(&record.RequestID, scope.RequestID)
+	fill(&record.RequestID, logger.RequestID(ctx))
+	fill(&record.UserID, scope.UserID)
+	fill(&record.ChatID, scope.ChatID)
+	fill(&record.SessionID, scope.SessionID)
+	fill(&record.MsgID, scope.MsgID)
+	fill(&record.Action, scope.Action)
+	return record
+}
@@ -0,0 +1,169 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func initLog(t *testing.T, dir string, content string) *Log {
+	t.Helper()
+	if err := Init(Options{Dir: dir, Content: content, RetentionDays: 30}); err != nil {
+		t.Fatal(err)
+	}
+	log := Default()
+	t.Cleanup(func() {
+		active.Lock()
+		active.log = nil
+		active.Unlock()
+		log.Close()
+	})
+	return log
+}
+
+func readRecords(t *testing.T, log *Log) []Record {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := log.Export(&buf, time.Now().Add(-time.Hour),
+		time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	var records []Record
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var record Record
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("%v: %s", err, line)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestRecordsCarryScopeAndChain(t *testing.T) {
+	dir := t.TempDir()
+	log := initLog(t, dir, ContentHash)
+	ctx := WithScope(context.Background(), Scope{UserID: "ou_1",
+		ChatID: "oc_1", SessionID: "om_1", MsgID: "om_2", Action: "Message"})
+
+	RecordProviderCall(ctx, "chat/completions", "gpt-4o",
+		[]byte(`{"messages":"my salary is 42"}`), []byte(`{"answer":"ok"}`), nil)
+	RecordAction(ctx, Record{Prompt: Text("my salary is 42"),
+		Attachments: []Attachment{{Type: "file", Key: "file_1", Name: "a.pdf"}}})
+
+	records := readRecords(t, log)
+	if len(records) != 2 {
+		t.Fatalf("got %d records, want 2", len(records))
+	}
+	call, action := records[0], records[1]
+	if call.Kind != KindProviderCall || call.Model != "gpt-4o" ||
+		call.UserID != "ou_1" || call.ChatID != "oc_1" ||
+		call.SessionID != "om_1" || call.Action != "Message" {
+		t.Errorf("unexpected provider call %+v", call)
+	}
+	if action.Kind != KindAction || action.Attachments[0].Name != "a.pdf" ||
+		action.Prompt.Bytes != len("my salary is 42") {
+		t.Errorf("unexpected action %+v", action)
+	}
+	if action.Prompt.Text != "" || call.Prompt.Text != "" {
+		t.Error("text kept in hash mode")
+	}
+	if call.PrevHash != "" || action.PrevHash != call.Hash {
+		t.Errorf("records are not chained: %q -> %q", call.Hash, action.PrevHash)
+	}
+
+	// reopening continues the chain
+	log = initLog(t, dir, ContentFull)
+	RecordAction(ctx, Record{Prompt: Text("hello")})
+	records = readRecords(t, log)
+	if last := records[2]; last.PrevHash != action.Hash ||
+		last.Prompt.Text != "hello" {
+		t.Errorf("unexpected record after reopening %+v", last)
+	}
+}
+
+func TestVerifyDetectsTampering(t *testing.T) {
+	log := initLog(t, t.TempDir(), ContentFull)
+	ctx := context.Background()
+	RecordAction(ctx, Record{Prompt: Text("first")})
+	RecordProviderCall(ctx, "images/generations", "dall-e-3", []byte("a cat"),
+		nil, errors.New("rate limited"))
+	RecordAction(ctx, Record{Prompt: Text("third")})
+
+	var buf bytes.Buffer
+	log.Export(&buf, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	if _, err := Verify(bytes.NewReader(buf.Bytes()), ""); err != nil {
+		t.Fatalf("intact log rejected: %v", err)
+	}
+
+	edited := bytes.Replace(buf.Bytes(), []byte("first"), []byte("fixed"), 1)
+	if _, err := Verify(bytes.NewReader(edited), ""); err == nil {
+		t.Error("edited record accepted")
+	}
+	lines := bytes.SplitAfter(buf.Bytes(), []byte("\n"))
+	removed := append(append([]byte{}, lines[0]...), lines[2]...)
+	if _, err := Verify(bytes.NewReader(removed), ""); err == nil {
+		t.Error("removed record accepted")
+	}
+}
+
+func TestRetentionRemovesOldFiles(t *testing.T) {
+	dir := t.TempDir()
+	old := filepath.Join(dir, "audit-2000-01-01.jsonl")
+	recent := filepath.Join(dir, "audit-"+time.Now().UTC().Format(dayLayout)+".jsonl")
+	for _, path := range []string{old, recent} {
+		if err := os.WriteFile(path, nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	initLog(t, dir, ContentHash)
+	if _, err := os.Stat(old); !os.IsNotExist(err) {
+		t.Error("file past the retention kept")
+	}
+	if _, err := os.Stat(recent); err != nil {
+		t.Errorf("recent file removed: %v", err)
+	}
+}
+
+func TestExportHandlerRequiresToken(t *testing.T) {
+	initLog(t, t.TempDir(), ContentHash)
+	RecordAction(context.Background(), Record{Prompt: Text("hi")})
+	handler := ExportHandler("s3cret-token")
+
+	for _, auth := range []string{"", "Bearer wrong"} {
+		req := httptest.NewRequest(http.MethodGet, "/admin/audit/export", nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("authorization %q got status %d", auth, w.Code)
+		}
+	}
+
+	today := time.Now().UTC().Format(dayLayout)
+	req := httptest.NewRequest(http.MethodGet,
+		"/admin/audit/export?from="+today+"&to="+today, nil)
+	req.Header.Set("Authorization", "Bearer s3cret-token")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || strings.Count(w.Body.String(), "\n") != 1 {
+		t.Errorf("status %d, body %q", w.Code, w.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/admin/audit/export?from=yesterday", nil)
+	req.Header.Set("Authorization", "Bearer s3cret-token")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("invalid bound got status %d", w.Code)
+	}
+}
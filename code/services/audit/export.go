@@ -0,0 +1,78 @@
+package audit
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"start-feishubot/logger"
+)
+
+// ExportHandler serves the records between the from and to query
+// parameters as JSON lines to requests bearing token. Both bounds accept
+// RFC 3339 times or dates and default to the last 24 hours
+func ExportHandler(token string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(r, token) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		log := Default()
+		if log == nil {
+			http.Error(w, "audit log is off", http.StatusNotFound)
+			return
+		}
+		now := time.Now()
+		from, err := parseBound(r.URL.Query().Get("from"), now.Add(-24*time.Hour), false)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		to, err := parseBound(r.URL.Query().Get("to"), now, true)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		logger.Ctx(r.Context()).Infof("audit export from %s to %s",
+			from.Format(time.RFC3339), to.Format(time.RFC3339))
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(
+			"attachment; filename=audit-%s-%s.jsonl",
+			from.UTC().Format(dayLayout), to.UTC().Format(dayLayout)))
+		if err := log.Export(w, from, to); err != nil {
+			logger.Ctx(r.Context()).Errorf("audit export failed: %v", err)
+		}
+	})
+}
+
+func authorized(r *http.Request, token string) bool {
+	if token == "" {
+		return false
+	}
+	given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1
+}
+
+// parseBound parses a time or a date, a date as upper bound includes the
+// whole day
+func parseBound(value string, fallback time.Time, end bool) (time.Time, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(dayLayout, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q, expected RFC 3339 or %s",
+			value, dayLayout)
+	}
+	if end {
+		t = t.Add(24*time.Hour - time.Nanosecond)
+	}
+	return t, nil
+}
@@ -0,0 +1,322 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"start-feishubot/logger"
+)
+
+const (
+	filePrefix = "audit-"
+	fileSuffix = ".jsonl"
+	dayLayout  = "2006-01-02"
+)
+
+// pruneInterval is how often files past the retention are removed
+const pruneInterval = time.Hour
+
+// Log appends records to daily files. Files are only ever opened for
+// appending and removed whole once past the retention
+type Log struct {
+	dir         string
+	contentMode string
+	retention   time.Duration
+
+	mu       sync.Mutex
+	file     *os.File
+	day      string
+	prevHash string
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// Open opens the audit log in opts.Dir, continuing the hash chain of the
+// latest file and removing files past the retention
+func Open(opts Options) (*Log, error) {
+	mode := strings.ToLower(opts.Content)
+	switch mode {
+	case "":
+		mode = ContentHash
+	case ContentHash, ContentFull:
+	default:
+		return nil, fmt.Errorf("invalid audit content mode %q", opts.Content)
+	}
+	if err := os.MkdirAll(opts.Dir, 0o700); err != nil {
+		return nil, fmt.Errorf("create audit dir failed: %w", err)
+	}
+	l := &Log{
+		dir:         opts.Dir,
+		contentMode: mode,
+		retention:   time.Duration(opts.RetentionDays) * 24 * time.Hour,
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	days, err := l.days()
+	if err != nil {
+		return nil, err
+	}
+	if len(days) > 0 {
+		last, err := lastHash(l.path(days[len(days)-1]))
+		if err != nil {
+			return nil, err
+		}
+		l.prevHash = last
+	}
+	l.prune(time.Now())
+	go l.run()
+	return l, nil
+}
+
+// Close stops the retention and closes the current file
+func (l *Log) Close() error {
+	select {
+	case <-l.stop:
+		return nil
+	default:
+		close(l.stop)
+	}
+	<-l.done
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+func (l *Log) run() {
+	defer close(l.done)
+	ticker := time.NewTicker(pruneInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			l.prune(now)
+		case <-l.stop:
+			return
+		}
+	}
+}
+
+// append writes record, a failure is logged but never fails the
+// interaction being audited
+func (l *Log) append(ctx context.Context, record Record) {
+	if err := l.write(withScope(ctx, record)); err != nil {
+		logger.Ctx(ctx).Errorf("audit record failed: %v", err)
+	}
+}
+
+func (l *Log) write(record Record) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	record.Time = time.Now().UTC()
+	day := record.Time.Format(dayLayout)
+	if l.file == nil || l.day != day {
+		if l.file != nil {
+			l.file.Close()
+		}
+		file, err := os.OpenFile(l.path(day),
+			os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+		if err != nil {
+			l.file = nil
+			return fmt.Errorf("open audit file failed: %w", err)
+		}
+		l.file, l.day = file, day
+	}
+
+	record.PrevHash = l.prevHash
+	hash, err := recordHash(record)
+	if err != nil {
+		return err
+	}
+	record.Hash = hash
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if _, err := l.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("write audit file failed: %w", err)
+	}
+	l.prevHash = hash
+	return nil
+}
+
+// recordHash is the SHA-256 of the record without its own hash, it covers
+// the previous hash so the records form a chain
+func recordHash(record Record) (string, error) {
+	record.Hash = ""
+	data, err := json.Marshal(record)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Verify reads records from r and checks each is intact and follows the
+// previous one, it returns the hash of the last record
+func Verify(r io.Reader, prevHash string) (string, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64<<10), 4*maxTextBytes)
+	for n := 1; scanner.Scan(); n++ {
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return "", fmt.Errorf("record %d: %w", n, err)
+		}
+		if record.PrevHash != prevHash {
+			return "", fmt.Errorf("record %d does not follow the previous one", n)
+		}
+		hash, err := recordHash(record)
+		if err != nil {
+			return "", err
+		}
+		if hash != record.Hash {
+			return "", fmt.Errorf("record %d was modified", n)
+		}
+		prevHash = hash
+	}
+	return prevHash, scanner.Err()
+}
+
+func (l *Log) path(day string) string {
+	return filepath.Join(l.dir, filePrefix+day+fileSuffix)
+}
+
+// days lists the days having a file, oldest first
+func (l *Log) days() ([]string, error) {
+	entries, err := os.ReadDir(l.dir)
+	if err != nil {
+		return nil, fmt.Errorf("read audit dir failed: %w", err)
+	}
+	var days []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, filePrefix) ||
+			!strings.HasSuffix(name, fileSuffix) {
+			continue
+		}
+		day := strings.TrimSuffix(strings.TrimPrefix(name, filePrefix), fileSuffix)
+		if _, err := time.Parse(dayLayout, day); err == nil {
+			days = append(days, day)
+		}
+	}
+	sort.Strings(days)
+	return days, nil
+}
+
+// prune removes the files of days entirely past the retention
+func (l *Log) prune(now time.Time) {
+	if l.retention <= 0 {
+		return
+	}
+	days, err := l.days()
+	if err != nil {
+		logger.Errorf("audit retention failed: %v", err)
+		return
+	}
+	cutoff := now.UTC().Add(-l.retention)
+	for _, day := range days {
+		start, _ := time.Parse(dayLayout, day)
+		if !start.Add(24 * time.Hour).Before(cutoff) {
+			break
+		}
+		l.mu.Lock()
+		if day == l.day && l.file != nil {
+			l.file.Close()
+			l.file, l.day = nil, ""
+		}
+		err := os.Remove(l.path(day))
+		l.mu.Unlock()
+		if err != nil {
+			logger.Errorf("remove audit file failed: %v", err)
+			continue
+		}
+		logger.Infof("audit file of %s removed after %d days retention",
+			day, int(l.retention.Hours()/24))
+	}
+}
+
+// Export writes the records made between from and to as JSON lines
+func (l *Log) Export(w io.Writer, from, to time.Time) error {
+	days, err := l.days()
+	if err != nil {
+		return err
+	}
+	first, last := from.UTC().Format(dayLayout), to.UTC().Format(dayLayout)
+	for _, day := range days {
+		if day < first || day > last {
+			continue
+		}
+		if err := l.exportFile(w, l.path(day), from, to); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (l *Log) exportFile(w io.Writer, path string, from, to time.Time) error {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		// removed by the retention meanwhile
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("open audit file failed: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64<<10), 4*maxTextBytes)
+	for scanner.Scan() {
+		var record struct {
+			Time time.Time `json:"time"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			continue
+		}
+		if record.Time.Before(from) || record.Time.After(to) {
+			continue
+		}
+		if _, err := w.Write(append(scanner.Bytes(), '\n')); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// lastHash returns the hash of the last record in path
+func lastHash(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("read audit file failed: %w", err)
+	}
+	data = bytes.TrimRight(data, "\n")
+	if i := bytes.LastIndexByte(data, '\n'); i >= 0 {
+		data = data[i+1:]
+	}
+	if len(data) == 0 {
+		return "", nil
+	}
+	var record Record
+	if err := json.Unmarshal(data, &record); err != nil {
+		return "", fmt.Errorf("parse last audit record failed: %w", err)
+	}
+	return record.Hash, nil
+}
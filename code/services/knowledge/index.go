@@ -0,0 +1,171 @@
+package knowledge
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// Chunk is a piece of a source document and its embedding
+type Chunk struct {
+	Text   string    `json:"text"`
+	Vector []float32 `json:"vector"`
+}
+
+type source struct {
+	Hash   string  `json:"hash"`
+	Chunks []Chunk `json:"chunks"`
+}
+
+// Result is a chunk matching a query
+type Result struct {
+	Source string
+	Text   string
+	Score  float64
+}
+
+// Index keeps the embedded chunks in memory and persists them to a JSON
+// file, a brute force search is fast enough for a team knowledge base
+type Index struct {
+	path string
+	mu   sync.RWMutex
+	// saves run one at a time, they share the temporary file
+	saveMu  sync.Mutex
+	Model   string             `json:"model"`
+	Sources map[string]*source `json:"sources"`
+}
+
+// OpenIndex loads the index at path, an index embedded with another model
+// is discarded since its vectors are not comparable
+func OpenIndex(path string, model string) (*Index, error) {
+	index := &Index{path: path, Model: model, Sources: map[string]*source{}}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return index, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read index failed: %w", err)
+	}
+	stored := &Index{}
+	if err := json.Unmarshal(data, stored); err != nil {
+		return nil, fmt.Errorf("parse index failed: %w", err)
+	}
+	if stored.Model == model && stored.Sources != nil {
+		index.Sources = stored.Sources
+	}
+	return index, nil
+}
+
+// Hash returns the content hash recorded for name
+func (i *Index) Hash(name string) string {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	if s, ok := i.Sources[name]; ok {
+		return s.Hash
+	}
+	return ""
+}
+
+// Put replaces the chunks of name
+func (i *Index) Put(name string, hash string, chunks []Chunk) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.Sources[name] = &source{Hash: hash, Chunks: chunks}
+}
+
+// Remove drops the chunks of name
+func (i *Index) Remove(name string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	delete(i.Sources, name)
+}
+
+// Names lists the sources in the index
+func (i *Index) Names() []string {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	names := make([]string, 0, len(i.Sources))
+	for name := range i.Sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Len returns the number of chunks in the index
+func (i *Index) Len() int {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	n := 0
+	for _, s := range i.Sources {
+		n += len(s.Chunks)
+	}
+	return n
+}
+
+// Search returns the k chunks most similar to vector
+func (i *Index) Search(vector []float32, k int) []Result {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	var results []Result
+	for name, s := range i.Sources {
+		for _, chunk := range s.Chunks {
+			results = append(results, Result{
+				Source: name,
+				Text:   chunk.Text,
+				Score:  cosine(vector, chunk.Vector),
+			})
+		}
+	}
+	sort.SliceStable(results, func(a, b int) bool {
+		if results[a].Score != results[b].Score {
+			return results[a].Score > results[b].Score
+		}
+		return results[a].Source < results[b].Source
+	})
+	if len(results) > k {
+		results = results[:k]
+	}
+	return results
+}
+
+// Save writes the index to a temporary file and renames it over the old
+// one, so a crash never leaves a truncated index behind
+func (i *Index) Save() error {
+	i.saveMu.Lock()
+	defer i.saveMu.Unlock()
+	i.mu.RLock()
+	data, err := json.Marshal(i)
+	i.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(i.path), 0o755); err != nil {
+		return fmt.Errorf("create index dir failed: %w", err)
+	}
+	tmp := i.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("write index failed: %w", err)
+	}
+	return os.Rename(tmp, i.path)
+}
+
+func cosine(a, b []float32) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
@@ -0,0 +1,196 @@
+// Package knowledge answers questions from a team knowledge base: documents
+// are split into chunks, embedded through the provider and searched by
+// cosine similarity.
+package knowledge
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"start-feishubot/logger"
+	"start-feishubot/utils/document"
+)
+
+const (
+	chunkBytes = 1500
+	// inputs sent in a single embeddings request
+	embeddingBatch = 64
+	// chunks less similar to the question than this are not worth citing
+	minScore = 0.2
+)
+
+// UploadPrefix marks the sources added from chat rather than the directory
+const UploadPrefix = "uploads/"
+
+// Embedder computes embeddings, *openai.ChatGPT implements it
+type Embedder interface {
+	Embeddings(model string, input []string) ([][]float32, error)
+}
+
+type Base struct {
+	embedder Embedder
+	model    string
+	index    *Index
+}
+
+// Open loads the on-disk index at indexPath
+func Open(embedder Embedder, model string, indexPath string) (*Base, error) {
+	index, err := OpenIndex(indexPath, model)
+	if err != nil {
+		return nil, err
+	}
+	return &Base{embedder: embedder, model: model, index: index}, nil
+}
+
+// IngestDir adds every supported document under dir, documents unchanged
+// since the last run are not embedded again and deleted ones are dropped.
+// A document that cannot be read or embedded is logged and skipped, its
+// previous chunks are kept, and the error reports how many failed
+func (b *Base) IngestDir(dir string) (int, error) {
+	seen := map[string]bool{}
+	ingested, failed := 0, 0
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == dir {
+				return err
+			}
+			logger.Warnf("knowledge base skipped %s: %v", path, err)
+			failed++
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+		if _, err := document.KindOf(path); err != nil {
+			return nil
+		}
+		name, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		name = filepath.ToSlash(name)
+		seen[name] = true
+		added, err := b.ingestPath(name, path)
+		if errors.Is(err, document.ErrNoText) {
+			logger.Warnf("knowledge base skipped %s: %v", name, err)
+			return nil
+		}
+		if err != nil {
+			logger.Errorf("knowledge base failed to ingest %s: %v", name, err)
+			failed++
+			return nil
+		}
+		if added {
+			ingested++
+		}
+		return nil
+	})
+	if err != nil {
+		// without a listing every document would look deleted
+		return ingested, err
+	}
+	for _, name := range b.index.Names() {
+		if !seen[name] && !strings.HasPrefix(name, UploadPrefix) {
+			b.index.Remove(name)
+		}
+	}
+	if err := b.index.Save(); err != nil {
+		return ingested, err
+	}
+	if failed > 0 {
+		return ingested, fmt.Errorf("%d documents failed to ingest", failed)
+	}
+	return ingested, nil
+}
+
+// ingestPath reads and ingests the file at path, a parser panic on it is
+// returned as an error so the other documents are still ingested
+func (b *Base) ingestPath(name string, path string) (_ bool, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("ingest panic: %v", r)
+		}
+	}()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+	return b.IngestFile(name, data)
+}
+
+// IngestFile extracts and embeds a document, reporting false when the
+// index already holds the same content
+func (b *Base) IngestFile(name string, data []byte) (bool, error) {
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	if b.index.Hash(name) == hash {
+		return false, nil
+	}
+	text, err := document.Extract(name, data)
+	if err != nil {
+		return false, err
+	}
+	if err := b.add(name, hash, document.Chunk(text, chunkBytes)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// AddChunks embeds chunks already extracted from an uploaded document and
+// saves the index
+func (b *Base) AddChunks(name string, chunks []string) error {
+	name = UploadPrefix + name
+	sum := sha256.Sum256([]byte(strings.Join(chunks, "\n")))
+	if err := b.add(name, hex.EncodeToString(sum[:]), chunks); err != nil {
+		return err
+	}
+	return b.index.Save()
+}
+
+func (b *Base) add(name string, hash string, texts []string) error {
+	chunks := make([]Chunk, 0, len(texts))
+	for start := 0; start < len(texts); start += embeddingBatch {
+		end := start + embeddingBatch
+		if end > len(texts) {
+			end = len(texts)
+		}
+		vectors, err := b.embedder.Embeddings(b.model, texts[start:end])
+		if err != nil {
+			return fmt.Errorf("embed failed: %w", err)
+		}
+		for i, vector := range vectors {
+			chunks = append(chunks, Chunk{Text: texts[start+i], Vector: vector})
+		}
+	}
+	b.index.Put(name, hash, chunks)
+	return nil
+}
+
+// Retrieve returns the k chunks closest to query
+func (b *Base) Retrieve(query string, k int) ([]Result, error) {
+	if b.index.Len() == 0 || strings.TrimSpace(query) == "" {
+		return nil, nil
+	}
+	vectors, err := b.embedder.Embeddings(b.model, []string{query})
+	if err != nil {
+		return nil, fmt.Errorf("embed query failed: %w", err)
+	}
+	var results []Result
+	for _, result := range b.index.Search(vectors[0], k) {
+		if result.Score >= minScore {
+			results = append(results, result)
+		}
+	}
+	return results, nil
+}
+
+// Len returns the number of chunks in the knowledge base
+func (b *Base) Len() int {
+	return b.index.Len()
+}
@@ -0,0 +1,194 @@
+package knowledge
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"start-feishubot/services/loadbalancer"
+	"start-feishubot/services/openai"
+)
+
+// newFakeEmbeddingsServer embeds texts as bags of hashed words, texts
+// sharing words end up close to each other. Texts with the word
+// unembeddable are rejected
+func newFakeEmbeddingsServer(t *testing.T, requests *int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,
+		r *http.Request) {
+		if r.URL.Path != "/v1/embeddings" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		var body openai.EmbeddingRequestBody
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		*requests++
+		for _, input := range body.Input {
+			if strings.Contains(input, "unembeddable") {
+				http.Error(w, "input rejected", http.StatusBadRequest)
+				return
+			}
+		}
+		type data struct {
+			Embedding []float32 `json:"embedding"`
+			Index     int       `json:"index"`
+		}
+		var resp struct {
+			Data []data `json:"data"`
+		}
+		for i, input := range body.Input {
+			vector := make([]float32, 64)
+			for _, word := range strings.Fields(strings.ToLower(input)) {
+				h := fnv.New32a()
+				h.Write([]byte(strings.Trim(word, ".,?!")))
+				vector[h.Sum32()%64]++
+			}
+			resp.Data = append(resp.Data, data{Embedding: vector, Index: i})
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+}
+
+func newTestBase(t *testing.T, url string, indexPath string) *Base {
+	gpt := &openai.ChatGPT{
+		Lb:       loadbalancer.NewLoadBalancer([]string{"sk-test"}),
+		ApiUrl:   url,
+		Platform: openai.OpenAI,
+	}
+	base, err := Open(gpt, "text-embedding-3-small", indexPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base
+}
+
+func TestKnowledgeBase(t *testing.T) {
+	requests := 0
+	server := newFakeEmbeddingsServer(t, &requests)
+	defer server.Close()
+
+	dir := t.TempDir()
+	docs := filepath.Join(dir, "docs")
+	os.MkdirAll(filepath.Join(docs, "seo"), 0o755)
+	os.WriteFile(filepath.Join(docs, "seo", "backlinks.md"),
+		[]byte("Backlinks from relevant sites improve rankings."), 0o644)
+	os.WriteFile(filepath.Join(docs, "crawling.txt"),
+		[]byte("Sitemaps help crawlers discover new pages."), 0o644)
+	os.WriteFile(filepath.Join(docs, "logo.png"), []byte("png"), 0o644)
+	indexPath := filepath.Join(dir, "index", "knowledge.json")
+
+	base := newTestBase(t, server.URL, indexPath)
+	ingested, err := base.IngestDir(docs)
+	if err != nil {
+		t.Fatalf("IngestDir() error = %v", err)
+	}
+	if ingested != 2 {
+		t.Errorf("IngestDir() ingested = %d, want 2", ingested)
+	}
+
+	results, err := base.Retrieve("how do backlinks improve rankings?", 1)
+	if err != nil {
+		t.Fatalf("Retrieve() error = %v", err)
+	}
+	if len(results) != 1 || results[0].Source != "seo/backlinks.md" {
+		t.Errorf("Retrieve() got = %+v, want seo/backlinks.md", results)
+	}
+
+	// a reopened index skips unchanged documents and drops deleted ones
+	os.Remove(filepath.Join(docs, "crawling.txt"))
+	requests = 0
+	base = newTestBase(t, server.URL, indexPath)
+	if ingested, err = base.IngestDir(docs); err != nil || ingested != 0 {
+		t.Errorf("IngestDir() again = %d, %v, want 0, nil", ingested, err)
+	}
+	if requests != 0 {
+		t.Errorf("IngestDir() again sent %d embedding requests", requests)
+	}
+	if names := base.index.Names(); len(names) != 1 || names[0] != "seo/backlinks.md" {
+		t.Errorf("index names = %v, want [seo/backlinks.md]", names)
+	}
+
+	if err := base.AddChunks("audit.pdf", []string{"Audit the sitemap monthly."}); err != nil {
+		t.Fatalf("AddChunks() error = %v", err)
+	}
+	results, err = base.Retrieve("sitemap audit", 4)
+	if err != nil {
+		t.Fatalf("Retrieve() error = %v", err)
+	}
+	if len(results) == 0 || results[0].Source != UploadPrefix+"audit.pdf" {
+		t.Errorf("Retrieve() got = %+v, want uploaded audit.pdf first", results)
+	}
+}
+
+func TestIngestDirSkipsFailedDocuments(t *testing.T) {
+	requests := 0
+	server := newFakeEmbeddingsServer(t, &requests)
+	defer server.Close()
+
+	dir := t.TempDir()
+	docs := filepath.Join(dir, "docs")
+	os.MkdirAll(docs, 0o755)
+	os.WriteFile(filepath.Join(docs, "crawling.txt"),
+		[]byte("Sitemaps help crawlers discover new pages."), 0o644)
+	indexPath := filepath.Join(dir, "knowledge.json")
+	base := newTestBase(t, server.URL, indexPath)
+	if _, err := base.IngestDir(docs); err != nil {
+		t.Fatalf("IngestDir() error = %v", err)
+	}
+
+	os.Remove(filepath.Join(docs, "crawling.txt"))
+	os.WriteFile(filepath.Join(docs, "broken.pdf"),
+		[]byte("%PDF-1.4 <0001> unembeddable"), 0o644)
+	os.WriteFile(filepath.Join(docs, "rejected.md"),
+		[]byte("This text is unembeddable."), 0o644)
+	os.WriteFile(filepath.Join(docs, "backlinks.md"),
+		[]byte("Backlinks from relevant sites improve rankings."), 0o644)
+	ingested, err := base.IngestDir(docs)
+	if err == nil || ingested != 1 {
+		t.Errorf("IngestDir() = %d, %v, want 1 and an error", ingested, err)
+	}
+
+	// the deleted document is pruned and the index saved despite failures
+	saved, err := OpenIndex(indexPath, "text-embedding-3-small")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := saved.Names(); len(names) != 1 || names[0] != "backlinks.md" {
+		t.Errorf("saved index names = %v, want [backlinks.md]", names)
+	}
+}
+
+func TestIndexConcurrentSaves(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "index.json")
+	index, err := OpenIndex(path, "text-embedding-3-small")
+	if err != nil {
+		t.Fatal(err)
+	}
+	errs := make(chan error, 16)
+	for n := 0; n < cap(errs); n++ {
+		go func(n int) {
+			index.Put(fmt.Sprintf("doc%d.md", n), "hash",
+				[]Chunk{{Text: "text", Vector: []float32{1}}})
+			errs <- index.Save()
+		}(n)
+	}
+	for n := 0; n < cap(errs); n++ {
+		if err := <-errs; err != nil {
+			t.Errorf("Save() error = %v", err)
+		}
+	}
+
+	saved, err := OpenIndex(path, "text-embedding-3-small")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Len() != index.Len() {
+		t.Errorf("saved index has %d chunks, want %d", saved.Len(), index.Len())
+	}
+}
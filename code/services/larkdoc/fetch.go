@@ -0,0 +1,212 @@
+package larkdoc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	lark "github.com/larksuite/oapi-sdk-go/v3"
+	larkcore "github.com/larksuite/oapi-sdk-go/v3/core"
+	larkdocx "github.com/larksuite/oapi-sdk-go/v3/service/docx/v1"
+	larksheets "github.com/larksuite/oapi-sdk-go/v3/service/sheets/v3"
+	larkwiki "github.com/larksuite/oapi-sdk-go/v3/service/wiki/v2"
+)
+
+// ErrUnsupported is returned for wiki nodes of types that cannot be read
+// as text, such as mind notes and files
+var ErrUnsupported = errors.New("unsupported document type")
+
+// Document is the text content of a linked document
+type Document struct {
+	Kind    Kind
+	Title   string
+	URL     string
+	Content string
+}
+
+type Fetcher struct {
+	client *lark.Client
+}
+
+func NewFetcher(client *lark.Client) *Fetcher {
+	return &Fetcher{client: client}
+}
+
+// Fetch reads the document behind link, wiki nodes are resolved to the
+// document they hold
+func (f *Fetcher) Fetch(ctx context.Context, link Link) (*Document, error) {
+	doc := &Document{Kind: link.Kind, URL: link.URL}
+	var err error
+	switch link.Kind {
+	case KindDocx:
+		err = f.fetchDocx(ctx, link.Token, doc)
+	case KindDoc:
+		err = f.fetchDoc(ctx, link.Token, doc)
+	case KindSheet:
+		err = f.fetchSheet(ctx, link.Token, link.SheetId, doc)
+	case KindWiki:
+		err = f.fetchWiki(ctx, link, doc)
+	default:
+		err = ErrUnsupported
+	}
+	if err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+func (f *Fetcher) fetchWiki(ctx context.Context, link Link,
+	doc *Document) error {
+	req := larkwiki.NewGetNodeSpaceReqBuilder().Token(link.Token).Build()
+	resp, err := f.client.Wiki.Space.GetNode(ctx, req)
+	if err != nil {
+		return err
+	}
+	if !resp.Success() {
+		return codeError("get wiki node", resp.CodeError)
+	}
+	node := resp.Data.Node
+	if node == nil || node.ObjToken == nil || node.ObjType == nil {
+		return fmt.Errorf("wiki node %s has no document", link.Token)
+	}
+	switch *node.ObjType {
+	case "docx":
+		doc.Kind = KindDocx
+		err = f.fetchDocx(ctx, *node.ObjToken, doc)
+	case "doc":
+		doc.Kind = KindDoc
+		err = f.fetchDoc(ctx, *node.ObjToken, doc)
+	case "sheet":
+		doc.Kind = KindSheet
+		err = f.fetchSheet(ctx, *node.ObjToken, link.SheetId, doc)
+	default:
+		return fmt.Errorf("%w: %s", ErrUnsupported, *node.ObjType)
+	}
+	if err == nil && node.Title != nil && doc.Title == "" {
+		doc.Title = *node.Title
+	}
+	return err
+}
+
+func (f *Fetcher) fetchDocx(ctx context.Context, token string,
+	doc *Document) error {
+	getReq := larkdocx.NewGetDocumentReqBuilder().DocumentId(token).Build()
+	getResp, err := f.client.Docx.Document.Get(ctx, getReq)
+	if err != nil {
+		return err
+	}
+	if !getResp.Success() {
+		return codeError("get document", getResp.CodeError)
+	}
+	if getResp.Data.Document != nil && getResp.Data.Document.Title != nil {
+		doc.Title = *getResp.Data.Document.Title
+	}
+
+	req := larkdocx.NewRawContentDocumentReqBuilder().DocumentId(token).Build()
+	resp, err := f.client.Docx.Document.RawContent(ctx, req)
+	if err != nil {
+		return err
+	}
+	if !resp.Success() {
+		return codeError("get document content", resp.CodeError)
+	}
+	if resp.Data.Content != nil {
+		doc.Content = *resp.Data.Content
+	}
+	return nil
+}
+
+// fetchDoc reads documents of the previous generation, which the SDK has
+// no typed API for
+func (f *Fetcher) fetchDoc(ctx context.Context, token string,
+	doc *Document) error {
+	var body struct {
+		larkcore.CodeError
+		Data struct {
+			Content string `json:"content"`
+		} `json:"data"`
+	}
+	if err := f.get(ctx, fmt.Sprintf("/open-apis/doc/v2/%s/raw_content",
+		token), &body); err != nil {
+		return err
+	}
+	if body.Code != 0 {
+		return codeError("get doc content", body.CodeError)
+	}
+	doc.Content = body.Data.Content
+	return nil
+}
+
+func (f *Fetcher) fetchSheet(ctx context.Context, token string,
+	sheetId string, doc *Document) error {
+	getReq := larksheets.NewGetSpreadsheetReqBuilder().
+		SpreadsheetToken(token).Build()
+	getResp, err := f.client.Sheets.Spreadsheet.Get(ctx, getReq)
+	if err != nil {
+		return err
+	}
+	if !getResp.Success() {
+		return codeError("get spreadsheet", getResp.CodeError)
+	}
+	if getResp.Data.Spreadsheet != nil && getResp.Data.Spreadsheet.Title != nil {
+		doc.Title = *getResp.Data.Spreadsheet.Title
+	}
+
+	if sheetId == "" {
+		req := larksheets.NewQuerySpreadsheetSheetReqBuilder().
+			SpreadsheetToken(token).Build()
+		resp, err := f.client.Sheets.SpreadsheetSheet.Query(ctx, req)
+		if err != nil {
+			return err
+		}
+		if !resp.Success() {
+			return codeError("list sheets", resp.CodeError)
+		}
+		for _, sheet := range resp.Data.Sheets {
+			if sheet.SheetId != nil && (sheet.Hidden == nil || !*sheet.Hidden) {
+				sheetId = *sheet.SheetId
+				break
+			}
+		}
+		if sheetId == "" {
+			return fmt.Errorf("spreadsheet %s has no sheet", token)
+		}
+	}
+
+	// values are only available in the v2 API
+	var body struct {
+		larkcore.CodeError
+		Data struct {
+			ValueRange struct {
+				Values [][]interface{} `json:"values"`
+			} `json:"valueRange"`
+		} `json:"data"`
+	}
+	if err := f.get(ctx, fmt.Sprintf(
+		"/open-apis/sheets/v2/spreadsheets/%s/values/%s?valueRenderOption=ToString",
+		token, sheetId), &body); err != nil {
+		return err
+	}
+	if body.Code != 0 {
+		return codeError("get sheet values", body.CodeError)
+	}
+	doc.Content = sheetToMarkdown(body.Data.ValueRange.Values)
+	return nil
+}
+
+func (f *Fetcher) get(ctx context.Context, path string,
+	body interface{}) error {
+	resp, err := f.client.Get(ctx, path, nil, larkcore.AccessTokenTypeTenant)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(resp.RawBody, body); err != nil {
+		return fmt.Errorf("parse %s failed: %w", path, err)
+	}
+	return nil
+}
+
+func codeError(action string, err larkcore.CodeError) error {
+	return fmt.Errorf("%s failed: %d %s", action, err.Code, err.Msg)
+}
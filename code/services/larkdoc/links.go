@@ -0,0 +1,144 @@
+// Package larkdoc reads the Lark Docs, Wiki pages and Sheets linked in
+// messages through the open API, with the permissions of the app.
+package larkdoc
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+type Kind string
+
+const (
+	KindDocx  Kind = "docx"
+	KindDoc   Kind = "doc"
+	KindSheet Kind = "sheet"
+	KindWiki  Kind = "wiki"
+)
+
+// Link is a Lark document referenced in a message
+type Link struct {
+	Kind  Kind
+	Token string
+	// SheetId selects a sheet of a spreadsheet, empty for the first one
+	SheetId string
+	URL     string
+}
+
+var linkPattern = regexp.MustCompile(
+	`https?://[\w.-]+\.(?:feishu\.cn|larksuite\.com|larkoffice\.com|feishu\.net)` +
+		`/(docx|docs|wiki|sheets)/([A-Za-z0-9]+)(?:[?#][^\s)\]>"]*)?`)
+
+var pathKinds = map[string]Kind{
+	"docx":   KindDocx,
+	"docs":   KindDoc,
+	"wiki":   KindWiki,
+	"sheets": KindSheet,
+}
+
+// FindLinks returns the distinct document links in text, in order
+func FindLinks(text string) []Link {
+	var links []Link
+	seen := map[string]bool{}
+	for _, match := range linkPattern.FindAllStringSubmatch(text, -1) {
+		// punctuation right after a link ends the sentence
+		raw := strings.TrimRight(match[0], ".,;:!?'")
+		link := Link{
+			Kind:  pathKinds[match[1]],
+			Token: match[2],
+			URL:   raw,
+		}
+		if u, err := url.Parse(raw); err == nil {
+			link.SheetId = u.Query().Get("sheet")
+		}
+		key := fmt.Sprintf("%s/%s/%s", link.Kind, link.Token, link.SheetId)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		links = append(links, link)
+	}
+	return links
+}
+
+// sheetToMarkdown renders the values of a sheet as a Markdown table, the
+// first row is taken as the header
+func sheetToMarkdown(values [][]interface{}) string {
+	width := 0
+	for _, row := range values {
+		if len(row) > width {
+			width = len(row)
+		}
+	}
+	if width == 0 {
+		return ""
+	}
+
+	var text strings.Builder
+	writeRow := func(row []interface{}) {
+		text.WriteString("|")
+		for i := 0; i < width; i++ {
+			cell := ""
+			if i < len(row) {
+				cell = cellText(row[i])
+			}
+			text.WriteString(" " + cell + " |")
+		}
+		text.WriteString("\n")
+	}
+	writeRow(values[0])
+	text.WriteString("|" + strings.Repeat(" --- |", width) + "\n")
+	for _, row := range values[1:] {
+		if isEmptyRow(row) {
+			continue
+		}
+		writeRow(row)
+	}
+	return text.String()
+}
+
+// cellText flattens a cell value, rich text cells are lists of segments
+func cellText(value interface{}) string {
+	var text string
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		text = v
+	case float64:
+		text = fmt.Sprint(v)
+	case []interface{}:
+		var parts []string
+		for _, segment := range v {
+			if m, ok := segment.(map[string]interface{}); ok {
+				if t, ok := m["text"].(string); ok {
+					parts = append(parts, t)
+					continue
+				}
+				if t, ok := m["link"].(string); ok {
+					parts = append(parts, t)
+				}
+			}
+		}
+		text = strings.Join(parts, "")
+	case map[string]interface{}:
+		if t, ok := v["text"].(string); ok {
+			text = t
+		}
+	default:
+		text = fmt.Sprint(v)
+	}
+	text = strings.ReplaceAll(text, "\n", " ")
+	return strings.ReplaceAll(text, "|", "\\|")
+}
+
+func isEmptyRow(row []interface{}) bool {
+	for _, cell := range row {
+		if cellText(cell) != "" {
+			return false
+		}
+	}
+	return true
+}
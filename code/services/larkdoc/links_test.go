@@ -0,0 +1,41 @@
+package larkdoc
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFindLinks(t *testing.T) {
+	text := "Summarize https://acme.feishu.cn/docx/AbC123xyz and " +
+		"https://acme.larksuite.com/wiki/WiKi456?from=from_copylink, " +
+		"(https://acme.feishu.cn/sheets/ShT789?sheet=0b12cd) " +
+		"again https://acme.feishu.cn/docx/AbC123xyz " +
+		"but not https://example.com/docx/Nope1"
+	want := []Link{
+		{Kind: KindDocx, Token: "AbC123xyz", URL: "https://acme.feishu.cn/docx/AbC123xyz"},
+		{Kind: KindWiki, Token: "WiKi456", URL: "https://acme.larksuite.com/wiki/WiKi456?from=from_copylink"},
+		{Kind: KindSheet, Token: "ShT789", SheetId: "0b12cd", URL: "https://acme.feishu.cn/sheets/ShT789?sheet=0b12cd"},
+	}
+	if got := FindLinks(text); !reflect.DeepEqual(got, want) {
+		t.Errorf("FindLinks() got = %+v, want %+v", got, want)
+	}
+}
+
+func TestSheetToMarkdown(t *testing.T) {
+	values := [][]interface{}{
+		{"Keyword", "Volume", "Notes"},
+		{"seo audit", float64(1200)},
+		{nil, nil, nil},
+		{"link | building", float64(880), []interface{}{
+			map[string]interface{}{"type": "text", "text": "see "},
+			map[string]interface{}{"type": "url", "text": "guide"},
+		}},
+	}
+	want := "| Keyword | Volume | Notes |\n" +
+		"| --- | --- | --- |\n" +
+		"| seo audit | 1200 |  |\n" +
+		"| link \\| building | 880 | see guide |\n"
+	if got := sheetToMarkdown(values); got != want {
+		t.Errorf("sheetToMarkdown() got = %q, want %q", got, want)
+	}
+}
@@ -104,3 +104,16 @@ func (lb *LoadBalancer) GetAPIs() []*API {
 	copy(apis, lb.apis)
 	return apis
 }
+
+// Availability counts the APIs currently marked available and all APIs
+func (lb *LoadBalancer) Availability() (available int, total int) {
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+
+	for _, api := range lb.apis {
+		if api.Available {
+			available++
+		}
+	}
+	return available, len(lb.apis)
+}
@@ -0,0 +1,184 @@
+// Package moderation checks what users send, and optionally what models
+// answer, against the provider's moderation endpoint and a local policy of
+// keywords and regular expressions.
+package moderation
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Actions taken on flagged content
+const (
+	ActionBlock = "block" // refuse it and show a notice
+	ActionWarn  = "warn"  // go on after showing a notice
+	ActionLog   = "log"   // go on, only logging it
+)
+
+// Providers checking content
+const (
+	ProviderOpenAI = "openai" // the moderation endpoint
+	ProviderLocal  = "local"  // the keywords and patterns
+	ProviderBoth   = "both"   // the local policy, then the endpoint
+)
+
+// Moderator classifies text, *openai.ChatGPT implements it
+type Moderator interface {
+	Moderate(model string, input string) ([]string, error)
+}
+
+// Options configure the filter
+type Options struct {
+	Provider     string
+	Model        string // moderation model, empty for the provider default
+	Action       string
+	Keywords     []string // matched case-insensitively
+	PatternsFile string   // one regular expression per line, # comments
+	Output       bool     // check model answers too
+}
+
+// Verdict is the outcome of a check
+type Verdict struct {
+	Flagged    bool
+	Source     string   // ProviderLocal or ProviderOpenAI
+	Categories []string // why it was flagged
+}
+
+type pattern struct {
+	source string
+	re     *regexp.Regexp
+}
+
+// Filter applies the moderation options
+type Filter struct {
+	provider string
+	model    string
+	action   string
+	keywords []string
+	patterns []pattern
+	output   bool
+}
+
+// New validates opts and loads the patterns file
+func New(opts Options) (*Filter, error) {
+	f := &Filter{
+		provider: strings.ToLower(opts.Provider),
+		model:    opts.Model,
+		action:   strings.ToLower(opts.Action),
+		output:   opts.Output,
+	}
+	switch f.provider {
+	case "":
+		f.provider = ProviderOpenAI
+	case ProviderOpenAI, ProviderLocal, ProviderBoth:
+	default:
+		return nil, fmt.Errorf("invalid moderation provider %q", opts.Provider)
+	}
+	switch f.action {
+	case "":
+		f.action = ActionBlock
+	case ActionBlock, ActionWarn, ActionLog:
+	default:
+		return nil, fmt.Errorf("invalid moderation action %q", opts.Action)
+	}
+	for _, keyword := range opts.Keywords {
+		if keyword = strings.TrimSpace(keyword); keyword != "" {
+			f.keywords = append(f.keywords, strings.ToLower(keyword))
+		}
+	}
+	if opts.PatternsFile != "" {
+		patterns, err := loadPatterns(opts.PatternsFile)
+		if err != nil {
+			return nil, err
+		}
+		f.patterns = patterns
+	}
+	return f, nil
+}
+
+func loadPatterns(path string) ([]pattern, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open moderation patterns failed: %w", err)
+	}
+	defer file.Close()
+	var patterns []pattern
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		re, err := regexp.Compile(line)
+		if err != nil {
+			return nil, fmt.Errorf("moderation pattern on line %d: %w", n, err)
+		}
+		patterns = append(patterns, pattern{source: line, re: re})
+	}
+	return patterns, scanner.Err()
+}
+
+// Action is what to do with flagged content
+func (f *Filter) Action() string {
+	return f.action
+}
+
+// Output tells whether model answers are checked too
+func (f *Filter) Output() bool {
+	return f.output
+}
+
+// Check moderates text, the local policy first since it is free. An
+// error means the endpoint could not be reached, the caller decides
+// whether to go on
+func (f *Filter) Check(moderator Moderator, text string) (Verdict, error) {
+	if strings.TrimSpace(text) == "" {
+		return Verdict{}, nil
+	}
+	if f.provider == ProviderLocal || f.provider == ProviderBoth {
+		if categories := f.checkLocal(text); len(categories) > 0 {
+			return Verdict{Flagged: true, Source: ProviderLocal,
+				Categories: categories}, nil
+		}
+	}
+	if f.provider == ProviderOpenAI || f.provider == ProviderBoth {
+		categories, err := moderator.Moderate(f.model, text)
+		if err != nil {
+			return Verdict{}, fmt.Errorf("moderation request failed: %w", err)
+		}
+		if len(categories) > 0 {
+			return Verdict{Flagged: true, Source: ProviderOpenAI,
+				Categories: categories}, nil
+		}
+	}
+	return Verdict{}, nil
+}
+
+// checkLocal names the keywords and patterns text matches. Keywords are
+// not echoed back since they are often the words being filtered
+func (f *Filter) checkLocal(text string) []string {
+	matched := map[string]bool{}
+	lower := strings.ToLower(text)
+	for _, keyword := range f.keywords {
+		if strings.Contains(lower, keyword) {
+			matched["keyword"] = true
+			break
+		}
+	}
+	for _, p := range f.patterns {
+		if p.re.MatchString(text) {
+			matched["pattern"] = true
+			break
+		}
+	}
+	categories := make([]string, 0, len(matched))
+	for category := range matched {
+		categories = append(categories, category)
+	}
+	sort.Strings(categories)
+	return categories
+}
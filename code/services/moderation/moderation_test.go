@@ -0,0 +1,83 @@
+package moderation
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+type fakeModerator struct {
+	categories []string
+	err        error
+	calls      int
+}
+
+func (f *fakeModerator) Moderate(model string, input string) ([]string, error) {
+	f.calls++
+	return f.categories, f.err
+}
+
+func TestLocalPolicyChecksKeywordsAndPatterns(t *testing.T) {
+	patterns := filepath.Join(t.TempDir(), "patterns.txt")
+	os.WriteFile(patterns, []byte("# card numbers\n\\b\\d{4}-\\d{4}-\\d{4}-\\d{4}\\b\n"), 0o600)
+	f, err := New(Options{Provider: ProviderLocal, Keywords: []string{"Forbidden "},
+		PatternsFile: patterns})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"a perfectly fine question", nil},
+		{"this is FORBIDDEN stuff", []string{"keyword"}},
+		{"pay with 1234-5678-9012-3456", []string{"pattern"}},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		verdict, err := f.Check(nil, tt.text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if verdict.Flagged != (tt.want != nil) ||
+			!reflect.DeepEqual(verdict.Categories, tt.want) {
+			t.Errorf("Check(%q) = %+v, want categories %v", tt.text, verdict,
+				tt.want)
+		}
+	}
+}
+
+func TestBothStopsAtTheLocalPolicy(t *testing.T) {
+	moderator := &fakeModerator{categories: []string{"violence"}}
+	f, _ := New(Options{Provider: ProviderBoth, Keywords: []string{"secret"}})
+
+	verdict, _ := f.Check(moderator, "the secret plan")
+	if verdict.Source != ProviderLocal || moderator.calls != 0 {
+		t.Errorf("verdict %+v after %d endpoint calls", verdict, moderator.calls)
+	}
+	verdict, _ = f.Check(moderator, "something else")
+	if verdict.Source != ProviderOpenAI ||
+		!reflect.DeepEqual(verdict.Categories, []string{"violence"}) {
+		t.Errorf("unexpected verdict %+v", verdict)
+	}
+
+	moderator.err = errors.New("timeout")
+	if _, err := f.Check(moderator, "something else"); err == nil {
+		t.Error("expected the endpoint error")
+	}
+}
+
+func TestNewRejectsUnknownSettings(t *testing.T) {
+	if _, err := New(Options{Action: "delete"}); err == nil {
+		t.Error("expected an invalid action error")
+	}
+	if _, err := New(Options{Provider: "azure"}); err == nil {
+		t.Error("expected an invalid provider error")
+	}
+	if f, err := New(Options{}); err != nil || f.Action() != ActionBlock {
+		t.Errorf("unexpected defaults %+v, %v", f, err)
+	}
+}
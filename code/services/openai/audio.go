@@ -1,46 +1,70 @@
 package openai
 
 import (
-	"bytes"
 	"fmt"
 	"io"
 	"mime/multipart"
 	"os"
+	"path/filepath"
 )
 
 type AudioToTextRequestBody struct {
-	File           string `json:"file"`
-	Model          string `json:"model"`
-	ResponseFormat string `json:"response_format"`
+	File string `json:"file"`
+	// Audio is sent instead of reading File when set, File names it
+	Audio          io.Reader `json:"-"`
+	Model          string    `json:"model"`
+	ResponseFormat string    `json:"response_format"`
+	Language       string    `json:"language,omitempty"`
+	Prompt         string    `json:"prompt,omitempty"`
 }
 
 type AudioToTextResponseBody struct {
-	Text string `json:"text"`
+	Text     string              `json:"text"`
+	Language string              `json:"language,omitempty"`
+	Duration float64             `json:"duration,omitempty"`
+	Segments []TranscriptSegment `json:"segments,omitempty"`
+}
+
+// TranscriptSegment is a timed part of a transcription, in seconds
+type TranscriptSegment struct {
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+	Text  string  `json:"text"`
 }
 
 func audioMultipartForm(request AudioToTextRequestBody, w *multipart.Writer) error {
-	f, err := os.Open(request.File)
-	if err != nil {
-		return fmt.Errorf("opening audio file: %w", err)
+	audio := request.Audio
+	if audio == nil {
+		f, err := os.Open(request.File)
+		if err != nil {
+			return fmt.Errorf("opening audio file: %w", err)
+		}
+		defer f.Close()
+		audio = f
 	}
 
-	fw, err := w.CreateFormFile("file", f.Name())
+	fw, err := w.CreateFormFile("file", filepath.Base(request.File))
 	if err != nil {
 		return fmt.Errorf("creating form file: %w", err)
 	}
 
-	if _, err = io.Copy(fw, f); err != nil {
+	if _, err = io.Copy(fw, audio); err != nil {
 		return fmt.Errorf("reading from opened audio file: %w", err)
 	}
 
-	fw, err = w.CreateFormField("model")
-	if err != nil {
-		return fmt.Errorf("creating form field: %w", err)
+	fields := []struct{ name, value string }{
+		{"model", request.Model},
+		{"response_format", request.ResponseFormat},
+		{"language", request.Language},
+		{"prompt", request.Prompt},
 	}
-
-	modelName := bytes.NewReader([]byte(request.Model))
-	if _, err = io.Copy(fw, modelName); err != nil {
-		return fmt.Errorf("writing model name: %w", err)
+	for _, field := range fields {
+		if field.value == "" {
+			continue
+		}
+		if err := w.WriteField(field.name, field.value); err != nil {
+			return fmt.Errorf("writing %s: %w", field.name, err)
+		}
 	}
 	w.Close()
 
@@ -51,7 +75,7 @@ func (gpt *ChatGPT) AudioToText(audio string) (string, error) {
 	requestBody := AudioToTextRequestBody{
 		File:           audio,
 		Model:          "whisper-1",
-		ResponseFormat: "text",
+		ResponseFormat: "json",
 	}
 	audioToTextResponseBody := &AudioToTextResponseBody{}
 	err := gpt.sendRequestWithBodyType(gpt.ApiUrl+"/v1/audio/transcriptions",
@@ -64,3 +88,25 @@ func (gpt *ChatGPT) AudioToText(audio string) (string, error) {
 
 	return audioToTextResponseBody.Text, nil
 }
+
+// Transcribe transcribes the audio named name with timed segments.
+// language is an ISO-639-1 hint and prompt the text spoken just before,
+// both may be empty
+func (gpt *ChatGPT) Transcribe(name string, audio io.Reader, language string,
+	prompt string) (*AudioToTextResponseBody, error) {
+	requestBody := AudioToTextRequestBody{
+		File:           name,
+		Audio:          audio,
+		Model:          "whisper-1",
+		ResponseFormat: "verbose_json",
+		Language:       language,
+		Prompt:         prompt,
+	}
+	audioToTextResponseBody := &AudioToTextResponseBody{}
+	err := gpt.sendRequestWithBodyType(gpt.ApiUrl+"/v1/audio/transcriptions",
+		"POST", formVoiceDataBody, requestBody, audioToTextResponseBody)
+	if err != nil {
+		return nil, err
+	}
+	return audioToTextResponseBody, nil
+}
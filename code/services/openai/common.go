@@ -2,6 +2,7 @@ package openai
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -11,9 +12,15 @@ import (
 	"net/url"
 	"start-feishubot/initialization"
 	"start-feishubot/logger"
+	"start-feishubot/services/audit"
 	"start-feishubot/services/loadbalancer"
+	"start-feishubot/tracing"
+	"strconv"
 	"strings"
 	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type PlatForm string
@@ -46,6 +53,10 @@ type ChatGPT struct {
 	MaxTokens   int
 	Platform    PlatForm
 	AzureConfig AzureConfig
+	// Multimodal is set when Model accepts images in chat completions
+	Multimodal bool
+	// ctx is carried by the requests, see WithContext
+	ctx context.Context
 }
 type requestBodyType int
 
@@ -53,16 +64,18 @@ const (
 	jsonBody requestBodyType = iota
 	formVoiceDataBody
 	formPictureDataBody
+	formPictureEditBody
 
 	nilBody
 )
 
-func (gpt *ChatGPT) doAPIRequestWithRetry(url, method string,
+func (gpt *ChatGPT) doAPIRequestWithRetry(ctx context.Context,
+	url, method string,
 	bodyType requestBodyType,
-	requestBody interface{}, responseBody interface{}, client *http.Client, maxRetries int) error {
+	requestBody interface{}, responseBody interface{}, client *http.Client,
+	maxRetries int) (err error) {
 	var api *loadbalancer.API
 	var requestBodyData []byte
-	var err error
 	var writer *multipart.Writer
 	api = gpt.Lb.GetAPI()
 
@@ -96,6 +109,18 @@ func (gpt *ChatGPT) doAPIRequestWithRetry(url, method string,
 			return err
 		}
 		requestBodyData = formBody.Bytes()
+	case formPictureEditBody:
+		formBody := &bytes.Buffer{}
+		writer = multipart.NewWriter(formBody)
+		err = pictureEditMultipartForm(requestBody.(ImageEditRequestBody), writer)
+		if err != nil {
+			return err
+		}
+		err = writer.Close()
+		if err != nil {
+			return err
+		}
+		requestBodyData = formBody.Bytes()
 	case nilBody:
 		requestBodyData = nil
 
@@ -106,15 +131,18 @@ func (gpt *ChatGPT) doAPIRequestWithRetry(url, method string,
 	if api == nil {
 		return errors.New("no available API")
 	}
+	model := requestModel(bodyType, requestBody, requestBodyData)
 
 	//fmt.Println("requestBodyData", string(requestBodyData))
-	req, err := http.NewRequest(method, url, bytes.NewReader(requestBodyData))
+	req, err := http.NewRequestWithContext(ctx, method, url,
+		bytes.NewReader(requestBodyData))
 	if err != nil {
 		return err
 	}
 
 	req.Header.Set("Content-Type", "application/json")
-	if bodyType == formVoiceDataBody || bodyType == formPictureDataBody {
+	if bodyType == formVoiceDataBody || bodyType == formPictureDataBody ||
+		bodyType == formPictureEditBody {
 		req.Header.Set("Content-Type", writer.FormDataContentType())
 	}
 	if gpt.Platform == OpenAI {
@@ -123,6 +151,11 @@ func (gpt *ChatGPT) doAPIRequestWithRetry(url, method string,
 		req.Header.Set("api-key", gpt.AzureConfig.ApiToken)
 	}
 
+	endpoint := endpointOf(url)
+	var body []byte
+	defer func() {
+		audit.RecordProviderCall(ctx, endpoint, model, requestBodyData, body, err)
+	}()
 	var response *http.Response
 	var retry int
 	for retry = 0; retry <= maxRetries; retry++ {
@@ -130,18 +163,34 @@ func (gpt *ChatGPT) doAPIRequestWithRetry(url, method string,
 		if retry > 0 {
 			req.Body = ioutil.NopCloser(bytes.NewReader(requestBodyData))
 		}
-		response, err = client.Do(req)
+		attemptCtx, span := tracing.Start(ctx, "openai "+endpoint,
+			trace.WithAttributes(
+				attribute.String("openai.endpoint", endpoint),
+				attribute.String("openai.model", model),
+				attribute.Int("openai.retry", retry)))
+		start := time.Now()
+		response, err = client.Do(req.WithContext(attemptCtx))
 		//fmt.Println("--------------------")
 		//fmt.Println("req", req.Header)
 		//fmt.Printf("response: %v", response)
 		logger.Debug("req", req.Header)
 
 		logger.Debugf("response %v", response)
+		status := "error"
+		if err == nil {
+			status = strconv.Itoa(response.StatusCode)
+		}
+		observeRequest(endpoint, model, status, api.Key, start)
+		endAttemptSpan(span, response, err)
 		// read body
 		if err != nil || response.StatusCode < 200 || response.StatusCode >= 300 {
 
-			body, _ := ioutil.ReadAll(response.Body)
-			fmt.Println("body", string(body))
+			if response != nil {
+				body, _ := ioutil.ReadAll(response.Body)
+				response.Body.Close()
+				logger.Ctx(ctx).Debugf("%s failed with status %d: %v",
+					endpoint, response.StatusCode, logger.UserContent(string(body)))
+			}
 
 			gpt.Lb.SetAvailability(api.Key, false)
 			if retry == maxRetries {
@@ -160,13 +209,15 @@ func (gpt *ChatGPT) doAPIRequestWithRetry(url, method string,
 		return fmt.Errorf("%s api failed after %d retries", strings.ToUpper(method), retry)
 	}
 
-	body, err := ioutil.ReadAll(response.Body)
+	body, err = ioutil.ReadAll(response.Body)
 	if err != nil {
 		return err
 	}
 
-	err = json.Unmarshal(body, responseBody)
-	if err != nil {
+	// binary responses such as generated speech are returned as is
+	if raw, ok := responseBody.(*[]byte); ok {
+		*raw = body
+	} else if err = json.Unmarshal(body, responseBody); err != nil {
 		return err
 	}
 
@@ -185,7 +236,7 @@ func (gpt *ChatGPT) sendRequestWithBodyType(link, method string,
 		return parseProxyError
 	}
 
-	err = gpt.doAPIRequestWithRetry(link, method, bodyType,
+	err = gpt.doAPIRequestWithRetry(gpt.context(), link, method, bodyType,
 		requestBody, responseBody, client, MaxRetries)
 
 	return err
@@ -205,6 +256,13 @@ func NewChatGPT(config initialization.Config) *ChatGPT {
 		platform = Azure
 	}
 
+	multimodal := IsMultimodalModel(config.OpenaiModel)
+	if override, err := strconv.ParseBool(config.OpenaiMultimodal); err == nil {
+		multimodal = override
+	}
+
+	registerKeyGauges(lb)
+
 	return &ChatGPT{
 		Lb:        lb,
 		ApiKey:    config.OpenaiApiKeys,
@@ -220,6 +278,7 @@ func NewChatGPT(config initialization.Config) *ChatGPT {
 			ApiVersion:     config.AzureApiVersion,
 			ApiToken:       config.AzureOpenaiToken,
 		},
+		Multimodal: multimodal,
 	}
 }
 
@@ -240,7 +299,10 @@ func GetProxyClient(proxyString string) (*http.Client, error) {
 	var client *http.Client
 	timeOutDuration := time.Duration(initialization.GetConfig().OpenAIHttpClientTimeOut) * time.Second
 	if proxyString == "" {
-		client = &http.Client{Timeout: timeOutDuration}
+		client = &http.Client{
+			Transport: tracing.Transport(http.DefaultTransport),
+			Timeout:   timeOutDuration,
+		}
 	} else {
 		proxyUrl, err := url.Parse(proxyString)
 		if err != nil {
@@ -250,13 +312,28 @@ func GetProxyClient(proxyString string) (*http.Client, error) {
 			Proxy: http.ProxyURL(proxyUrl),
 		}
 		client = &http.Client{
-			Transport: transport,
+			Transport: tracing.Transport(transport),
 			Timeout:   timeOutDuration,
 		}
 	}
 	return client, nil
 }
 
+// WithContext returns a copy of the client whose requests carry ctx, they
+// are traced under its span and canceled with it
+func (gpt *ChatGPT) WithContext(ctx context.Context) *ChatGPT {
+	c := *gpt
+	c.ctx = ctx
+	return &c
+}
+
+func (gpt *ChatGPT) context() context.Context {
+	if gpt.ctx == nil {
+		return context.Background()
+	}
+	return gpt.ctx
+}
+
 func (gpt *ChatGPT) ChangeMode(model string) *ChatGPT {
 	gpt.Model = model
 	return gpt
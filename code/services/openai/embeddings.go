@@ -0,0 +1,47 @@
+package openai
+
+import (
+	"fmt"
+	"net/http"
+)
+
+type EmbeddingRequestBody struct {
+	Model string   `json:"model"`
+	Input []string `json:"input"`
+}
+
+type EmbeddingResponseBody struct {
+	Data []struct {
+		Embedding []float32 `json:"embedding"`
+		Index     int       `json:"index"`
+	} `json:"data"`
+}
+
+// Embeddings returns the embedding of every input, in the order given
+func (gpt *ChatGPT) Embeddings(model string, input []string) ([][]float32,
+	error) {
+	requestBody := EmbeddingRequestBody{
+		Model: model,
+		Input: input,
+	}
+	embeddingResponseBody := &EmbeddingResponseBody{}
+	err := gpt.sendRequestWithBodyType(gpt.FullUrl("embeddings"),
+		http.MethodPost, jsonBody, requestBody, embeddingResponseBody)
+	if err != nil {
+		return nil, err
+	}
+
+	embeddings := make([][]float32, len(input))
+	for _, data := range embeddingResponseBody.Data {
+		if data.Index < 0 || data.Index >= len(input) {
+			return nil, fmt.Errorf("embedding index %d out of range", data.Index)
+		}
+		embeddings[data.Index] = data.Embedding
+	}
+	for i, embedding := range embeddings {
+		if embedding == nil {
+			return nil, fmt.Errorf("missing embedding for input %d", i)
+		}
+	}
+	return embeddings, nil
+}
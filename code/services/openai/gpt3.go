@@ -34,6 +34,10 @@ var AIModeStrs = []string{
 type Messages struct {
 	Role    string `json:"role"`
 	Content string `json:"content"`
+	// Images are sent alongside Content as image_url parts, see MarshalJSON
+	Images []ImageURL `json:"-"`
+	// MsgId is the Lark message a user turn was asked in
+	MsgId string `json:"-"`
 }
 
 // ChatGPTResponseBody request body
@@ -65,7 +69,11 @@ type ChatGPTRequestBody struct {
 
 func (msg *Messages) CalculateTokenLength() int {
 	text := strings.TrimSpace(msg.Content)
-	return tokenizer.MustCalToken(text)
+	total := tokenizer.MustCalToken(text)
+	for _, image := range msg.Images {
+		total += image.CalculateTokenLength()
+	}
+	return total
 }
 
 func (gpt *ChatGPT) Completions(msg []Messages, aiMode AIMode) (resp Messages,
@@ -83,7 +91,7 @@ func (gpt *ChatGPT) Completions(msg []Messages, aiMode AIMode) (resp Messages,
 	url := gpt.FullUrl("chat/completions")
 	//fmt.Println(url)
 	logger.Debug(url)
-	logger.Debug("request body ", requestBody)
+	logger.Debug("request body", logger.UserContent(requestBody))
 	if url == "" {
 		return resp, errors.New("unable to get openai request URL")
 	}
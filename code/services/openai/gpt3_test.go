@@ -1,8 +1,15 @@
 package openai
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -77,21 +84,22 @@ func TestAudioToText(t *testing.T) {
 func TestVariateOneImage(t *testing.T) {
 	config := initialization.LoadConfig("../../config.yaml")
 	gpt := NewChatGPT(*config)
-	image := "./test_file/img.png"
+	f, err := os.Open("./test_file/img.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
 	size := "256x256"
-	//compressionType, err := GetImageCompressionType(image)
-	//if err != nil {
-	//	return
-	//}
-	//fmt.Println("compressionType: ", compressionType)
-	ConvertToRGBA(image, image)
-	err := VerifyPngs([]string{image})
+	image, err := ConvertToRGBA(f)
+	if err == nil {
+		err = VerifyPngs([][]byte{image})
+	}
 	if err != nil {
 		t.Errorf("TestVariateOneImage failed with error: %v", err)
 		return
 	}
 
-	imageBs64, err := gpt.GenerateOneImageVariation(image, size)
+	imageBs64, err := gpt.GenerateOneImageVariation(bytes.NewReader(image), size)
 	if err != nil {
 		t.Errorf("TestVariateOneImage failed with error: %v", err)
 	}
@@ -104,22 +112,26 @@ func TestVariateOneImage(t *testing.T) {
 func TestVariateOneImageWithJpg(t *testing.T) {
 	config := initialization.LoadConfig("../../config.yaml")
 	gpt := NewChatGPT(*config)
-	image := "./test_file/test.jpg"
+	data, err := os.ReadFile("./test_file/test.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
 	size := "256x256"
-	compressionType, err := GetImageCompressionType(image)
+	compressionType, err := GetImageCompressionType(bytes.NewReader(data))
 	if err != nil {
 		return
 	}
 	fmt.Println("compressionType: ", compressionType)
-	//ConvertJPGtoPNG(image)
-	ConvertToRGBA(image, image)
-	err = VerifyPngs([]string{image})
+	image, err := ConvertToRGBA(bytes.NewReader(data))
+	if err == nil {
+		err = VerifyPngs([][]byte{image})
+	}
 	if err != nil {
 		t.Errorf("TestVariateOneImage failed with error: %v", err)
 		return
 	}
 
-	imageBs64, err := gpt.GenerateOneImageVariation(image, size)
+	imageBs64, err := gpt.GenerateOneImageVariation(bytes.NewReader(image), size)
 	if err != nil {
 		t.Errorf("TestVariateOneImage failed with error: %v", err)
 	}
@@ -191,3 +203,103 @@ func TestChatGPT_streamChat(t *testing.T) {
 		}
 	}
 }
+
+func TestMessagesMarshalJSON(t *testing.T) {
+	text, _ := json.Marshal(Messages{Role: "user", Content: "hi"})
+	if string(text) != `{"role":"user","content":"hi"}` {
+		t.Errorf("unexpected text message json: %s", text)
+	}
+
+	withImage, _ := json.Marshal(Messages{Role: "user", Content: "hi",
+		Images: []ImageURL{{URL: "data:image/jpeg;base64,AAAA", Detail: "low"}}})
+	want := `{"role":"user","content":[{"type":"text","text":"hi"},` +
+		`{"type":"image_url","image_url":{"url":"data:image/jpeg;base64,AAAA","detail":"low"}}]}`
+	if string(withImage) != want {
+		t.Errorf("unexpected image message json: %s", withImage)
+	}
+}
+
+func TestIsMultimodalModel(t *testing.T) {
+	for model, want := range map[string]bool{
+		"gpt-4o":        true,
+		"gpt-4o-mini":   true,
+		"gpt-4.1":       true,
+		"gpt-3.5-turbo": false,
+		"gpt-4":         false,
+		"o3-mini":       false,
+	} {
+		if got := IsMultimodalModel(model); got != want {
+			t.Errorf("IsMultimodalModel(%q) = %v, want %v", model, got, want)
+		}
+	}
+}
+
+func TestStreamVisionInfo(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if !strings.Contains(string(body), `"stream":true`) {
+			t.Errorf("stream flag missing from request: %s", body)
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"content\":\"a red \"}}]}\n\n")
+		fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"content\":\"apple\"}}]}\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer server.Close()
+
+	gpt := NewChatGPT(initialization.Config{
+		OpenaiApiKeys: []string{"sk-test"},
+		OpenaiApiUrl:  server.URL,
+		OpenaiModel:   "gpt-4o",
+	})
+	responseStream := make(chan string)
+	go func() {
+		defer close(responseStream)
+		if err := gpt.StreamVisionInfo(context.Background(),
+			createTestVisionMessages(), responseStream); err != nil {
+			t.Errorf("StreamVisionInfo failed with error: %v", err)
+		}
+	}()
+	answer := ""
+	for res := range responseStream {
+		answer += res
+	}
+	if answer != "a red apple" {
+		t.Errorf("unexpected streamed answer: %q", answer)
+	}
+}
+
+func TestStreamChatWithImagesUsesMaxTokens(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body streamRequestBody
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error(err)
+		}
+		if body.MaxTokens != 4096 {
+			t.Errorf("max_tokens = %d, want the configured 4096", body.MaxTokens)
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer server.Close()
+
+	gpt := NewChatGPT(initialization.Config{
+		OpenaiApiKeys:   []string{"sk-test"},
+		OpenaiApiUrl:    server.URL,
+		OpenaiModel:     "gpt-4o",
+		OpenaiMaxTokens: 4096,
+	})
+	msg := []Messages{{Role: "user", Content: "What’s in this image?",
+		Images: []ImageURL{{URL: "data:image/jpeg;base64,AAAA"}}}}
+	if err := gpt.StreamChat(context.Background(), msg, Balance,
+		make(chan string)); err != nil {
+		t.Errorf("StreamChat failed with error: %v", err)
+	}
+}
+
+func createTestVisionMessages() []VisionMessages {
+	return []VisionMessages{{Role: "user", Content: []ContentType{
+		{Type: "text", Text: "What’s in this image?"},
+		{Type: "image_url", ImageURL: &ImageURL{URL: "data:image/jpeg;base64,AAAA"}},
+	}}}
+}
@@ -0,0 +1,99 @@
+package openai
+
+import "strings"
+
+const (
+	ImageModelDallE2   = "dall-e-2"
+	ImageModelDallE3   = "dall-e-3"
+	ImageModelGPTImage = "gpt-image-1"
+)
+
+// ImageOptions are the settings of an image generation
+type ImageOptions struct {
+	Model   string
+	Size    string
+	Style   string
+	Quality string
+	N       int
+}
+
+// imageModel describes the parameters an image model accepts, the first
+// size and quality are its defaults
+type imageModel struct {
+	sizes      []string
+	qualities  []string
+	styles     bool
+	maxN       int // images per request
+	base64Only bool
+}
+
+var imageModels = map[string]imageModel{
+	ImageModelDallE2: {
+		sizes:     []string{"1024x1024", "256x256", "512x512"},
+		qualities: []string{"standard"},
+		maxN:      10,
+	},
+	ImageModelDallE3: {
+		sizes:     []string{"1024x1024", "1024x1792", "1792x1024"},
+		qualities: []string{"standard", "hd"},
+		styles:    true,
+		maxN:      1,
+	},
+	ImageModelGPTImage: {
+		sizes:      []string{"1024x1024", "1024x1536", "1536x1024"},
+		qualities:  []string{"medium", "low", "high"},
+		maxN:       10,
+		base64Only: true,
+	},
+}
+
+// MaxImageCount is the most images generated for one prompt
+const MaxImageCount = 4
+
+// imageModelOf returns the parameters of model, gpt-image models share
+// those of gpt-image-1 and unknown models are sent one image at a time
+func imageModelOf(model string) imageModel {
+	if m, ok := imageModels[model]; ok {
+		return m
+	}
+	if strings.HasPrefix(model, "gpt-image") {
+		return imageModels[ImageModelGPTImage]
+	}
+	return imageModel{maxN: 1}
+}
+
+// Normalize returns options the model accepts, sizes and qualities it
+// does not know fall back to its defaults and settings it has no use for
+// are dropped
+func (o ImageOptions) Normalize() ImageOptions {
+	if o.Model == "" {
+		o.Model = ImageModelDallE3
+	}
+	m := imageModelOf(o.Model)
+	o.Size = oneOf(o.Size, m.sizes)
+	o.Quality = oneOf(o.Quality, m.qualities)
+	if !m.styles {
+		o.Style = ""
+	}
+	if o.N < 1 {
+		o.N = 1
+	}
+	if o.N > MaxImageCount {
+		o.N = MaxImageCount
+	}
+	return o
+}
+
+// oneOf returns value when it is allowed, otherwise the first allowed
+// value. Any value is kept when nothing is known about the parameter
+func oneOf(value string, allowed []string) string {
+	if len(allowed) == 0 {
+		return value
+	}
+	for _, a := range allowed {
+		if a == value {
+			return value
+		}
+	}
+	return allowed[0]
+}
@@ -0,0 +1,69 @@
+package openai
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"start-feishubot/services/loadbalancer"
+)
+
+func TestImageOptionsNormalize(t *testing.T) {
+	tests := []struct {
+		in, want ImageOptions
+	}{
+		{ImageOptions{Model: ImageModelDallE2, Size: "1792x1024", Style: "vivid", Quality: "hd", N: 9},
+			ImageOptions{Model: ImageModelDallE2, Size: "1024x1024", Quality: "standard", N: MaxImageCount}},
+		{ImageOptions{Size: "1024x1792", Style: "natural", Quality: "hd"},
+			ImageOptions{Model: ImageModelDallE3, Size: "1024x1792", Style: "natural", Quality: "hd", N: 1}},
+		{ImageOptions{Model: "gpt-image-1-mini", Size: "1536x1024", Quality: "hd", N: 2},
+			ImageOptions{Model: "gpt-image-1-mini", Size: "1536x1024", Quality: "medium", N: 2}},
+	}
+	for _, tt := range tests {
+		if got := tt.in.Normalize(); got != tt.want {
+			t.Errorf("Normalize(%+v) = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestGenerateImagesParallel(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,
+		r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		var body ImageGenerationRequestBody
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error(err)
+		}
+		if body.N != 1 || body.Model != ImageModelDallE3 ||
+			body.ResponseFormat != "b64_json" {
+			t.Errorf("unexpected request %+v", body)
+		}
+		w.Write([]byte(`{"data":[{"b64_json":"AAAA",` +
+			`"revised_prompt":"a tabby cat"}]}`))
+	}))
+	defer server.Close()
+
+	gpt := &ChatGPT{
+		Lb:       loadbalancer.NewLoadBalancer([]string{"sk-test"}),
+		ApiUrl:   server.URL,
+		Platform: OpenAI,
+	}
+	images, err := gpt.GenerateImages("a cat", ImageOptions{
+		Model: ImageModelDallE3, N: 3,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(images) != 3 || requests != 3 {
+		t.Errorf("got %d images from %d requests, want 3 from 3",
+			len(images), requests)
+	}
+	for _, image := range images {
+		if image.Base64 != "AAAA" || image.RevisedPrompt != "a tabby cat" {
+			t.Errorf("unexpected image %+v", image)
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package openai
+
+import (
+	"errors"
+	"strings"
+)
+
+const imagePromptInstruction = "You write prompts for image generation " +
+	"models. Rewrite the user's request as one detailed English prompt: " +
+	"translate it if needed, keep every subject and constraint the user " +
+	"gave, and add composition, lighting and style details that fit. " +
+	"Reply with the prompt only."
+
+// EnhanceImagePrompt expands and translates a short image request into a
+// detailed English prompt with the chat model
+func (gpt *ChatGPT) EnhanceImagePrompt(prompt string) (string, error) {
+	resp, err := gpt.Completions([]Messages{
+		{Role: "system", Content: imagePromptInstruction},
+		{Role: "user", Content: prompt},
+	}, Fresh)
+	if err != nil {
+		return "", err
+	}
+	enhanced := strings.Trim(strings.TrimSpace(resp.Content), "\"")
+	if enhanced == "" {
+		return "", errors.New("empty enhanced prompt")
+	}
+	return enhanced, nil
+}
@@ -0,0 +1,133 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"start-feishubot/metrics"
+	"start-feishubot/services/loadbalancer"
+	"start-feishubot/tracing"
+
+	go_openai "github.com/sashabaranov/go-openai"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// observeRequest records one attempt of an API request, status is the HTTP
+// status code or "error" when no response was received
+func observeRequest(endpoint, model, status, key string, start time.Time) {
+	metrics.OpenAIRequests.WithLabelValues(endpoint, model, status,
+		keyLabel(key)).Inc()
+	metrics.OpenAIDuration.WithLabelValues(endpoint, model).
+		Observe(time.Since(start).Seconds())
+}
+
+// endAttemptSpan ends the span of a request attempt with its outcome
+func endAttemptSpan(span trace.Span, response *http.Response, err error) {
+	if err != nil {
+		tracing.Fail(span, err)
+	} else {
+		span.SetAttributes(semconv.HTTPStatusCode(response.StatusCode))
+		if response.StatusCode < 200 || response.StatusCode >= 300 {
+			tracing.Fail(span, fmt.Errorf("status %d", response.StatusCode))
+		}
+	}
+	span.End()
+}
+
+// startStreamSpan starts the span of a streamed chat completion, it lasts
+// until the stream ends
+func startStreamSpan(ctx context.Context, model string) (context.Context,
+	trace.Span) {
+	return tracing.Start(ctx, "openai chat/completions",
+		trace.WithAttributes(
+			attribute.String("openai.endpoint", "chat/completions"),
+			attribute.String("openai.model", model),
+			attribute.Bool("openai.stream", true)))
+}
+
+// errorStatus is the HTTP status carried by a go-openai error
+func errorStatus(err error) string {
+	var apiErr *go_openai.APIError
+	if errors.As(err, &apiErr) && apiErr.HTTPStatusCode > 0 {
+		return strconv.Itoa(apiErr.HTTPStatusCode)
+	}
+	var reqErr *go_openai.RequestError
+	if errors.As(err, &reqErr) && reqErr.HTTPStatusCode > 0 {
+		return strconv.Itoa(reqErr.HTTPStatusCode)
+	}
+	return "error"
+}
+
+// endpointOf reduces a request URL to the API path, such as
+// chat/completions, for both OpenAI and Azure deployments
+func endpointOf(link string) string {
+	u, err := url.Parse(link)
+	if err != nil {
+		return "unknown"
+	}
+	path := u.Path
+	if i := strings.Index(path, "/deployments/"); i >= 0 {
+		path = path[i+len("/deployments/"):]
+		if j := strings.IndexByte(path, '/'); j >= 0 {
+			return path[j+1:]
+		}
+		return path
+	}
+	if i := strings.Index(path, "/v1/"); i >= 0 {
+		return path[i+len("/v1/"):]
+	}
+	return strings.TrimPrefix(path, "/")
+}
+
+// keyLabel identifies an API key by its last characters so metrics never
+// carry a usable key
+func keyLabel(key string) string {
+	if len(key) <= 4 {
+		return "****"
+	}
+	return "..." + key[len(key)-4:]
+}
+
+// requestModel finds the model a request body asks for
+func requestModel(bodyType requestBodyType, requestBody interface{},
+	data []byte) string {
+	switch bodyType {
+	case jsonBody:
+		var body struct {
+			Model string `json:"model"`
+		}
+		json.Unmarshal(data, &body)
+		return body.Model
+	case formVoiceDataBody:
+		return requestBody.(AudioToTextRequestBody).Model
+	case formPictureDataBody:
+		return ImageModelDallE2
+	case formPictureEditBody:
+		return requestBody.(ImageEditRequestBody).Model
+	}
+	return ""
+}
+
+// registerKeyGauges exports how many of the load balancer's keys are
+// currently marked available
+func registerKeyGauges(lb *loadbalancer.LoadBalancer) {
+	metrics.NewGaugeFunc("feishubot_openai_keys",
+		"OpenAI API keys configured.", func() float64 {
+			_, total := lb.Availability()
+			return float64(total)
+		})
+	metrics.NewGaugeFunc("feishubot_openai_keys_available",
+		"OpenAI API keys currently marked available.", func() float64 {
+			available, _ := lb.Availability()
+			return float64(available)
+		})
+}
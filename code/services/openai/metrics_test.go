@@ -0,0 +1,25 @@
+package openai
+
+import "testing"
+
+func TestEndpointOf(t *testing.T) {
+	for link, want := range map[string]string{
+		"https://api.openai.com/v1/chat/completions":  "chat/completions",
+		"http://localhost:8080/proxy/v1/images/edits": "images/edits",
+		"https://res.openai.azure.com/openai/deployments/gpt4/chat/completions" +
+			"?api-version=2023-05-15": "chat/completions",
+	} {
+		if got := endpointOf(link); got != want {
+			t.Errorf("endpointOf(%q) = %q, want %q", link, got, want)
+		}
+	}
+}
+
+func TestKeyLabel(t *testing.T) {
+	if got := keyLabel("sk-abcdefgh1234"); got != "...1234" {
+		t.Errorf("keyLabel = %q", got)
+	}
+	if got := keyLabel("abc"); got != "****" {
+		t.Errorf("keyLabel of a short key = %q", got)
+	}
+}
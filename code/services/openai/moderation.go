@@ -0,0 +1,54 @@
+package openai
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+)
+
+type ModerationRequestBody struct {
+	Model string `json:"model,omitempty"`
+	Input string `json:"input"`
+}
+
+type ModerationResponseBody struct {
+	Results []struct {
+		Flagged    bool            `json:"flagged"`
+		Categories map[string]bool `json:"categories"`
+	} `json:"results"`
+}
+
+// Moderate classifies input with the moderation endpoint and returns the
+// categories it is flagged for, none when it is fine
+func (gpt *ChatGPT) Moderate(model string, input string) ([]string, error) {
+	requestBody := ModerationRequestBody{
+		Model: model,
+		Input: input,
+	}
+	moderationResponseBody := &ModerationResponseBody{}
+	err := gpt.sendRequestWithBodyType(gpt.FullUrl("moderations"),
+		http.MethodPost, jsonBody, requestBody, moderationResponseBody)
+	if err != nil {
+		return nil, err
+	}
+	if len(moderationResponseBody.Results) == 0 {
+		return nil, errors.New("empty moderation result")
+	}
+
+	var categories []string
+	for _, result := range moderationResponseBody.Results {
+		if !result.Flagged {
+			continue
+		}
+		for category, flagged := range result.Categories {
+			if flagged {
+				categories = append(categories, category)
+			}
+		}
+		if len(categories) == 0 {
+			categories = append(categories, "flagged")
+		}
+	}
+	sort.Strings(categories)
+	return categories, nil
+}
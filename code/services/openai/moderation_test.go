@@ -0,0 +1,53 @@
+package openai
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"start-feishubot/services/loadbalancer"
+)
+
+func TestModerate(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,
+		r *http.Request) {
+		if r.URL.Path != "/v1/moderations" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		var body ModerationRequestBody
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		flagged := body.Input == "bad"
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"results": []map[string]interface{}{{
+				"flagged": flagged,
+				"categories": map[string]bool{
+					"violence":   flagged,
+					"harassment": flagged,
+					"sexual":     false,
+				},
+			}},
+		})
+	}))
+	defer server.Close()
+
+	gpt := &ChatGPT{
+		Lb:       loadbalancer.NewLoadBalancer([]string{"sk-test"}),
+		ApiUrl:   server.URL,
+		Platform: OpenAI,
+	}
+	categories, err := gpt.Moderate("omni-moderation-latest", "bad")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(categories, []string{"harassment", "violence"}) {
+		t.Errorf("categories = %v", categories)
+	}
+	categories, err = gpt.Moderate("omni-moderation-latest", "fine")
+	if err != nil || len(categories) != 0 {
+		t.Errorf("fine input flagged: %v, %v", categories, err)
+	}
+}
@@ -1,50 +1,132 @@
 package openai
 
 import (
-	"bufio"
+	"bytes"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"image"
-	"image/jpeg"
+	_ "image/jpeg"
 	"image/png"
 	"io"
-	"io/ioutil"
 	"mime/multipart"
 	"os"
+	"path/filepath"
+	"sync"
 )
 
 type ImageGenerationRequestBody struct {
 	Prompt         string `json:"prompt"`
 	N              int    `json:"n"`
 	Size           string `json:"size"`
-	ResponseFormat string `json:"response_format"`
+	ResponseFormat string `json:"response_format,omitempty"`
 	Model          string `json:"model,omitempty"`
 	Style          string `json:"style,omitempty"`
+	Quality        string `json:"quality,omitempty"`
 }
 
 type ImageResponseBody struct {
 	Created int64 `json:"created"`
 	Data    []struct {
 		Base64Json string `json:"b64_json"`
+		// RevisedPrompt is the prompt dall-e-3 rewrote and drew
+		RevisedPrompt string `json:"revised_prompt,omitempty"`
 	} `json:"data"`
 }
 
+// GeneratedImage is a generated image with the prompt the model drew, when
+// it rewrote the one it was given
+type GeneratedImage struct {
+	Base64        string
+	RevisedPrompt string
+}
+
 type ImageVariantRequestBody struct {
-	Image          string `json:"image"`
-	N              int    `json:"n"`
-	Size           string `json:"size"`
-	ResponseFormat string `json:"response_format"`
+	Image string `json:"image"`
+	// ImageData is sent instead of reading the file Image when set, Image
+	// names it
+	ImageData      io.Reader `json:"-"`
+	N              int       `json:"n"`
+	Size           string    `json:"size"`
+	ResponseFormat string    `json:"response_format"`
 }
 
 func (gpt *ChatGPT) GenerateImage(prompt string, size string,
 	n int, style string) ([]string, error) {
+	images, err := gpt.GenerateImages(prompt, ImageOptions{
+		Model: ImageModelDallE3,
+		Size:  size,
+		Style: style,
+		N:     n,
+	})
+	if err != nil {
+		return nil, err
+	}
+	var b64Pool []string
+	for _, image := range images {
+		b64Pool = append(b64Pool, image.Base64)
+	}
+	return b64Pool, nil
+}
+
+// GenerateImages generates options.N images of prompt. Models that make
+// fewer images per request are sent several requests in parallel, the
+// images of the requests that succeed are returned
+func (gpt *ChatGPT) GenerateImages(prompt string,
+	options ImageOptions) ([]GeneratedImage, error) {
+	options = options.Normalize()
+	perRequest := imageModelOf(options.Model).maxN
+	var batches []int
+	for left := options.N; left > 0; left -= perRequest {
+		if left < perRequest {
+			batches = append(batches, left)
+		} else {
+			batches = append(batches, perRequest)
+		}
+	}
+
+	results := make([][]GeneratedImage, len(batches))
+	errs := make([]error, len(batches))
+	var wg sync.WaitGroup
+	for i, n := range batches {
+		wg.Add(1)
+		go func(i, n int) {
+			defer wg.Done()
+			batch := options
+			batch.N = n
+			results[i], errs[i] = gpt.generateImageBatch(prompt, batch)
+		}(i, n)
+	}
+	wg.Wait()
+
+	var images []GeneratedImage
+	for _, result := range results {
+		images = append(images, result...)
+	}
+	if len(images) == 0 {
+		for _, err := range errs {
+			if err != nil {
+				return nil, err
+			}
+		}
+		return nil, errors.New("no image returned")
+	}
+	return images, nil
+}
+
+func (gpt *ChatGPT) generateImageBatch(prompt string,
+	options ImageOptions) ([]GeneratedImage, error) {
 	requestBody := ImageGenerationRequestBody{
-		Prompt:         prompt,
-		N:              n,
-		Size:           size,
-		ResponseFormat: "b64_json",
-		Model:          "dall-e-3",
-		Style:          style,
+		Prompt:  prompt,
+		N:       options.N,
+		Size:    options.Size,
+		Model:   options.Model,
+		Style:   options.Style,
+		Quality: options.Quality,
+	}
+	// gpt-image models always answer in base64 and reject the parameter
+	if !imageModelOf(options.Model).base64Only {
+		requestBody.ResponseFormat = "b64_json"
 	}
 
 	imageResponseBody := &ImageResponseBody{}
@@ -55,11 +137,14 @@ func (gpt *ChatGPT) GenerateImage(prompt string, size string,
 		return nil, err
 	}
 
-	var b64Pool []string
+	var images []GeneratedImage
 	for _, data := range imageResponseBody.Data {
-		b64Pool = append(b64Pool, data.Base64Json)
+		images = append(images, GeneratedImage{
+			Base64:        data.Base64Json,
+			RevisedPrompt: data.RevisedPrompt,
+		})
 	}
-	return b64Pool, nil
+	return images, nil
 }
 
 func (gpt *ChatGPT) GenerateOneImage(prompt string,
@@ -77,12 +162,16 @@ func (gpt *ChatGPT) GenerateOneImageWithDefaultSize(
 	return gpt.GenerateOneImage(prompt, "1024x1024", "")
 }
 
-func (gpt *ChatGPT) GenerateImageVariation(images string,
+// GenerateImageVariation returns n variations of the square PNG image,
+// only dall-e-2 makes variations so sizes it does not know fall back to
+// its default
+func (gpt *ChatGPT) GenerateImageVariation(image io.Reader,
 	size string, n int) ([]string, error) {
 	requestBody := ImageVariantRequestBody{
-		Image:          images,
+		Image:          "image.png",
+		ImageData:      image,
 		N:              n,
-		Size:           size,
+		Size:           oneOf(size, imageModels[ImageModelDallE2].sizes),
 		ResponseFormat: "b64_json",
 	}
 
@@ -101,9 +190,9 @@ func (gpt *ChatGPT) GenerateImageVariation(images string,
 	return b64Pool, nil
 }
 
-func (gpt *ChatGPT) GenerateOneImageVariation(images string,
+func (gpt *ChatGPT) GenerateOneImageVariation(image io.Reader,
 	size string) (string, error) {
-	b64s, err := gpt.GenerateImageVariation(images, size, 1)
+	b64s, err := gpt.GenerateImageVariation(image, size, 1)
 	if err != nil {
 		return "", err
 	}
@@ -112,17 +201,21 @@ func (gpt *ChatGPT) GenerateOneImageVariation(images string,
 
 func pictureMultipartForm(request ImageVariantRequestBody,
 	w *multipart.Writer) error {
-
-	f, err := os.Open(request.Image)
-	if err != nil {
-		return fmt.Errorf("opening audio file: %w", err)
+	image := request.ImageData
+	if image == nil {
+		f, err := os.Open(request.Image)
+		if err != nil {
+			return fmt.Errorf("opening image file: %w", err)
+		}
+		defer f.Close()
+		image = f
 	}
-	fw, err := w.CreateFormFile("image", f.Name())
+	fw, err := w.CreateFormFile("image", filepath.Base(request.Image))
 	if err != nil {
 		return fmt.Errorf("creating form file: %w", err)
 	}
-	if _, err = io.Copy(fw, f); err != nil {
-		return fmt.Errorf("reading from opened audio file: %w", err)
+	if _, err = io.Copy(fw, image); err != nil {
+		return fmt.Errorf("reading image: %w", err)
 	}
 
 	err = w.WriteField("size", request.Size)
@@ -140,44 +233,24 @@ func pictureMultipartForm(request ImageVariantRequestBody,
 		return fmt.Errorf("writing response_format: %w", err)
 	}
 
-	//err = w.WriteField("user", "user123456")
-
-	//fw, err = w.CreateFormField("model")
-	//if err != nil {
-	//	return fmt.Errorf("creating form field: %w", err)
-	//}
-	//modelName := bytes.NewReader([]byte(request.Model))
-	//if _, err = io.Copy(fw, modelName); err != nil {
-	//	return fmt.Errorf("writing model name: %w", err)
-	//}
-
-	//fmt.Printf("w.FormDataContentType(): %s ", w.FormDataContentType())
-
 	w.Close()
 
 	return nil
 }
 
-func VerifyPngs(pngPaths []string) error {
+// VerifyPngs checks the images are square PNGs of the same size under
+// 4MB, as the image edit and variation endpoints require
+func VerifyPngs(pngs [][]byte) error {
 	foundPng := false
 	var expectedWidth, expectedHeight int
 
-	for _, pngPath := range pngPaths {
-		f, err := os.Open(pngPath)
-		if err != nil {
-			return fmt.Errorf("os.Open: %v", err)
-		}
-
-		fi, err := f.Stat()
-		if err != nil {
-			return fmt.Errorf("f.Stat: %v", err)
-		}
-		if fi.Size() > 4*1024*1024 {
+	for _, data := range pngs {
+		if len(data) > 4*1024*1024 {
 			return fmt.Errorf("image size too large, "+
 				"must be under %d MB", 4)
 		}
 
-		image, err := png.Decode(f)
+		image, err := png.Decode(bytes.NewReader(data))
 		if err != nil {
 			return fmt.Errorf("image must be valid png, got error: %v", err)
 		}
@@ -201,123 +274,47 @@ func VerifyPngs(pngPaths []string) error {
 	return nil
 }
 
-func ConvertToRGBA(inputFilePath string, outputFilePath string) error {
-	// Open input file
-	inputFile, err := os.Open(inputFilePath)
-	if err != nil {
-		return fmt.Errorf("error opening file: %w", err)
-	}
-	defer inputFile.Close()
-
+// ConvertToRGBA decodes a PNG or JPEG image and encodes it as an RGBA PNG
+func ConvertToRGBA(input io.Reader) ([]byte, error) {
 	// Decode image
-	img, _, err := image.Decode(inputFile)
+	img, _, err := image.Decode(input)
 	if err != nil {
-		return fmt.Errorf("error decoding image: %w", err)
+		return nil, fmt.Errorf("error decoding image: %w", err)
 	}
 
 	// Convert image to RGBA mode
-	rgba := image.NewRGBA(img.Bounds())
-	for x := 0; x < img.Bounds().Max.X; x++ {
-		for y := 0; y < img.Bounds().Max.Y; y++ {
+	bounds := img.Bounds()
+	rgba := image.NewRGBA(bounds)
+	for x := bounds.Min.X; x < bounds.Max.X; x++ {
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
 			rgba.Set(x, y, img.At(x, y))
 		}
 	}
 
-	// Create output file
-	outputFile, err := os.Create(outputFilePath)
-	if err != nil {
-		return fmt.Errorf("error creating output file: %w", err)
-	}
-	defer outputFile.Close()
-
-	// Encode image as PNG format and write to output file
-	if err := png.Encode(outputFile, rgba); err != nil {
-		return fmt.Errorf("error encoding image: %w", err)
-	}
-
-	return nil
-}
-
-func ConvertJpegToPNG(jpgPath string) error {
-	// Open the JPEG file for reading
-	f, err := os.Open(jpgPath)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-
-	// Check if the file is a JPEG image
-	_, err = jpeg.Decode(f)
-	if err != nil {
-		// The file is not a JPEG image, no need to convert it
-		return fmt.Errorf("file %s is not a JPEG image", jpgPath)
-	}
-
-	// Reset the file pointer to the beginning of the file
-	_, err = f.Seek(0, 0)
-	if err != nil {
-		return err
-	}
-
-	// Create a new PNG file for writing
-	pngPath := jpgPath[:len(jpgPath)-4] + ".png" // replace .jpg extension with .png
-	out, err := os.Create(pngPath)
-	if err != nil {
-		return err
-	}
-	defer out.Close()
-
-	// Decode the JPEG image and encode it as PNG
-	img, err := jpeg.Decode(f)
-	if err != nil {
-		return err
+	// Encode image as PNG format
+	var output bytes.Buffer
+	if err := png.Encode(&output, rgba); err != nil {
+		return nil, fmt.Errorf("error encoding image: %w", err)
 	}
-	err = png.Encode(out, img)
-	if err != nil {
-		return err
-	}
-
-	return nil
+	return output.Bytes(), nil
 }
 
-func GetImageCompressionType(path string) (string, error) {
-	// Open file
-	file, err := os.Open(path)
-	if err != nil {
-		return "", err
-	}
-	defer file.Close()
-
-	// Create bufio.Reader
-	reader := bufio.NewReader(file)
-
-	// Decode image
-	_, format, err := image.DecodeConfig(reader)
+// GetImageCompressionType returns the format of an image, such as png or
+// jpeg
+func GetImageCompressionType(input io.Reader) (string, error) {
+	_, format, err := image.DecodeConfig(input)
 	if err != nil {
-		fmt.Println("err: ", err)
 		return "", err
 	}
-
-	fmt.Println("format: ", format)
-	// Return compression type
 	return format, nil
 }
 
-func GetBase64FromImage(imagePath string) (string, error) {
-	// Open file
-	// Read image file
-	imageFile, err := os.Open(imagePath)
-	if err != nil {
-		return "", err
-	}
-	defer imageFile.Close()
+func GetBase64FromImage(input io.Reader) (string, error) {
 	// Read image content
-	imageData, err := ioutil.ReadAll(imageFile)
+	imageData, err := io.ReadAll(input)
 	if err != nil {
 		return "", err
 	}
 	// Convert image content to base64 encoding
-	base64String := base64.StdEncoding.EncodeToString(imageData)
-
-	return base64String, nil
+	return base64.StdEncoding.EncodeToString(imageData), nil
 }
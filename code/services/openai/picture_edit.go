@@ -0,0 +1,110 @@
+package openai
+
+import (
+	"fmt"
+	"io"
+	"mime/multipart"
+)
+
+// ImageEditRequestBody asks to repaint the transparent areas of Image, or
+// of Mask when one is given, following Prompt
+type ImageEditRequestBody struct {
+	Image          io.Reader `json:"-"`
+	Mask           io.Reader `json:"-"`
+	Prompt         string    `json:"prompt"`
+	N              int       `json:"n"`
+	Size           string    `json:"size"`
+	ResponseFormat string    `json:"response_format"`
+	Model          string    `json:"model,omitempty"`
+}
+
+// editSizes are the sizes the image edit endpoint accepts
+var editSizes = map[string]bool{
+	"256x256":   true,
+	"512x512":   true,
+	"1024x1024": true,
+}
+
+// GenerateImageEdit edits a square PNG image following prompt, mask is an
+// optional PNG of the same size whose transparent areas mark what to
+// change. Sizes the endpoint does not accept fall back to 1024x1024
+func (gpt *ChatGPT) GenerateImageEdit(image io.Reader, mask io.Reader,
+	prompt string, size string, n int) ([]string, error) {
+	if !editSizes[size] {
+		size = "1024x1024"
+	}
+	requestBody := ImageEditRequestBody{
+		Image:          image,
+		Mask:           mask,
+		Prompt:         prompt,
+		N:              n,
+		Size:           size,
+		ResponseFormat: "b64_json",
+		Model:          "dall-e-2",
+	}
+
+	imageResponseBody := &ImageResponseBody{}
+	err := gpt.sendRequestWithBodyType(gpt.FullUrl("images/edits"),
+		"POST", formPictureEditBody, requestBody, imageResponseBody)
+	if err != nil {
+		return nil, err
+	}
+
+	var b64Pool []string
+	for _, data := range imageResponseBody.Data {
+		b64Pool = append(b64Pool, data.Base64Json)
+	}
+	return b64Pool, nil
+}
+
+func (gpt *ChatGPT) GenerateOneImageEdit(image io.Reader, mask io.Reader,
+	prompt string, size string) (string, error) {
+	b64s, err := gpt.GenerateImageEdit(image, mask, prompt, size, 1)
+	if err != nil {
+		return "", err
+	}
+	if len(b64s) == 0 {
+		return "", fmt.Errorf("no image returned")
+	}
+	return b64s[0], nil
+}
+
+func pictureEditMultipartForm(request ImageEditRequestBody,
+	w *multipart.Writer) error {
+	files := []struct {
+		field string
+		data  io.Reader
+	}{
+		{"image", request.Image},
+		{"mask", request.Mask},
+	}
+	for _, file := range files {
+		if file.data == nil {
+			continue
+		}
+		fw, err := w.CreateFormFile(file.field, file.field+".png")
+		if err != nil {
+			return fmt.Errorf("creating form file: %w", err)
+		}
+		if _, err = io.Copy(fw, file.data); err != nil {
+			return fmt.Errorf("reading %s: %w", file.field, err)
+		}
+	}
+
+	fields := []struct{ name, value string }{
+		{"prompt", request.Prompt},
+		{"n", fmt.Sprintf("%d", request.N)},
+		{"size", request.Size},
+		{"response_format", request.ResponseFormat},
+		{"model", request.Model},
+	}
+	for _, field := range fields {
+		if field.value == "" {
+			continue
+		}
+		if err := w.WriteField(field.name, field.value); err != nil {
+			return fmt.Errorf("writing %s: %w", field.name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package openai
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"start-feishubot/services/loadbalancer"
+)
+
+func TestGenerateImageEdit(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,
+		r *http.Request) {
+		if r.URL.Path != "/v1/images/edits" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			t.Fatal(err)
+		}
+		if got := r.FormValue("size"); got != "1024x1024" {
+			t.Errorf("size = %q, want the 1024x1024 fallback", got)
+		}
+		if got := r.FormValue("prompt"); got != "add a hat" {
+			t.Errorf("prompt = %q", got)
+		}
+		for field, want := range map[string]string{"image": "IMG", "mask": "MASK"} {
+			file, _, err := r.FormFile(field)
+			if err != nil {
+				t.Fatalf("%s: %v", field, err)
+			}
+			data, _ := io.ReadAll(file)
+			if string(data) != want {
+				t.Errorf("%s = %q, want %q", field, data, want)
+			}
+		}
+		w.Write([]byte(`{"data":[{"b64_json":"AAAA"}]}`))
+	}))
+	defer server.Close()
+
+	gpt := &ChatGPT{
+		Lb:       loadbalancer.NewLoadBalancer([]string{"sk-test"}),
+		ApiUrl:   server.URL,
+		Platform: OpenAI,
+	}
+	b64, err := gpt.GenerateOneImageEdit(strings.NewReader("IMG"),
+		strings.NewReader("MASK"), "add a hat", "1024x1792")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b64 != "AAAA" {
+		t.Errorf("b64 = %q", b64)
+	}
+}
@@ -0,0 +1,37 @@
+package openai
+
+import (
+	"net/http"
+)
+
+// maxSpeechInputRunes is the longest text the speech endpoint accepts
+const maxSpeechInputRunes = 4096
+
+type TextToSpeechRequestBody struct {
+	Model          string  `json:"model"`
+	Input          string  `json:"input"`
+	Voice          string  `json:"voice"`
+	ResponseFormat string  `json:"response_format,omitempty"`
+	Speed          float64 `json:"speed,omitempty"`
+}
+
+// TextToSpeech reads text aloud and returns the audio as Ogg Opus, text
+// longer than the endpoint accepts is cut short
+func (gpt *ChatGPT) TextToSpeech(model, voice, text string) ([]byte, error) {
+	if runes := []rune(text); len(runes) > maxSpeechInputRunes {
+		text = string(runes[:maxSpeechInputRunes])
+	}
+	requestBody := TextToSpeechRequestBody{
+		Model:          model,
+		Input:          text,
+		Voice:          voice,
+		ResponseFormat: "opus",
+	}
+	var speech []byte
+	err := gpt.sendRequestWithBodyType(gpt.FullUrl("audio/speech"),
+		http.MethodPost, jsonBody, requestBody, &speech)
+	if err != nil {
+		return nil, err
+	}
+	return speech, nil
+}
@@ -0,0 +1,50 @@
+package openai
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"unicode/utf8"
+
+	"start-feishubot/services/loadbalancer"
+)
+
+func TestTextToSpeech(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,
+		r *http.Request) {
+		if r.URL.Path != "/v1/audio/speech" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		var body TextToSpeechRequestBody
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		if body.ResponseFormat != "opus" || body.Voice != "alloy" {
+			t.Errorf("unexpected request %+v", body)
+		}
+		if n := utf8.RuneCountInString(body.Input); n != maxSpeechInputRunes {
+			t.Errorf("input of %d runes, want %d", n, maxSpeechInputRunes)
+		}
+		w.Header().Set("Content-Type", "audio/ogg")
+		w.Write([]byte("OggS\x00binary"))
+	}))
+	defer server.Close()
+
+	gpt := &ChatGPT{
+		Lb:       loadbalancer.NewLoadBalancer([]string{"sk-test"}),
+		ApiUrl:   server.URL,
+		Platform: OpenAI,
+	}
+	long := make([]rune, maxSpeechInputRunes+10)
+	for i := range long {
+		long[i] = 'é'
+	}
+	speech, err := gpt.TextToSpeech("tts-1", "alloy", string(long))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(speech) != "OggS\x00binary" {
+		t.Errorf("speech = %q", speech)
+	}
+}
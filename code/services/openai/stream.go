@@ -1,16 +1,37 @@
 package openai
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	go_openai "github.com/sashabaranov/go-openai"
 	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"start-feishubot/services/audit"
+	"start-feishubot/tracing"
 )
 
 func (c *ChatGPT) StreamChat(ctx context.Context,
 	msg []Messages, mode AIMode,
 	responseStream chan string) error {
+	// go-openai only carries text content, image parts are streamed directly
+	if HasImages(msg) {
+		return c.streamCompletions(ctx, streamRequestBody{
+			Model:       c.Model,
+			Messages:    msg,
+			MaxTokens:   c.MaxTokens,
+			Temperature: float32(mode),
+			Stream:      true,
+		}, responseStream)
+	}
 	//change msg type from Messages to openai.ChatCompletionMessage
 	chatMsgs := make([]go_openai.ChatCompletionMessage, len(msg))
 	for i, m := range msg {
@@ -23,15 +44,33 @@ func (c *ChatGPT) StreamChat(ctx context.Context,
 		responseStream)
 }
 
+// StreamVisionInfo is the streaming counterpart of GetVisionInfo
+func (c *ChatGPT) StreamVisionInfo(ctx context.Context,
+	msg []VisionMessages, responseStream chan string) error {
+	return c.streamCompletions(ctx, streamRequestBody{
+		Model:     c.visionModel(),
+		Messages:  msg,
+		MaxTokens: c.MaxTokens,
+		Stream:    true,
+	}, responseStream)
+}
+
 func (c *ChatGPT) StreamChatWithHistory(ctx context.Context,
 	msg []go_openai.ChatCompletionMessage, maxTokens int,
 	aiMode AIMode,
 	responseStream chan string,
-) error {
+) (err error) {
+	ctx, span := startStreamSpan(ctx, c.Model)
+	defer func() {
+		tracing.Fail(span, err)
+		span.End()
+	}()
 
-	config := go_openai.DefaultConfig(c.ApiKey[0])
+	key := c.ApiKey[0]
+	config := go_openai.DefaultConfig(key)
 	config.BaseURL = c.ApiUrl + "/v1"
 	if c.Platform != OpenAI {
+		key = c.AzureConfig.ApiToken
 		baseUrl := fmt.Sprintf("https://%s.%s",
 			c.AzureConfig.ResourceName, "openai.azure.com")
 		config = go_openai.DefaultAzureConfig(c.AzureConfig.
@@ -60,28 +99,157 @@ func (c *ChatGPT) StreamChatWithHistory(ctx context.Context,
 		Temperature: temperature,
 		MaxTokens:   maxTokens,
 		//TopP:        1,
-		//Moderation:     true,
-		//ModerationStop: true,
 	}
+	// the answer is audited as streamed, up to a failure or a stop
+	var answer strings.Builder
+	defer func() {
+		requestData, _ := json.Marshal(req)
+		audit.RecordProviderCall(ctx, "chat/completions", c.Model,
+			requestData, []byte(answer.String()), err)
+	}()
+	start := time.Now()
 	stream, err := client.CreateChatCompletionStream(ctx, req)
 	if err != nil {
-		fmt.Errorf("CreateCompletionStream returned error: %v", err)
+		observeRequest("chat/completions", c.Model, errorStatus(err),
+			key, start)
+		return fmt.Errorf("CreateCompletionStream returned error: %v", err)
 	}
 
 	defer stream.Close()
+	// the latency covers the whole stream, observed once it ends
+	defer observeRequest("chat/completions", c.Model, "200",
+		key, start)
 	for {
 		response, err := stream.Recv()
-		fmt.Println("response: ", response)
 		if errors.Is(err, io.EOF) {
 			//fmt.Println("Stream finished")
 			return nil
 		}
 		if err != nil {
-			fmt.Printf("Stream error: %v\n", err)
 			return err
 		}
-		responseStream <- response.Choices[0].Delta.Content
+		if len(response.Choices) == 0 {
+			continue
+		}
+		answer.WriteString(response.Choices[0].Delta.Content)
+		select {
+		case responseStream <- response.Choices[0].Delta.Content:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+type streamRequestBody struct {
+	Model       string      `json:"model"`
+	Messages    interface{} `json:"messages"`
+	MaxTokens   int         `json:"max_tokens"`
+	Temperature float32     `json:"temperature,omitempty"`
+	Stream      bool        `json:"stream"`
+}
+
+type streamResponseBody struct {
+	Choices []struct {
+		Delta struct {
+			Content string `json:"content"`
+		} `json:"delta"`
+	} `json:"choices"`
+}
+
+// streamCompletions posts a chat completion request with stream enabled
+// and forwards the content deltas of the server-sent events
+func (c *ChatGPT) streamCompletions(ctx context.Context,
+	requestBody streamRequestBody, responseStream chan string) (err error) {
+	ctx, span := startStreamSpan(ctx, requestBody.Model)
+	defer func() {
+		tracing.Fail(span, err)
+		span.End()
+	}()
+	requestBodyData, err := json.Marshal(requestBody)
+	if err != nil {
+		return err
+	}
+	var answer strings.Builder
+	defer func() {
+		audit.RecordProviderCall(ctx, "chat/completions", requestBody.Model,
+			requestBodyData, []byte(answer.String()), err)
+	}()
+	api := c.Lb.GetAPI()
+	if api == nil {
+		return errors.New("no available API")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		c.FullUrl("chat/completions"), bytes.NewReader(requestBodyData))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "text/event-stream")
+	if c.Platform == OpenAI {
+		req.Header.Set("Authorization", "Bearer "+api.Key)
+	} else {
+		req.Header.Set("api-key", c.AzureConfig.ApiToken)
+	}
+
+	client, err := GetProxyClient(c.HttpProxy)
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	response, err := client.Do(req)
+	if err != nil {
+		observeRequest("chat/completions", requestBody.Model, "error",
+			api.Key, start)
+		return err
+	}
+	defer response.Body.Close()
+	// the latency covers the whole stream, observed once it ends
+	defer observeRequest("chat/completions", requestBody.Model,
+		strconv.Itoa(response.StatusCode), api.Key, start)
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		body, _ := ioutil.ReadAll(response.Body)
+		c.Lb.SetAvailability(api.Key, false)
+		return fmt.Errorf("stream request failed with status %d: %s",
+			response.StatusCode, string(body))
+	}
+
+	reader := bufio.NewReader(response.Body)
+	for {
+		line, err := reader.ReadString('\n')
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		data, found := cutDataPrefix(line)
+		if !found {
+			continue
+		}
+		if data == "[DONE]" {
+			return nil
+		}
+		var chunk streamResponseBody
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return fmt.Errorf("decoding stream chunk: %w", err)
+		}
+		if len(chunk.Choices) == 0 || chunk.Choices[0].Delta.Content == "" {
+			continue
+		}
+		answer.WriteString(chunk.Choices[0].Delta.Content)
+		select {
+		case responseStream <- chunk.Choices[0].Delta.Content:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
 	}
-	return nil
+}
 
+func cutDataPrefix(line string) (string, bool) {
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, "data:") {
+		return "", false
+	}
+	return strings.TrimSpace(strings.TrimPrefix(line, "data:")), true
 }
@@ -1,8 +1,11 @@
 package openai
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
 	"start-feishubot/logger"
+	"strings"
 )
 
 type ImageURL struct {
@@ -29,13 +32,13 @@ type VisionRequestBody struct {
 func (gpt *ChatGPT) GetVisionInfo(msg []VisionMessages) (
 	resp Messages, err error) {
 	requestBody := VisionRequestBody{
-		Model:     "gpt-4-vision-preview",
+		Model:     gpt.visionModel(),
 		Messages:  msg,
 		MaxTokens: gpt.MaxTokens,
 	}
 	gptResponseBody := &ChatGPTResponseBody{}
 	url := gpt.FullUrl("chat/completions")
-	logger.Debug("request body ", requestBody)
+	logger.Debug("request body", logger.UserContent(requestBody))
 	if url == "" {
 		return resp, errors.New("unable to get openai request URL")
 	}
@@ -51,3 +54,88 @@ func (gpt *ChatGPT) GetVisionInfo(msg []VisionMessages) (
 	}
 	return resp, err
 }
+
+// approximate prompt cost of one image, used to bound the session history
+const (
+	lowDetailImageTokens  = 85
+	highDetailImageTokens = 765
+)
+
+// text-only variants of otherwise multimodal model families
+var textOnlyModelPrefixes = []string{"o1-mini", "o1-preview", "o3-mini"}
+
+var multimodalModelPrefixes = []string{
+	"gpt-4o", "chatgpt-4o", "gpt-4-turbo", "gpt-4-vision", "gpt-4.1",
+	"gpt-4.5", "gpt-5", "o1", "o3", "o4",
+}
+
+// IsMultimodalModel reports whether the model accepts image inputs in
+// chat completions
+func IsMultimodalModel(model string) bool {
+	model = strings.ToLower(model)
+	for _, prefix := range textOnlyModelPrefixes {
+		if strings.HasPrefix(model, prefix) {
+			return false
+		}
+	}
+	for _, prefix := range multimodalModelPrefixes {
+		if strings.HasPrefix(model, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (image *ImageURL) CalculateTokenLength() int {
+	if image.Detail == "low" {
+		return lowDetailImageTokens
+	}
+	return highDetailImageTokens
+}
+
+// MarshalJSON sends messages carrying images as multi-part content,
+// plain text messages keep the string content format
+func (msg Messages) MarshalJSON() ([]byte, error) {
+	if len(msg.Images) == 0 {
+		type plainMessages Messages
+		return json.Marshal(plainMessages(msg))
+	}
+	var content []ContentType
+	if msg.Content != "" {
+		content = append(content, ContentType{Type: "text", Text: msg.Content})
+	}
+	for i := range msg.Images {
+		content = append(content, ContentType{
+			Type: "image_url", ImageURL: &msg.Images[i],
+		})
+	}
+	return json.Marshal(VisionMessages{Role: msg.Role, Content: content})
+}
+
+// visionModel answers image requests with the chat model when it accepts
+// images
+func (gpt *ChatGPT) visionModel() string {
+	if gpt.Multimodal {
+		return gpt.Model
+	}
+	return "gpt-4-vision-preview"
+}
+
+// HasImages reports whether any message of the conversation carries images
+func HasImages(msg []Messages) bool {
+	for _, m := range msg {
+		if len(m.Images) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// String keeps base64 payloads out of logs
+func (image ImageURL) String() string {
+	url := image.URL
+	if len(url) > 64 {
+		url = url[:64] + "..."
+	}
+	return fmt.Sprintf("{%s %s}", url, image.Detail)
+}
@@ -0,0 +1,282 @@
+// Package pii replaces personal data and secrets in text sent to the AI
+// providers with placeholders such as [EMAIL_1], and puts the originals
+// back into the answers. Placeholders are kept per session so the same
+// value gets the same placeholder in every turn of a conversation.
+package pii
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+)
+
+// Kinds of data detected
+const (
+	KindEmail  = "EMAIL"
+	KindPhone  = "PHONE"
+	KindCard   = "CARD"   // payment card numbers
+	KindSecret = "SECRET" // API keys, tokens and private keys
+)
+
+// restorable kinds are put back into answers, card numbers and secrets
+// stay masked since the answer may be read by others
+var restorable = map[string]bool{KindEmail: true, KindPhone: true}
+
+// vaultTTL matches how long sessions are kept
+const vaultTTL = 12 * time.Hour
+
+type detector struct {
+	kind  string
+	re    *regexp.Regexp
+	valid func(match string) bool
+}
+
+// builtin detectors, in the order they run: cards before phones since a
+// card number also looks like a long phone number. Phone groups after the
+// first have 3 or 4 digits so dates such as 2024-01-15 are left alone
+var builtin = []detector{
+	{kind: KindSecret, re: regexp.MustCompile(`(?s)-----BEGIN [A-Z ]*PRIVATE KEY-----.*?-----END [A-Z ]*PRIVATE KEY-----`)},
+	{kind: KindSecret, re: regexp.MustCompile(`\b(?:sk-[A-Za-z0-9_\-]{16,}|` +
+		`AKIA[0-9A-Z]{16}|gh[pousr]_[A-Za-z0-9]{36,}|xox[abprs]-[A-Za-z0-9\-]{10,}|` +
+		`AIza[0-9A-Za-z_\-]{35}|eyJ[A-Za-z0-9_\-]{8,}\.[A-Za-z0-9_\-]{8,}\.[A-Za-z0-9_\-]{8,})`)},
+	{kind: KindEmail, re: regexp.MustCompile(`\b[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}\b`)},
+	{kind: KindCard, re: regexp.MustCompile(`\b\d(?:[ \-]?\d){12,18}\b`), valid: luhn},
+	{kind: KindPhone, re: regexp.MustCompile(`(?:\+\d{1,3}[ .\-]?)?(?:\(\d{1,4}\)[ .\-]?)?\d{2,4}(?:[ .\-]?\d{3,4}){1,4}\b`),
+		valid: phoneNumber},
+}
+
+// Options configure the redactor
+type Options struct {
+	Kinds        []string // builtin kinds to detect, all when empty
+	PatternsFile string   // custom regular expressions, one per line
+	Restore      bool     // put restorable kinds back into answers
+}
+
+// Redactor replaces detected data with placeholders
+type Redactor struct {
+	detectors []detector
+	restore   bool
+	vaults    *cache.Cache
+}
+
+// New returns a redactor for the enabled kinds and the patterns file.
+// A line of the file is a regular expression, optionally prefixed by the
+// name of its placeholders such as EMPLOYEE_ID=\bE\d{6}\b
+func New(opts Options) (*Redactor, error) {
+	enabled := map[string]bool{}
+	for _, kind := range opts.Kinds {
+		kind = strings.ToUpper(strings.TrimSpace(kind))
+		switch kind {
+		case KindEmail, KindPhone, KindCard, KindSecret:
+			enabled[kind] = true
+		default:
+			return nil, fmt.Errorf("unknown redaction kind %q", kind)
+		}
+	}
+	r := &Redactor{
+		restore: opts.Restore,
+		vaults:  cache.New(vaultTTL, time.Hour),
+	}
+	for _, d := range builtin {
+		if len(enabled) == 0 || enabled[d.kind] {
+			r.detectors = append(r.detectors, d)
+		}
+	}
+	if opts.PatternsFile != "" {
+		custom, err := loadPatterns(opts.PatternsFile)
+		if err != nil {
+			return nil, err
+		}
+		// custom patterns run first, they are the most specific
+		r.detectors = append(custom, r.detectors...)
+	}
+	return r, nil
+}
+
+var customName = regexp.MustCompile(`^([A-Z][A-Z0-9_]*)=(.+)$`)
+
+func loadPatterns(path string) ([]detector, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open redaction patterns failed: %w", err)
+	}
+	defer file.Close()
+	var detectors []detector
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		kind, expr := "CUSTOM", line
+		if m := customName.FindStringSubmatch(line); m != nil {
+			kind, expr = m[1], m[2]
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("redaction pattern on line %d: %w", n, err)
+		}
+		detectors = append(detectors, detector{kind: kind, re: re})
+	}
+	return detectors, scanner.Err()
+}
+
+// vault maps the values of a session to their placeholders
+type vault struct {
+	mu       sync.Mutex
+	byValue  map[string]string
+	byHolder map[string]string
+	counts   map[string]int
+}
+
+func (r *Redactor) vault(session string) *vault {
+	if v, ok := r.vaults.Get(session); ok {
+		r.vaults.Set(session, v, vaultTTL)
+		return v.(*vault)
+	}
+	v := &vault{byValue: map[string]string{}, byHolder: map[string]string{},
+		counts: map[string]int{}}
+	if err := r.vaults.Add(session, v, vaultTTL); err != nil {
+		// added by a concurrent message of the session
+		if existing, ok := r.vaults.Get(session); ok {
+			return existing.(*vault)
+		}
+	}
+	return v
+}
+
+func (v *vault) placeholder(kind, value string) string {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if holder, ok := v.byValue[value]; ok {
+		return holder
+	}
+	v.counts[kind]++
+	holder := fmt.Sprintf("[%s_%d]", kind, v.counts[kind])
+	v.byValue[value] = holder
+	v.byHolder[holder] = value
+	return holder
+}
+
+// Redact replaces the data detected in text with placeholders of session
+func (r *Redactor) Redact(session, text string) string {
+	if text == "" {
+		return text
+	}
+	v := r.vault(session)
+	for _, d := range r.detectors {
+		text = d.replace(text, func(match string) string {
+			return v.placeholder(d.kind, match)
+		})
+	}
+	return text
+}
+
+func (d detector) replace(text string, holder func(string) string) string {
+	var sb strings.Builder
+	last := 0
+	for _, loc := range d.re.FindAllStringIndex(text, -1) {
+		match := text[loc[0]:loc[1]]
+		// digits glued to letters are identifiers rather than numbers
+		if d.valid != nil && (!d.valid(match) ||
+			(loc[0] > 0 && isWordByte(text[loc[0]-1]))) {
+			continue
+		}
+		sb.WriteString(text[last:loc[0]])
+		sb.WriteString(holder(match))
+		last = loc[1]
+	}
+	if last == 0 {
+		return text
+	}
+	sb.WriteString(text[last:])
+	return sb.String()
+}
+
+// Restore puts the restorable values of session back in place of their
+// placeholders, custom kinds included
+func (r *Redactor) Restore(session, text string) string {
+	if !r.restore || !strings.Contains(text, "[") {
+		return text
+	}
+	value, ok := r.vaults.Get(session)
+	if !ok {
+		return text
+	}
+	v := value.(*vault)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	holders := make([]string, 0, len(v.byHolder))
+	for holder := range v.byHolder {
+		kind := holder[1:strings.LastIndexByte(holder, '_')]
+		if restorable[kind] || !isBuiltin(kind) {
+			holders = append(holders, holder)
+		}
+	}
+	// [EMAIL_10] before [EMAIL_1]
+	sort.Slice(holders, func(i, j int) bool {
+		return len(holders[i]) > len(holders[j])
+	})
+	for _, holder := range holders {
+		text = strings.ReplaceAll(text, holder, v.byHolder[holder])
+	}
+	return text
+}
+
+func isBuiltin(kind string) bool {
+	return kind == KindEmail || kind == KindPhone || kind == KindCard ||
+		kind == KindSecret
+}
+
+func isWordByte(b byte) bool {
+	return b == '_' || b >= '0' && b <= '9' || b >= 'a' && b <= 'z' ||
+		b >= 'A' && b <= 'Z'
+}
+
+func digits(s string) []int {
+	var ds []int
+	for _, c := range s {
+		if c >= '0' && c <= '9' {
+			ds = append(ds, int(c-'0'))
+		}
+	}
+	return ds
+}
+
+// luhn validates the checksum of payment card numbers
+func luhn(s string) bool {
+	ds := digits(s)
+	if len(ds) < 13 || len(ds) > 19 {
+		return false
+	}
+	sum := 0
+	for i := len(ds) - 1; i >= 0; i-- {
+		d := ds[i]
+		if (len(ds)-1-i)%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+	return sum%10 == 0
+}
+
+// phoneNumber accepts numbers of 8 to 15 digits, the lengths of phone
+// numbers with or without country code, written the way phone numbers are:
+// with a country code, separators or a 0 trunk prefix. Compact dates such
+// as 20240115 and amounts such as 1500000000 have none of them
+func phoneNumber(s string) bool {
+	if n := len(digits(s)); n < 8 || n > 15 {
+		return false
+	}
+	return s[0] == '+' || s[0] == '0' || strings.ContainsAny(s, " .-()")
+}
@@ -0,0 +1,74 @@
+package services
+
+import (
+	"sync"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+)
+
+// Turn links a user message to its session and the bot replies to it
+type Turn struct {
+	SessionId string
+	ReplyIds  []string
+}
+
+type ReplyService struct {
+	cache *cache.Cache
+	mu    sync.Mutex
+}
+type ReplyCacheInterface interface {
+	SetSession(msgId string, sessionId string)
+	AddReply(msgId string, replyId string)
+	Get(msgId string) *Turn
+	Clear(msgId string)
+}
+
+var replyServices *ReplyService
+
+func (r *ReplyService) SetSession(msgId string, sessionId string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	turn := r.get(msgId)
+	turn.SessionId = sessionId
+	r.cache.Set(msgId, turn, time.Hour*12)
+}
+
+func (r *ReplyService) AddReply(msgId string, replyId string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	turn := r.get(msgId)
+	turn.ReplyIds = append(turn.ReplyIds, replyId)
+	r.cache.Set(msgId, turn, time.Hour*12)
+}
+
+func (r *ReplyService) Get(msgId string) *Turn {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	turn, ok := r.cache.Get(msgId)
+	if !ok {
+		return nil
+	}
+	copied := *turn.(*Turn)
+	copied.ReplyIds = append([]string(nil), copied.ReplyIds...)
+	return &copied
+}
+
+func (r *ReplyService) Clear(msgId string) {
+	r.cache.Delete(msgId)
+}
+
+func (r *ReplyService) get(msgId string) *Turn {
+	turn, ok := r.cache.Get(msgId)
+	if !ok {
+		return &Turn{}
+	}
+	return turn.(*Turn)
+}
+
+func GetReplyCache() ReplyCacheInterface {
+	if replyServices == nil {
+		replyServices = &ReplyService{cache: cache.New(time.Hour*12, time.Hour*1)}
+	}
+	return replyServices
+}
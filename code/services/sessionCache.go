@@ -1,6 +1,7 @@
 package services
 
 import (
+	"start-feishubot/metrics"
 	"start-feishubot/services/openai"
 	"time"
 
@@ -15,6 +16,10 @@ type SessionService struct {
 type PicSetting struct {
 	resolution Resolution
 	style      PicStyle
+	model      string
+	quality    string
+	count      int
+	enhance    bool // rewrite prompts with the chat model first
 }
 type Resolution string
 type PicStyle string
@@ -25,6 +30,15 @@ type SessionMeta struct {
 	PicSetting   PicSetting        `json:"pic_setting,omitempty"`
 	AIMode       openai.AIMode     `json:"ai_mode,omitempty"`
 	VisionDetail VisionDetail      `json:"vision_detail,omitempty"`
+	Documents    []Document        `json:"documents,omitempty"`
+	VoiceReply   bool              `json:"voice_reply,omitempty"`
+}
+
+// Document is a file shared in the session, kept apart from the message
+// history so it is not trimmed with it
+type Document struct {
+	Name   string   `json:"name"`
+	Chunks []string `json:"chunks"`
 }
 
 const (
@@ -33,6 +47,8 @@ const (
 	Resolution1024     Resolution = "1024x1024"
 	Resolution10241792 Resolution = "1024x1792"
 	Resolution17921024 Resolution = "1792x1024"
+	Resolution10241536 Resolution = "1024x1536"
+	Resolution15361024 Resolution = "1536x1024"
 )
 const (
 	PicStyleVivid   PicStyle = "vivid"
@@ -41,10 +57,12 @@ const (
 const (
 	VisionDetailHigh VisionDetail = "high"
 	VisionDetailLow  VisionDetail = "low"
+	VisionDetailAuto VisionDetail = "auto"
 )
 const (
 	ModePicCreate SessionMode = "pic_create"
 	ModePicVary   SessionMode = "pic_vary"
+	ModePicEdit   SessionMode = "pic_edit"
 	ModeGPT       SessionMode = "gpt"
 	ModeVision    SessionMode = "vision"
 )
@@ -62,9 +80,26 @@ type SessionServiceCacheInterface interface {
 	GetPicResolution(sessionId string) string
 	SetPicStyle(sessionId string, resolution PicStyle)
 	GetPicStyle(sessionId string) string
+	SetPicModel(sessionId string, model string)
+	GetPicModel(sessionId string) string
+	SetPicQuality(sessionId string, quality string)
+	GetPicQuality(sessionId string) string
+	SetPicCount(sessionId string, count int)
+	GetPicCount(sessionId string) int
+	SetPicEnhance(sessionId string, enhance bool)
+	GetPicEnhance(sessionId string) bool
 	SetVisionDetail(sessionId string, visionDetail VisionDetail)
 	GetVisionDetail(sessionId string) string
+	AddDocument(sessionId string, document Document)
+	GetDocuments(sessionId string) []Document
+	SetVoiceReply(sessionId string, on bool)
+	GetVoiceReply(sessionId string) bool
+	EditTurn(sessionId string, msgId string, content string) bool
+	RemoveTurn(sessionId string, msgId string) bool
+	TurnHistory(sessionId string, msgId string) []openai.Messages
+	SaveAnswer(sessionId string, msg []openai.Messages, answer openai.Messages)
 	Clear(sessionId string)
+	Count() int
 }
 
 var sessionServices *SessionService
@@ -189,6 +224,84 @@ func (s *SessionService) GetPicStyle(sessionId string) string {
 	return string(sessionMeta.PicSetting.style)
 }
 
+// SetPicModel sets the image model of the session, an empty model uses
+// the configured default
+func (s *SessionService) SetPicModel(sessionId string, model string) {
+	s.updatePicSetting(sessionId, func(setting *PicSetting) {
+		setting.model = model
+	})
+}
+
+func (s *SessionService) GetPicModel(sessionId string) string {
+	sessionContext, ok := s.cache.Get(sessionId)
+	if !ok {
+		return ""
+	}
+	return sessionContext.(*SessionMeta).PicSetting.model
+}
+
+func (s *SessionService) SetPicQuality(sessionId string, quality string) {
+	s.updatePicSetting(sessionId, func(setting *PicSetting) {
+		setting.quality = quality
+	})
+}
+
+func (s *SessionService) GetPicQuality(sessionId string) string {
+	sessionContext, ok := s.cache.Get(sessionId)
+	if !ok {
+		return ""
+	}
+	return sessionContext.(*SessionMeta).PicSetting.quality
+}
+
+// SetPicCount sets how many images are generated for each prompt
+func (s *SessionService) SetPicCount(sessionId string, count int) {
+	s.updatePicSetting(sessionId, func(setting *PicSetting) {
+		setting.count = count
+	})
+}
+
+func (s *SessionService) GetPicCount(sessionId string) int {
+	sessionContext, ok := s.cache.Get(sessionId)
+	if !ok {
+		return 1
+	}
+	if count := sessionContext.(*SessionMeta).PicSetting.count; count > 0 {
+		return count
+	}
+	return 1
+}
+
+// SetPicEnhance turns rewriting image prompts with the chat model on or off
+func (s *SessionService) SetPicEnhance(sessionId string, enhance bool) {
+	s.updatePicSetting(sessionId, func(setting *PicSetting) {
+		setting.enhance = enhance
+	})
+}
+
+func (s *SessionService) GetPicEnhance(sessionId string) bool {
+	sessionContext, ok := s.cache.Get(sessionId)
+	if !ok {
+		return false
+	}
+	return sessionContext.(*SessionMeta).PicSetting.enhance
+}
+
+func (s *SessionService) updatePicSetting(sessionId string,
+	update func(setting *PicSetting)) {
+	maxCacheTime := time.Hour * 12
+	sessionContext, ok := s.cache.Get(sessionId)
+	if !ok {
+		sessionMeta := &SessionMeta{}
+		update(&sessionMeta.PicSetting)
+		s.cache.Set(sessionId, sessionMeta, maxCacheTime)
+		return
+	}
+	sessionMeta := sessionContext.(*SessionMeta)
+	update(&sessionMeta.PicSetting)
+	s.cache.Set(sessionId, sessionMeta, maxCacheTime)
+}
+
 func (s *SessionService) SetPicResolution(sessionId string,
 	resolution Resolution) {
 	maxCacheTime := time.Hour * 12
@@ -196,7 +309,8 @@ func (s *SessionService) SetPicResolution(sessionId string,
 	//if not in [Resolution256, Resolution512, Resolution1024] then set
 	//to Resolution256
 	switch resolution {
-	case Resolution256, Resolution512, Resolution1024, Resolution10241792, Resolution17921024:
+	case Resolution256, Resolution512, Resolution1024, Resolution10241792,
+		Resolution17921024, Resolution10241536, Resolution15361024:
 	default:
 		resolution = Resolution1024
 	}
@@ -227,6 +341,83 @@ func (s *SessionService) Clear(sessionId string) {
 	s.cache.Delete(sessionId)
 }
 
+// EditTurn rewrites the user message asked in msgId and drops the answer
+// to the old question
+func (s *SessionService) EditTurn(sessionId string, msgId string,
+	content string) bool {
+	msg := s.GetMsg(sessionId)
+	start, end := findTurn(msg, msgId)
+	if start < 0 {
+		return false
+	}
+	edited := append([]openai.Messages{}, msg[:start]...)
+	question := msg[start]
+	question.Content = content
+	edited = append(edited, question)
+	edited = append(edited, msg[end:]...)
+	s.SetMsg(sessionId, edited)
+	return true
+}
+
+// RemoveTurn drops the user message asked in msgId and the answer to it
+func (s *SessionService) RemoveTurn(sessionId string, msgId string) bool {
+	msg := s.GetMsg(sessionId)
+	start, end := findTurn(msg, msgId)
+	if start < 0 {
+		return false
+	}
+	removed := append([]openai.Messages{}, msg[:start]...)
+	removed = append(removed, msg[end:]...)
+	s.SetMsg(sessionId, removed)
+	return true
+}
+
+// TurnHistory returns the messages of the session up to the user message
+// asked in msgId, nil when the turn is not in the session
+func (s *SessionService) TurnHistory(sessionId string,
+	msgId string) []openai.Messages {
+	msg := s.GetMsg(sessionId)
+	start, _ := findTurn(msg, msgId)
+	if start < 0 {
+		return nil
+	}
+	return append([]openai.Messages{}, msg[:start+1]...)
+}
+
+// SaveAnswer stores answer to the question ending msg. A question already
+// in the session, one being answered again, has its answer replaced and
+// the turns after it kept, otherwise msg and answer become the history
+func (s *SessionService) SaveAnswer(sessionId string, msg []openai.Messages,
+	answer openai.Messages) {
+	if len(msg) > 0 && msg[len(msg)-1].MsgId != "" {
+		stored := s.GetMsg(sessionId)
+		start, end := findTurn(stored, msg[len(msg)-1].MsgId)
+		if start >= 0 {
+			saved := append([]openai.Messages{}, stored[:start+1]...)
+			saved = append(saved, answer)
+			s.SetMsg(sessionId, append(saved, stored[end:]...))
+			return
+		}
+	}
+	s.SetMsg(sessionId, append(msg, answer))
+}
+
+// findTurn locates the user message asked in msgId and the assistant
+// messages answering it
+func findTurn(msg []openai.Messages, msgId string) (int, int) {
+	for i, m := range msg {
+		if m.Role != "user" || m.MsgId != msgId {
+			continue
+		}
+		end := i + 1
+		for end < len(msg) && msg[end].Role == "assistant" {
+			end++
+		}
+		return i, end
+	}
+	return -1, -1
+}
+
 func (s *SessionService) GetVisionDetail(sessionId string) string {
 	sessionContext, ok := s.cache.Get(sessionId)
 	if !ok {
@@ -250,13 +441,75 @@ func (s *SessionService) SetVisionDetail(sessionId string,
 	s.cache.Set(sessionId, sessionMeta, maxCacheTime)
 }
 
+// SetVoiceReply turns reading answers aloud on or off for the session
+func (s *SessionService) SetVoiceReply(sessionId string, on bool) {
+	maxCacheTime := time.Hour * 12
+	sessionContext, ok := s.cache.Get(sessionId)
+	if !ok {
+		sessionMeta := &SessionMeta{VoiceReply: on}
+		s.cache.Set(sessionId, sessionMeta, maxCacheTime)
+		return
+	}
+	sessionMeta := sessionContext.(*SessionMeta)
+	sessionMeta.VoiceReply = on
+	s.cache.Set(sessionId, sessionMeta, maxCacheTime)
+}
+
+func (s *SessionService) GetVoiceReply(sessionId string) bool {
+	sessionContext, ok := s.cache.Get(sessionId)
+	if !ok {
+		return false
+	}
+	return sessionContext.(*SessionMeta).VoiceReply
+}
+
+func (s *SessionService) AddDocument(sessionId string, document Document) {
+	maxCacheTime := time.Hour * 12
+	sessionContext, ok := s.cache.Get(sessionId)
+	if !ok {
+		sessionMeta := &SessionMeta{Documents: []Document{document}}
+		s.cache.Set(sessionId, sessionMeta, maxCacheTime)
+		return
+	}
+	sessionMeta := sessionContext.(*SessionMeta)
+	// a document shared again replaces its older version
+	for i, d := range sessionMeta.Documents {
+		if d.Name == document.Name {
+			sessionMeta.Documents[i] = document
+			s.cache.Set(sessionId, sessionMeta, maxCacheTime)
+			return
+		}
+	}
+	sessionMeta.Documents = append(sessionMeta.Documents, document)
+	s.cache.Set(sessionId, sessionMeta, maxCacheTime)
+}
+
+func (s *SessionService) GetDocuments(sessionId string) []Document {
+	sessionContext, ok := s.cache.Get(sessionId)
+	if !ok {
+		return nil
+	}
+	sessionMeta := sessionContext.(*SessionMeta)
+	return sessionMeta.Documents
+}
+
 func GetSessionCache() SessionServiceCacheInterface {
 	if sessionServices == nil {
 		sessionServices = &SessionService{cache: cache.New(time.Hour*12, time.Hour*1)}
+		metrics.NewGaugeFunc("feishubot_sessions",
+			"Sessions held in the session cache.", func() float64 {
+				return float64(sessionServices.Count())
+			})
 	}
 	return sessionServices
 }
 
+// Count reports how many sessions are cached, expired ones included until
+// the next cleanup
+func (s *SessionService) Count() int {
+	return s.cache.ItemCount()
+}
+
 func getStrPoolTotalLength(strPool []openai.Messages) int {
 	var total int
 	for _, v := range strPool {
@@ -0,0 +1,84 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"start-feishubot/services/openai"
+
+	"github.com/patrickmn/go-cache"
+)
+
+func newTestSessions() *SessionService {
+	return &SessionService{cache: cache.New(time.Hour, time.Hour)}
+}
+
+func question(content, msgId string) openai.Messages {
+	return openai.Messages{Role: "user", Content: content, MsgId: msgId}
+}
+
+func answer(content string) openai.Messages {
+	return openai.Messages{Role: "assistant", Content: content}
+}
+
+func TestRegenerateOlderTurnKeepsLaterTurns(t *testing.T) {
+	s := newTestSessions()
+	system := openai.Messages{Role: "system", Content: "be brief"}
+	s.SetMsg("s", []openai.Messages{system,
+		question("q1", "m1"), answer("a1"),
+		question("q2", "m2"), answer("a2")})
+
+	history := s.TurnHistory("s", "m1")
+	want := []openai.Messages{system, question("q1", "m1")}
+	if !reflect.DeepEqual(history, want) {
+		t.Fatalf("TurnHistory = %v, want %v", history, want)
+	}
+	s.SaveAnswer("s", history, answer("a1 again"))
+
+	got := s.GetMsg("s")
+	want = []openai.Messages{system, question("q1", "m1"),
+		answer("a1 again"), question("q2", "m2"), answer("a2")}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("history after regenerate = %v, want %v", got, want)
+	}
+	if s.TurnHistory("s", "m9") != nil {
+		t.Error("TurnHistory found a turn not in the session")
+	}
+}
+
+func TestSaveAnswerAppendsNewTurn(t *testing.T) {
+	s := newTestSessions()
+	s.SetMsg("s", []openai.Messages{question("q1", "m1"), answer("a1")})
+
+	msg := append(s.GetMsg("s"), question("q2", "m2"))
+	s.SaveAnswer("s", msg, answer("a2"))
+
+	want := []openai.Messages{question("q1", "m1"), answer("a1"),
+		question("q2", "m2"), answer("a2")}
+	if got := s.GetMsg("s"); !reflect.DeepEqual(got, want) {
+		t.Errorf("history = %v, want %v", got, want)
+	}
+}
+
+func TestEditTurnIsAnsweredAgain(t *testing.T) {
+	s := newTestSessions()
+	s.SetMsg("s", []openai.Messages{question("q1", "m1"), answer("a1"),
+		question("q2", "m2"), answer("a2")})
+
+	if !s.EditTurn("s", "m1", "q1 edited") {
+		t.Fatal("EditTurn did not find the turn")
+	}
+	history := s.TurnHistory("s", "m1")
+	want := []openai.Messages{question("q1 edited", "m1")}
+	if !reflect.DeepEqual(history, want) {
+		t.Fatalf("TurnHistory = %v, want %v", history, want)
+	}
+	s.SaveAnswer("s", history, answer("a1 edited"))
+
+	want = []openai.Messages{question("q1 edited", "m1"), answer("a1 edited"),
+		question("q2", "m2"), answer("a2")}
+	if got := s.GetMsg("s"); !reflect.DeepEqual(got, want) {
+		t.Errorf("history after edit = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,184 @@
+// Package webpage fetches the pages linked in messages and extracts their
+// readable text along with the metadata SEO audits look at.
+package webpage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const userAgent = "Mozilla/5.0 (compatible; FeishuBot/1.0; +https://open.feishu.cn)"
+
+var (
+	ErrUnsupportedContent = errors.New("unsupported content type")
+	ErrPrivateAddress     = errors.New("refusing to fetch a private address")
+)
+
+// Fetcher downloads pages with a timeout and a size limit
+type Fetcher struct {
+	client       *http.Client
+	maxBytes     int64
+	allowPrivate bool
+}
+
+// NewFetcher returns a fetcher whose requests take at most timeout and
+// read at most maxBytes of every page. Unless allowPrivate is set it
+// refuses loopback and private network addresses, so users cannot make the
+// bot read internal services
+func NewFetcher(timeout time.Duration, maxBytes int64, proxy string,
+	allowPrivate bool) (*Fetcher, error) {
+	f := &Fetcher{maxBytes: maxBytes, allowPrivate: allowPrivate}
+	dialer := &net.Dialer{Timeout: timeout}
+	transport := &http.Transport{
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   timeout,
+		ResponseHeaderTimeout: timeout,
+	}
+	if proxy != "" {
+		// the proxy may well be local, the targets are checked before
+		// every request instead
+		proxyUrl, err := url.Parse(proxy)
+		if err != nil {
+			return nil, err
+		}
+		transport.Proxy = http.ProxyURL(proxyUrl)
+	} else if !allowPrivate {
+		// checking the address dialed too catches hosts resolving to
+		// another address by the time they are connected to
+		dialer.Control = refusePrivate
+	}
+	f.client = &http.Client{
+		Transport: transport,
+		Timeout:   timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 5 {
+				return errors.New("too many redirects")
+			}
+			return f.checkHost(req.Context(), req.URL)
+		},
+	}
+	return f, nil
+}
+
+// checkHost refuses u when its host is or resolves to a private address
+func (f *Fetcher) checkHost(ctx context.Context, u *url.URL) error {
+	if f.allowPrivate {
+		return nil
+	}
+	host := u.Hostname()
+	if ip := net.ParseIP(host); ip != nil {
+		if isPrivate(ip) {
+			return fmt.Errorf("%w: %s", ErrPrivateAddress, host)
+		}
+		return nil
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return fmt.Errorf("resolve %s failed: %w", host, err)
+	}
+	for _, addr := range addrs {
+		if isPrivate(addr.IP) {
+			return fmt.Errorf("%w: %s is %s", ErrPrivateAddress, host, addr.IP)
+		}
+	}
+	return nil
+}
+
+func refusePrivate(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || isPrivate(ip) {
+		return fmt.Errorf("%w: %s", ErrPrivateAddress, host)
+	}
+	return nil
+}
+
+func isPrivate(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast()
+}
+
+// Fetch downloads rawUrl and extracts the page, pages larger than the
+// limit are cut and marked as truncated
+func (f *Fetcher) Fetch(ctx context.Context, rawUrl string) (*Page, error) {
+	u, err := url.Parse(rawUrl)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("invalid url %q", rawUrl)
+	}
+	if err := f.checkHost(ctx, u); err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml,text/plain;q=0.9")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("fetch %s failed: %s", rawUrl, resp.Status)
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	switch mediaType {
+	case "text/html", "application/xhtml+xml", "text/plain", "":
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedContent, mediaType)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, f.maxBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("read %s failed: %w", rawUrl, err)
+	}
+	truncated := int64(len(body)) > f.maxBytes
+	if truncated {
+		body = body[:f.maxBytes]
+	}
+
+	var page *Page
+	if mediaType == "text/plain" {
+		page = &Page{Text: strings.ToValidUTF8(string(body), "")}
+	} else {
+		page, err = Parse(string(body), resp.Request.URL)
+		if err != nil {
+			return nil, err
+		}
+	}
+	page.URL = rawUrl
+	page.FinalURL = resp.Request.URL.String()
+	page.Truncated = truncated
+	return page, nil
+}
+
+var urlPattern = regexp.MustCompile(`https?://[^\s<>"'()\[\]{}]+`)
+
+// FindURLs returns the distinct http and https addresses in text
+func FindURLs(text string) []string {
+	var urls []string
+	seen := map[string]bool{}
+	for _, match := range urlPattern.FindAllString(text, -1) {
+		match = strings.TrimRight(match, ".,;:!?")
+		if !seen[match] {
+			seen[match] = true
+			urls = append(urls, match)
+		}
+	}
+	return urls
+}
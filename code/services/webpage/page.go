@@ -0,0 +1,241 @@
+package webpage
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Page is the readable content of a web page with its SEO metadata
+type Page struct {
+	URL         string
+	FinalURL    string
+	Title       string
+	Description string
+	Canonical   string
+	Robots      string
+	Hreflang    []Alternate
+	Headings    []Heading
+	Text        string
+	Truncated   bool
+}
+
+// Alternate is a language version of the page declared with hreflang
+type Alternate struct {
+	Lang string
+	URL  string
+}
+
+type Heading struct {
+	Level int
+	Text  string
+}
+
+// elements whose content is not part of the readable text
+var skipped = map[atom.Atom]bool{
+	atom.Head: true, atom.Script: true, atom.Style: true,
+	atom.Noscript: true, atom.Template: true, atom.Svg: true,
+	atom.Iframe: true, atom.Nav: true, atom.Footer: true, atom.Form: true,
+}
+
+// elements that start a new line of text
+var blocks = map[atom.Atom]bool{
+	atom.P: true, atom.Div: true, atom.Br: true, atom.Li: true,
+	atom.Tr: true, atom.H1: true, atom.H2: true, atom.H3: true,
+	atom.H4: true, atom.H5: true, atom.H6: true, atom.Section: true,
+	atom.Article: true, atom.Header: true, atom.Blockquote: true,
+	atom.Pre: true, atom.Table: true, atom.Ul: true, atom.Ol: true,
+	atom.Dt: true, atom.Dd: true, atom.Main: true, atom.Aside: true,
+}
+
+var headingLevels = map[atom.Atom]int{
+	atom.H1: 1, atom.H2: 2, atom.H3: 3, atom.H4: 4, atom.H5: 5, atom.H6: 6,
+}
+
+// Parse extracts the page from its HTML, relative links are resolved
+// against base
+func Parse(document string, base *url.URL) (*Page, error) {
+	root, err := html.Parse(strings.NewReader(document))
+	if err != nil {
+		return nil, fmt.Errorf("parse html failed: %w", err)
+	}
+	page := &Page{}
+	var ogDescription string
+	var readMetadata func(n *html.Node)
+	readMetadata = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			switch n.DataAtom {
+			case atom.Title:
+				if page.Title == "" {
+					page.Title = collapse(textOf(n))
+				}
+			case atom.Meta:
+				name := strings.ToLower(attr(n, "name"))
+				property := strings.ToLower(attr(n, "property"))
+				switch {
+				case name == "description":
+					page.Description = collapse(attr(n, "content"))
+				case name == "robots":
+					page.Robots = attr(n, "content")
+				case property == "og:description":
+					ogDescription = collapse(attr(n, "content"))
+				}
+			case atom.Link:
+				for _, rel := range strings.Fields(strings.ToLower(attr(n, "rel"))) {
+					switch {
+					case rel == "canonical":
+						page.Canonical = resolve(base, attr(n, "href"))
+					case rel == "alternate" && attr(n, "hreflang") != "":
+						page.Hreflang = append(page.Hreflang, Alternate{
+							Lang: attr(n, "hreflang"),
+							URL:  resolve(base, attr(n, "href")),
+						})
+					}
+				}
+			}
+			if level, ok := headingLevels[n.DataAtom]; ok {
+				if heading := collapse(textOf(n)); heading != "" {
+					page.Headings = append(page.Headings, Heading{
+						Level: level, Text: heading,
+					})
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			readMetadata(c)
+		}
+	}
+	readMetadata(root)
+	if page.Description == "" {
+		page.Description = ogDescription
+	}
+
+	var text strings.Builder
+	readText(root, &text)
+	page.Text = cleanText(text.String())
+	return page, nil
+}
+
+func readText(n *html.Node, text *strings.Builder) {
+	switch n.Type {
+	case html.TextNode:
+		text.WriteString(n.Data)
+		return
+	case html.ElementNode:
+		if skipped[n.DataAtom] {
+			return
+		}
+	}
+	block := n.Type == html.ElementNode && blocks[n.DataAtom]
+	if block {
+		text.WriteByte('\n')
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		readText(c, text)
+	}
+	if block {
+		text.WriteByte('\n')
+	}
+}
+
+func textOf(n *html.Node) string {
+	var text strings.Builder
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			text.WriteString(n.Data)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(n)
+	return text.String()
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if strings.EqualFold(a.Key, key) {
+			return strings.TrimSpace(a.Val)
+		}
+	}
+	return ""
+}
+
+func resolve(base *url.URL, href string) string {
+	if base == nil || href == "" {
+		return href
+	}
+	u, err := base.Parse(href)
+	if err != nil {
+		return href
+	}
+	return u.String()
+}
+
+// collapse joins the words of s with single spaces
+func collapse(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// cleanText collapses the spaces within lines and drops empty lines
+func cleanText(s string) string {
+	var lines []string
+	for _, line := range strings.Split(s, "\n") {
+		if line = collapse(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Markdown renders the page for the prompt, with the metadata first and
+// the text cut to maxRunes
+func (p *Page) Markdown(maxRunes int) string {
+	var md strings.Builder
+	fmt.Fprintf(&md, "URL: %s\n", p.URL)
+	if p.FinalURL != "" && p.FinalURL != p.URL {
+		fmt.Fprintf(&md, "Redirected to: %s\n", p.FinalURL)
+	}
+	writeField := func(name, value string) {
+		if value == "" {
+			value = "(missing)"
+		}
+		fmt.Fprintf(&md, "%s: %s\n", name, value)
+	}
+	writeField("Title", p.Title)
+	writeField("Meta description", p.Description)
+	writeField("Canonical", p.Canonical)
+	if p.Robots != "" {
+		writeField("Robots", p.Robots)
+	}
+	if len(p.Hreflang) > 0 {
+		md.WriteString("Hreflang:\n")
+		for _, alternate := range p.Hreflang {
+			fmt.Fprintf(&md, "- %s: %s\n", alternate.Lang, alternate.URL)
+		}
+	}
+	if len(p.Headings) > 0 {
+		md.WriteString("Headings:\n")
+		for _, heading := range p.Headings {
+			fmt.Fprintf(&md, "%s %s\n", strings.Repeat("#", heading.Level),
+				heading.Text)
+		}
+	}
+
+	text := p.Text
+	truncated := p.Truncated
+	if runes := []rune(text); len(runes) > maxRunes {
+		text = string(runes[:maxRunes])
+		truncated = true
+	}
+	md.WriteString("\nContent:\n")
+	md.WriteString(text)
+	if truncated {
+		md.WriteString("\n(content truncated)")
+	}
+	return md.String()
+}
@@ -0,0 +1,174 @@
+package webpage
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+	<title>  SEO Audit
+		Checklist </title>
+	<meta name="description" content="A checklist for technical SEO audits.">
+	<meta name="robots" content="index, follow">
+	<link rel="canonical" href="/guides/seo-audit">
+	<link rel="alternate" hreflang="vi" href="https://example.com/vi/guides/seo-audit">
+	<link rel="alternate" hreflang="x-default" href="/guides/seo-audit">
+	<style>body { color: red }</style>
+</head>
+<body>
+	<nav><a href="/">Home</a></nav>
+	<h1>SEO Audit Checklist</h1>
+	<p>Start with   crawlability.</p>
+	<script>var tracking = true;</script>
+	<h2>Indexing</h2>
+	<ul><li>Check the sitemap</li><li>Check robots.txt</li></ul>
+	<footer>Copyright</footer>
+</body>
+</html>`
+
+func newTestFetcher(t *testing.T, timeout time.Duration, maxBytes int64) *Fetcher {
+	f, err := NewFetcher(timeout, maxBytes, "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestFetch(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/old", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/guides/audit", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("/guides/audit", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(testPage))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	page, err := newTestFetcher(t, time.Second, 1<<20).Fetch(
+		context.Background(), server.URL+"/old")
+	if err != nil {
+		t.Fatalf("Fetch() error = %v", err)
+	}
+	if page.FinalURL != server.URL+"/guides/audit" {
+		t.Errorf("FinalURL = %s", page.FinalURL)
+	}
+	if page.Title != "SEO Audit Checklist" {
+		t.Errorf("Title = %q", page.Title)
+	}
+	if page.Description != "A checklist for technical SEO audits." {
+		t.Errorf("Description = %q", page.Description)
+	}
+	if page.Canonical != server.URL+"/guides/seo-audit" {
+		t.Errorf("Canonical = %q", page.Canonical)
+	}
+	wantHreflang := []Alternate{
+		{Lang: "vi", URL: "https://example.com/vi/guides/seo-audit"},
+		{Lang: "x-default", URL: server.URL + "/guides/seo-audit"},
+	}
+	if !reflect.DeepEqual(page.Hreflang, wantHreflang) {
+		t.Errorf("Hreflang = %+v", page.Hreflang)
+	}
+	wantHeadings := []Heading{{1, "SEO Audit Checklist"}, {2, "Indexing"}}
+	if !reflect.DeepEqual(page.Headings, wantHeadings) {
+		t.Errorf("Headings = %+v", page.Headings)
+	}
+	wantText := "SEO Audit Checklist\nStart with crawlability.\nIndexing\n" +
+		"Check the sitemap\nCheck robots.txt"
+	if page.Text != wantText {
+		t.Errorf("Text = %q, want %q", page.Text, wantText)
+	}
+	if md := page.Markdown(1000); !strings.Contains(md, "Redirected to: ") ||
+		!strings.Contains(md, "- vi: https://example.com/vi/guides/seo-audit") ||
+		!strings.Contains(md, "## Indexing") {
+		t.Errorf("Markdown() = %s", md)
+	}
+}
+
+func TestFetchLimits(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/large", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(strings.Repeat("a", 2048)))
+	})
+	mux.HandleFunc("/image", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("png"))
+	})
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	})
+	mux.HandleFunc("/missing", http.NotFound)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	f := newTestFetcher(t, 100*time.Millisecond, 1024)
+	page, err := f.Fetch(context.Background(), server.URL+"/large")
+	if err != nil || !page.Truncated || len(page.Text) != 1024 {
+		t.Errorf("Fetch() large page = %v, %v, want 1024 truncated bytes", page, err)
+	}
+	if _, err := f.Fetch(context.Background(), server.URL+"/image"); !errors.Is(err, ErrUnsupportedContent) {
+		t.Errorf("Fetch() image error = %v, want ErrUnsupportedContent", err)
+	}
+	if _, err := f.Fetch(context.Background(), server.URL+"/slow"); err == nil {
+		t.Error("Fetch() slow page succeeded, want timeout")
+	}
+	if _, err := f.Fetch(context.Background(), server.URL+"/missing"); err == nil {
+		t.Error("Fetch() missing page succeeded, want error")
+	}
+	if _, err := f.Fetch(context.Background(), "ftp://example.com/file"); err == nil {
+		t.Error("Fetch() ftp url succeeded, want error")
+	}
+
+	private, _ := NewFetcher(time.Second, 1024, "", false)
+	if _, err := private.Fetch(context.Background(), server.URL+"/large"); !errors.Is(err, ErrPrivateAddress) {
+		t.Errorf("Fetch() loopback error = %v, want ErrPrivateAddress", err)
+	}
+}
+
+func TestFetchThroughLocalProxyChecksTargets(t *testing.T) {
+	// the test server plays the proxy, it serves whatever host is asked
+	mux := http.NewServeMux()
+	mux.HandleFunc("/page", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("public page"))
+	})
+	mux.HandleFunc("/redirect", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://127.0.0.1/admin", http.StatusFound)
+	})
+	proxy := httptest.NewServer(mux)
+	defer proxy.Close()
+
+	f, err := NewFetcher(time.Second, 1024, proxy.URL, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, err := f.Fetch(context.Background(), "http://93.184.216.34/page")
+	if err != nil || page.Text != "public page" {
+		t.Errorf("Fetch() through local proxy = %v, %v, want the page", page, err)
+	}
+	if _, err := f.Fetch(context.Background(), proxy.URL+"/page"); !errors.Is(err, ErrPrivateAddress) {
+		t.Errorf("Fetch() loopback target error = %v, want ErrPrivateAddress", err)
+	}
+	if _, err := f.Fetch(context.Background(), "http://93.184.216.34/redirect"); !errors.Is(err, ErrPrivateAddress) {
+		t.Errorf("Fetch() redirect to loopback error = %v, want ErrPrivateAddress", err)
+	}
+}
+
+func TestFindURLs(t *testing.T) {
+	got := FindURLs("Audit https://example.com/a?b=1, and (http://example.org/x). " +
+		"Again https://example.com/a?b=1")
+	want := []string{"https://example.com/a?b=1", "http://example.org/x"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("FindURLs() got = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,135 @@
+// Package tracing sets up OpenTelemetry for the bot. Spans of the work done
+// for each webhook request are batched and exported to a collector over
+// OTLP/HTTP, and the W3C trace context is propagated through the OpenAI and
+// Lark HTTP clients. Until Init is called with an endpoint the global no-op
+// provider is kept and spans record nothing
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName names the tracer the bot's own spans come from
+const instrumentationName = "start-feishubot"
+
+var provider *sdktrace.TracerProvider
+
+func init() {
+	// propagate even while export is off, so upstream traces stay linked
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{}))
+}
+
+// Init starts exporting spans to the collector at endpoint, such as
+// http://localhost:4318. An empty endpoint leaves tracing off
+func Init(endpoint, serviceName string) error {
+	if endpoint == "" {
+		return nil
+	}
+	options, err := exporterOptions(endpoint)
+	if err != nil {
+		return err
+	}
+	exporter, err := otlptracehttp.New(context.Background(), options...)
+	if err != nil {
+		return err
+	}
+	res, err := resource.Merge(resource.Default(),
+		resource.NewSchemaless(semconv.ServiceName(serviceName)))
+	if err != nil {
+		return err
+	}
+	provider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+	return nil
+}
+
+// exporterOptions points the OTLP exporter at the traces path of endpoint,
+// plain http endpoints are sent without TLS
+func exporterOptions(endpoint string) ([]otlptracehttp.Option, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid OTLP endpoint %q", endpoint)
+	}
+	options := []otlptracehttp.Option{
+		otlptracehttp.WithEndpoint(u.Host),
+		otlptracehttp.WithURLPath(strings.TrimSuffix(u.Path, "/") + "/v1/traces"),
+	}
+	if u.Scheme == "http" {
+		options = append(options, otlptracehttp.WithInsecure())
+	}
+	return options, nil
+}
+
+// Shutdown exports the spans still queued and stops the exporter
+func Shutdown(ctx context.Context) error {
+	if provider == nil {
+		return nil
+	}
+	return provider.Shutdown(ctx)
+}
+
+// Start begins a span named name as a child of the span in ctx and returns
+// a context carrying the new span
+func Start(ctx context.Context, name string,
+	options ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name, options...)
+}
+
+// Fail marks span failed with err, nil errors are ignored
+func Fail(span trace.Span, err error) {
+	if err == nil {
+		return
+	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// TraceID is the hex id of the trace the span in ctx belongs to, empty when
+// ctx carries no span
+func TraceID(ctx context.Context) string {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return ""
+	}
+	return sc.TraceID().String()
+}
+
+// Transport wraps base so each request is traced as a client span and
+// carries the trace context of its request context to the server
+func Transport(base http.RoundTripper) http.RoundTripper {
+	return otelhttp.NewTransport(base)
+}
+
+// Detach keeps the span and other values of ctx for work that outlives
+// it, such as replies generated after a card callback has been answered.
+// The returned context is never canceled
+func Detach(ctx context.Context) context.Context {
+	return detached{parent: ctx}
+}
+
+type detached struct {
+	parent context.Context
+}
+
+func (detached) Deadline() (time.Time, bool)         { return time.Time{}, false }
+func (detached) Done() <-chan struct{}               { return nil }
+func (detached) Err() error                          { return nil }
+func (d detached) Value(key interface{}) interface{} { return d.parent.Value(key) }
@@ -0,0 +1,151 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	collectortrace "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// restoreProvider puts the global provider back once the test ends
+func restoreProvider(t *testing.T) {
+	previous := otel.GetTracerProvider()
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previous)
+		provider = nil
+	})
+}
+
+func TestSpansExportedAsOTLP(t *testing.T) {
+	var mu sync.Mutex
+	var received []*collectortrace.ExportTraceServiceRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,
+		r *http.Request) {
+		if r.URL.Path != "/otel/v1/traces" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		req := &collectortrace.ExportTraceServiceRequest{}
+		if err := proto.Unmarshal(body, req); err != nil {
+			t.Error(err)
+		}
+		mu.Lock()
+		received = append(received, req)
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	restoreProvider(t)
+	if err := Init(server.URL+"/otel/", "test-bot"); err != nil {
+		t.Fatal(err)
+	}
+	ctx, parent := Start(context.Background(), "POST /webhook/event",
+		trace.WithSpanKind(trace.SpanKindServer))
+	_, child := Start(ctx, "openai chat/completions",
+		trace.WithAttributes(attribute.Int("http.status_code", 500)))
+	Fail(child, errors.New("upstream failed"))
+	child.End()
+	parent.End()
+	if err := Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	spans := map[string]*tracepb.Span{}
+	for _, req := range received {
+		for _, rs := range req.ResourceSpans {
+			service := ""
+			for _, kv := range rs.Resource.Attributes {
+				if kv.Key == "service.name" {
+					service = kv.Value.GetStringValue()
+				}
+			}
+			if service != "test-bot" {
+				t.Errorf("service.name = %q", service)
+			}
+			for _, ss := range rs.ScopeSpans {
+				for _, span := range ss.Spans {
+					spans[span.Name] = span
+				}
+			}
+		}
+	}
+	exportedParent := spans["POST /webhook/event"]
+	exportedChild := spans["openai chat/completions"]
+	if len(spans) != 2 || exportedParent == nil || exportedChild == nil {
+		t.Fatalf("exported spans = %v", spans)
+	}
+	if string(exportedChild.TraceId) != string(exportedParent.TraceId) ||
+		string(exportedChild.ParentSpanId) != string(exportedParent.SpanId) ||
+		len(exportedParent.ParentSpanId) != 0 {
+		t.Errorf("child %v is not linked to parent %v", exportedChild,
+			exportedParent)
+	}
+	if exportedChild.Status.Code != tracepb.Status_STATUS_CODE_ERROR ||
+		exportedChild.Status.Message != "upstream failed" {
+		t.Errorf("child status = %v", exportedChild.Status)
+	}
+}
+
+func TestInitRejectsInvalidEndpoint(t *testing.T) {
+	if err := Init("localhost:4318", "test-bot"); err == nil {
+		t.Error("Init() accepted an endpoint without a scheme")
+	}
+	if err := Init("", "test-bot"); err != nil || provider != nil {
+		t.Errorf("Init() with no endpoint = %v, want tracing off", err)
+	}
+}
+
+func TestTransportPropagatesTraceContext(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	restoreProvider(t)
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(
+		sdktrace.WithSpanProcessor(recorder)))
+
+	var traceparent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,
+		r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+	}))
+	defer server.Close()
+
+	ctx, span := Start(context.Background(), "lark reply_card")
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+	client := &http.Client{Transport: Transport(http.DefaultTransport)}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	span.End()
+
+	if TraceID(ctx) == "" || len(traceparent) != 55 ||
+		traceparent[3:35] != TraceID(ctx) {
+		t.Errorf("traceparent = %q, want trace %s", traceparent, TraceID(ctx))
+	}
+	ended := recorder.Ended()
+	if len(ended) != 2 || ended[0].SpanKind() != trace.SpanKindClient ||
+		ended[0].Parent().SpanID() != span.SpanContext().SpanID() {
+		t.Errorf("client span not recorded under %s: %v",
+			span.SpanContext().SpanID(), ended)
+	}
+}
+
+func TestTraceIDWithoutSpan(t *testing.T) {
+	if id := TraceID(context.Background()); id != "" {
+		t.Errorf("TraceID() = %q, want empty", id)
+	}
+}
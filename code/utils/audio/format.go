@@ -0,0 +1,68 @@
+package audio
+
+import (
+	"bytes"
+	"errors"
+)
+
+// Format is the container and codec of an audio stream
+type Format string
+
+const (
+	FormatUnknown   Format = "unknown"
+	FormatOggOpus   Format = "ogg/opus"
+	FormatOggVorbis Format = "ogg/vorbis"
+	FormatWav       Format = "wav"
+	FormatMP3       Format = "mp3"
+	FormatM4A       Format = "m4a"
+	FormatWebM      Format = "webm"
+	FormatFLAC      Format = "flac"
+	FormatAMR       Format = "amr"
+)
+
+// ErrUnsupported is returned for audio that cannot be decoded here
+var ErrUnsupported = errors.New("unsupported audio")
+
+// Detect recognizes the format of an audio stream from its first bytes, at
+// least 64 are needed to tell Ogg codecs apart
+func Detect(header []byte) Format {
+	switch {
+	case bytes.HasPrefix(header, []byte("OggS")):
+		return detectOgg(header)
+	case len(header) >= 12 && bytes.HasPrefix(header, []byte("RIFF")) &&
+		bytes.Equal(header[8:12], []byte("WAVE")):
+		return FormatWav
+	case bytes.HasPrefix(header, []byte("ID3")),
+		len(header) >= 2 && header[0] == 0xff && header[1]&0xe0 == 0xe0:
+		return FormatMP3
+	case len(header) >= 8 && bytes.Equal(header[4:8], []byte("ftyp")):
+		return FormatM4A
+	case bytes.HasPrefix(header, []byte{0x1a, 0x45, 0xdf, 0xa3}):
+		return FormatWebM
+	case bytes.HasPrefix(header, []byte("fLaC")):
+		return FormatFLAC
+	case bytes.HasPrefix(header, []byte("#!AMR")):
+		return FormatAMR
+	}
+	return FormatUnknown
+}
+
+// detectOgg looks at the first packet of the first Ogg page, which names
+// the codec
+func detectOgg(header []byte) Format {
+	if len(header) < 27 {
+		return FormatUnknown
+	}
+	payload := 27 + int(header[26])
+	if len(header) < payload {
+		return FormatUnknown
+	}
+	packet := header[payload:]
+	switch {
+	case bytes.HasPrefix(packet, []byte("OpusHead")):
+		return FormatOggOpus
+	case bytes.HasPrefix(packet, []byte("\x01vorbis")):
+		return FormatOggVorbis
+	}
+	return FormatUnknown
+}
@@ -3,6 +3,7 @@ package audio
 import (
 	"bytes"
 	"errors"
+	"fmt"
 	"io"
 	"os"
 
@@ -10,6 +11,10 @@ import (
 	"github.com/pion/opus/pkg/oggreader"
 )
 
+// OpusSampleRate is the rate Opus is decoded at
+const OpusSampleRate = 48000
+
+// OggToWavByPath converts the Ogg Opus file ogg into the WAV file wav
 func OggToWavByPath(ogg string, wav string) error {
 	input, err := os.Open(ogg)
 	if err != nil {
@@ -21,41 +26,159 @@ func OggToWavByPath(ogg string, wav string) error {
 	if err != nil {
 		return err
 	}
-
 	defer output.Close()
 	return OggToWav(input, output)
 }
 
+// OggToWav converts Ogg Opus into a 16kHz mono WAV, the format speech
+// recognition expects
 func OggToWav(input io.Reader, output io.WriteSeeker) error {
-	ogg, _, err := oggreader.NewWith(input)
+	reader, format, err := NewReader(input, TranscriptionSampleRate)
 	if err != nil {
 		return err
 	}
+	if format != FormatOggOpus {
+		return fmt.Errorf("%w: %s is not ogg/opus", ErrUnsupported, format)
+	}
 
-	out := make([]byte, 1920)
-
-	decoder := opus.NewDecoder()
-	encoder := NewEncoder(output, 44100, 16)
-
+	encoder := NewEncoder(output, TranscriptionSampleRate, 16)
+	if err := encoder.WriteHeader(); err != nil {
+		return err
+	}
+	buf := make([]byte, 32*1024)
 	for {
-		segments, _, err := ogg.ParseNextPage()
+		n, err := reader.Read(buf)
+		if n > 0 {
+			if err := encoder.Write(buf[:n]); err != nil {
+				return err
+			}
+		}
 		if errors.Is(err, io.EOF) {
 			break
-		} else if bytes.HasPrefix(segments[0], []byte("OpusTags")) {
-			continue
 		}
-
 		if err != nil {
-			panic(err)
+			return err
+		}
+	}
+	return encoder.Close()
+}
+
+// silkRates are the sample rates of the SILK bandwidths, narrowband,
+// mediumband and wideband
+var silkRates = [3]int{8000, 12000, 16000}
+
+// oggSource decodes the Opus packets of an Ogg stream. pion/opus only
+// decodes single 20ms mono SILK frames, the speech mode Lark records voice
+// messages in, CELT and hybrid frames are reported as unsupported
+type oggSource struct {
+	ogg     *oggreader.OggReader
+	decoder opus.Decoder
+	rate    int
+	packets [][]byte
+	partial []byte // packet continued on the next page
+	out     []byte
+}
+
+func newOggSource(input io.Reader) (*oggSource, error) {
+	ogg, header, err := oggreader.NewWith(input)
+	if err != nil {
+		return nil, err
+	}
+	if header.Channels > 2 {
+		return nil, fmt.Errorf("%w: opus with %d channels", ErrUnsupported,
+			header.Channels)
+	}
+	return &oggSource{
+		ogg:     ogg,
+		decoder: opus.NewDecoder(),
+		out:     make([]byte, 1920),
+	}, nil
+}
+
+func (s *oggSource) sampleRate() int {
+	if s.rate == 0 {
+		// the rate is known once the first packet is read
+		if packet, err := s.peekPacket(); err == nil && len(packet) > 0 {
+			if rate, err := silkRate(packet[0]); err == nil {
+				s.rate = rate
+			}
 		}
+	}
+	if s.rate == 0 {
+		return silkRates[2]
+	}
+	return s.rate
+}
 
-		for i := range segments {
-			if _, _, err = decoder.Decode(segments[i], out); err != nil {
-				panic(err)
+func (s *oggSource) next() ([]int16, error) {
+	packet, err := s.peekPacket()
+	if err != nil {
+		return nil, err
+	}
+	s.packets = s.packets[1:]
+
+	rate, err := silkRate(packet[0])
+	if err != nil {
+		return nil, err
+	}
+	if s.rate == 0 {
+		s.rate = rate
+	} else if rate != s.rate {
+		return nil, fmt.Errorf("%w: opus bandwidth changes from %dHz to %dHz",
+			ErrUnsupported, s.rate, rate)
+	}
+	if _, _, err := s.decoder.Decode(packet, s.out); err != nil {
+		return nil, err
+	}
+	// the decoder repeats every sample three times and only fills the
+	// frame length of the bandwidth
+	samples := readSamples(s.out)
+	frame := make([]int16, rate/50)
+	for i := range frame {
+		frame[i] = samples[3*i]
+	}
+	return frame, nil
+}
+
+// peekPacket returns the next audio packet, joining the lacing segments of
+// packets longer than 255 bytes
+func (s *oggSource) peekPacket() ([]byte, error) {
+	for len(s.packets) == 0 {
+		segments, _, err := s.ogg.ParseNextPage()
+		if err != nil {
+			return nil, err
+		}
+		for _, segment := range segments {
+			s.partial = append(s.partial, segment...)
+			if len(segment) == 255 {
+				continue
+			}
+			if len(s.partial) > 0 && !bytes.HasPrefix(s.partial, []byte("OpusTags")) {
+				s.packets = append(s.packets, s.partial)
 			}
-			encoder.Write(out)
+			s.partial = nil
 		}
 	}
-	encoder.Close()
-	return nil
+	return s.packets[0], nil
+}
+
+// silkRate reads the table of contents byte of an Opus packet, see RFC
+// 6716 section 3.1, and returns the sample rate of its SILK frame
+func silkRate(toc byte) (int, error) {
+	config := toc >> 3
+	switch {
+	case config >= 16:
+		return 0, fmt.Errorf("%w: opus CELT frames", ErrUnsupported)
+	case config >= 12:
+		return 0, fmt.Errorf("%w: opus hybrid frames", ErrUnsupported)
+	case config%4 != 1:
+		return 0, fmt.Errorf("%w: opus SILK frames other than 20ms",
+			ErrUnsupported)
+	case toc&0x04 != 0:
+		return 0, fmt.Errorf("%w: stereo opus", ErrUnsupported)
+	case toc&0x03 != 0:
+		return 0, fmt.Errorf("%w: several opus frames per packet",
+			ErrUnsupported)
+	}
+	return silkRates[config/4], nil
 }
@@ -0,0 +1,42 @@
+package audio
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/pion/opus/pkg/oggreader"
+)
+
+// OggOpusDuration checks that input is an Ogg Opus stream, the format Lark
+// plays audio messages in, and returns its length
+func OggOpusDuration(input io.Reader) (time.Duration, error) {
+	ogg, header, err := oggreader.NewWith(input)
+	if err != nil {
+		return 0, fmt.Errorf("not an ogg opus stream: %w", err)
+	}
+
+	var granule uint64
+	for {
+		_, page, err := ogg.ParseNextPage()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		// header pages and pages without a finished packet carry no
+		// position
+		if page.GranulePosition != ^uint64(0) && page.GranulePosition > granule {
+			granule = page.GranulePosition
+		}
+	}
+	if granule <= uint64(header.PreSkip) {
+		return 0, errors.New("ogg opus stream has no audio")
+	}
+	// granule positions count samples at the decoding rate, whatever the
+	// input sample rate recorded in the header
+	samples := granule - uint64(header.PreSkip)
+	return time.Duration(samples) * time.Second / OpusSampleRate, nil
+}
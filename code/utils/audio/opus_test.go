@@ -0,0 +1,69 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+var oggCRC = func() (table [256]uint32) {
+	for i := range table {
+		r := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if r&0x80000000 != 0 {
+				r = r<<1 ^ 0x04c11db7
+			} else {
+				r <<= 1
+			}
+		}
+		table[i] = r
+	}
+	return table
+}()
+
+// oggPage builds an Ogg page holding a single packet
+func oggPage(headerType byte, granule uint64, index uint32, packet []byte) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("OggS")
+	buf.Write([]byte{0, headerType})
+	binary.Write(&buf, binary.LittleEndian, granule)
+	binary.Write(&buf, binary.LittleEndian, uint32(1))
+	binary.Write(&buf, binary.LittleEndian, index)
+	buf.Write([]byte{0, 0, 0, 0, 1, byte(len(packet))})
+	buf.Write(packet)
+	page := buf.Bytes()
+	var crc uint32
+	for _, b := range page {
+		crc = crc<<8 ^ oggCRC[byte(crc>>24)^b]
+	}
+	binary.LittleEndian.PutUint32(page[22:], crc)
+	return page
+}
+
+func TestOggOpusDuration(t *testing.T) {
+	var head bytes.Buffer
+	head.WriteString("OpusHead")
+	head.Write([]byte{1, 1})
+	binary.Write(&head, binary.LittleEndian, uint16(312))
+	binary.Write(&head, binary.LittleEndian, uint32(16000))
+	head.Write([]byte{0, 0, 0})
+
+	var stream bytes.Buffer
+	stream.Write(oggPage(2, 0, 0, head.Bytes()))
+	stream.Write(oggPage(0, 0, 1, []byte("OpusTags")))
+	stream.Write(oggPage(0, 48000+312, 2, []byte{0xfc}))
+	stream.Write(oggPage(4, 72000+312, 3, []byte{0xfc}))
+
+	duration, err := OggOpusDuration(&stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if duration != 1500*time.Millisecond {
+		t.Errorf("duration = %v, want 1.5s", duration)
+	}
+
+	if _, err := OggOpusDuration(bytes.NewReader([]byte("ID3 mp3"))); err == nil {
+		t.Error("expected an error for a non ogg stream")
+	}
+}
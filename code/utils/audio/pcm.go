@@ -0,0 +1,155 @@
+package audio
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// TranscriptionSampleRate is the rate speech recognition works at, audio
+// is resampled to it before upload to keep requests small
+const TranscriptionSampleRate = 16000
+
+// pcmSource yields 16 bit mono samples at a fixed rate, io.EOF ends the
+// stream
+type pcmSource interface {
+	next() ([]int16, error)
+	sampleRate() int
+}
+
+// NewReader decodes Ogg Opus or WAV audio while it is read, returning 16
+// bit little endian mono PCM at sampleRate. Other formats are reported
+// with ErrUnsupported
+func NewReader(input io.Reader, sampleRate int) (io.Reader, Format, error) {
+	buffered := bufio.NewReaderSize(input, 4096)
+	header, _ := buffered.Peek(64)
+	format := Detect(header)
+
+	var source pcmSource
+	var err error
+	switch format {
+	case FormatOggOpus:
+		source, err = newOggSource(buffered)
+	case FormatWav:
+		source, err = newWavSource(buffered)
+	default:
+		return nil, format, fmt.Errorf("%w: %s", ErrUnsupported, format)
+	}
+	if err != nil {
+		return nil, format, err
+	}
+	return &pcmReader{
+		source:    source,
+		resampler: newResampler(source.sampleRate(), sampleRate),
+	}, format, nil
+}
+
+// Decode reads all of input into 16 bit mono PCM at sampleRate
+func Decode(input io.Reader, sampleRate int) ([]byte, error) {
+	reader, _, err := NewReader(input, sampleRate)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(reader)
+}
+
+type pcmReader struct {
+	source    pcmSource
+	resampler *resampler
+	pending   []byte
+	err       error
+}
+
+func (r *pcmReader) Read(p []byte) (int, error) {
+	for len(r.pending) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		samples, err := r.source.next()
+		if errors.Is(err, io.EOF) {
+			samples = r.resampler.flush()
+			r.err = io.EOF
+		} else if err != nil {
+			r.err = err
+			continue
+		} else {
+			samples = r.resampler.write(samples)
+		}
+		r.pending = appendSamples(r.pending[:0], samples)
+	}
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+func appendSamples(pcm []byte, samples []int16) []byte {
+	for _, sample := range samples {
+		pcm = append(pcm, byte(sample), byte(uint16(sample)>>8))
+	}
+	return pcm
+}
+
+func readSamples(pcm []byte) []int16 {
+	samples := make([]int16, len(pcm)/2)
+	for i := range samples {
+		samples[i] = int16(binary.LittleEndian.Uint16(pcm[2*i:]))
+	}
+	return samples
+}
+
+// resampler converts a stream of samples between rates by linear
+// interpolation
+type resampler struct {
+	from, to int64
+	samples  []int16 // input not consumed yet, starting at index base
+	base     int64
+	next     int64 // index of the next output sample
+}
+
+func newResampler(from, to int) *resampler {
+	return &resampler{from: int64(from), to: int64(to)}
+}
+
+func (r *resampler) write(in []int16) []int16 {
+	if r.from == r.to {
+		return in
+	}
+	r.samples = append(r.samples, in...)
+	var out []int16
+	for {
+		position := r.next * r.from
+		i := position/r.to - r.base
+		if i+1 >= int64(len(r.samples)) {
+			break
+		}
+		a, b := int64(r.samples[i]), int64(r.samples[i+1])
+		out = append(out, int16(a+(b-a)*(position%r.to)/r.to))
+		r.next++
+	}
+	if consumed := r.next*r.from/r.to - r.base; consumed > 0 {
+		if consumed > int64(len(r.samples)) {
+			consumed = int64(len(r.samples))
+		}
+		r.samples = r.samples[consumed:]
+		r.base += consumed
+	}
+	return out
+}
+
+// flush returns the samples held back waiting for a following input
+// sample
+func (r *resampler) flush() []int16 {
+	var out []int16
+	for r.from != r.to {
+		i := r.next*r.from/r.to - r.base
+		if i >= int64(len(r.samples)) {
+			break
+		}
+		out = append(out, r.samples[i])
+		r.next++
+	}
+	r.samples = nil
+	return out
+}
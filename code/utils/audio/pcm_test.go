@@ -0,0 +1,104 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestDetect(t *testing.T) {
+	opusHead := append(oggPage(2, 0, 0, []byte("OpusHead\x01\x01")), 0)
+	vorbisHead := oggPage(2, 0, 0, []byte("\x01vorbis"))
+	tests := []struct {
+		header []byte
+		want   Format
+	}{
+		{opusHead, FormatOggOpus},
+		{vorbisHead, FormatOggVorbis},
+		{EncodeWav(nil, 16000), FormatWav},
+		{[]byte("ID3\x04\x00"), FormatMP3},
+		{[]byte{0xff, 0xfb, 0x90}, FormatMP3},
+		{[]byte("\x00\x00\x00\x20ftypM4A "), FormatM4A},
+		{[]byte{0x1a, 0x45, 0xdf, 0xa3, 0x01}, FormatWebM},
+		{[]byte("fLaC\x00"), FormatFLAC},
+		{[]byte("#!AMR\n"), FormatAMR},
+		{[]byte("hello"), FormatUnknown},
+	}
+	for _, tt := range tests {
+		if got := Detect(tt.header); got != tt.want {
+			t.Errorf("Detect(%q) = %s, want %s", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestResampler(t *testing.T) {
+	down := newResampler(48000, 16000)
+	var got []int16
+	got = append(got, down.write([]int16{0, 1, 2, 3, 4})...)
+	got = append(got, down.write([]int16{5, 6, 7, 8})...)
+	got = append(got, down.flush()...)
+	if want := []int16{0, 3, 6}; !reflect.DeepEqual(got, want) {
+		t.Errorf("downsampled = %v, want %v", got, want)
+	}
+
+	up := newResampler(8000, 16000)
+	got = append(up.write([]int16{0, 100}), up.write([]int16{200})...)
+	got = append(got, up.flush()...)
+	if want := []int16{0, 50, 100, 150, 200, 200}; !reflect.DeepEqual(got, want) {
+		t.Errorf("upsampled = %v, want %v", got, want)
+	}
+}
+
+func TestNewReaderWav(t *testing.T) {
+	// 8kHz stereo, with a LIST chunk before the samples
+	var wav bytes.Buffer
+	wav.WriteString("RIFF\x00\x00\x00\x00WAVEfmt ")
+	binary.Write(&wav, binary.LittleEndian, []uint32{16})
+	binary.Write(&wav, binary.LittleEndian, []uint16{1, 2})
+	binary.Write(&wav, binary.LittleEndian, []uint32{8000, 32000})
+	binary.Write(&wav, binary.LittleEndian, []uint16{4, 16})
+	wav.WriteString("LIST\x03\x00\x00\x00abc\x00data")
+	binary.Write(&wav, binary.LittleEndian, []uint32{8})
+	binary.Write(&wav, binary.LittleEndian, []int16{100, 300, 400, 400})
+
+	reader, format, err := NewReader(&wav, 16000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != FormatWav {
+		t.Errorf("format = %s", format)
+	}
+	pcm, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := readSamples(pcm), []int16{200, 300, 400, 400}; !reflect.DeepEqual(got, want) {
+		t.Errorf("samples = %v, want %v", got, want)
+	}
+}
+
+func TestDecodeUnsupported(t *testing.T) {
+	var head bytes.Buffer
+	head.WriteString("OpusHead")
+	head.Write([]byte{1, 1})
+	binary.Write(&head, binary.LittleEndian, uint16(312))
+	binary.Write(&head, binary.LittleEndian, uint32(48000))
+	head.Write([]byte{0, 0, 0})
+
+	var celt bytes.Buffer
+	celt.Write(oggPage(2, 0, 0, head.Bytes()))
+	celt.Write(oggPage(0, 0, 1, []byte("OpusTags")))
+	celt.Write(oggPage(4, 960, 2, []byte{0xfc, 0x00}))
+
+	for name, input := range map[string][]byte{
+		"celt": celt.Bytes(),
+		"mp3":  []byte("ID3\x04\x00\x00\x00\x00\x00\x00"),
+	} {
+		if _, err := Decode(bytes.NewReader(input), 16000); !errors.Is(err, ErrUnsupported) {
+			t.Errorf("%s: err = %v, want ErrUnsupported", name, err)
+		}
+	}
+}
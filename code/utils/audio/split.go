@@ -0,0 +1,68 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// EncodeWav wraps 16 bit mono PCM in a WAV container
+func EncodeWav(pcm []byte, sampleRate int) []byte {
+	var wav bytes.Buffer
+	wav.Grow(44 + len(pcm))
+	wav.WriteString("RIFF")
+	binary.Write(&wav, binary.LittleEndian, uint32(36+len(pcm)))
+	wav.WriteString("WAVEfmt ")
+	binary.Write(&wav, binary.LittleEndian, uint32(16))
+	binary.Write(&wav, binary.LittleEndian, uint16(1)) // PCM
+	binary.Write(&wav, binary.LittleEndian, uint16(1)) // mono
+	binary.Write(&wav, binary.LittleEndian, uint32(sampleRate))
+	binary.Write(&wav, binary.LittleEndian, uint32(sampleRate*2))
+	binary.Write(&wav, binary.LittleEndian, uint16(2))
+	binary.Write(&wav, binary.LittleEndian, uint16(16))
+	wav.WriteString("data")
+	binary.Write(&wav, binary.LittleEndian, uint32(len(pcm)))
+	wav.Write(pcm)
+	return wav.Bytes()
+}
+
+// SplitPCM cuts 16 bit mono PCM into segments of at most maxBytes. Each cut
+// is moved to the quietest 100ms of the last tenth of the segment, so
+// words are rarely split in half
+func SplitPCM(pcm []byte, sampleRate int, maxBytes int) [][]byte {
+	maxBytes -= maxBytes % 2
+	window := sampleRate / 10 * 2
+	var segments [][]byte
+	for len(pcm) > maxBytes {
+		cut := maxBytes
+		if searchFrom := maxBytes - maxBytes/10; window > 0 && searchFrom+window <= maxBytes {
+			best := -1.0
+			for start := searchFrom; start+window <= maxBytes; start += window {
+				if e := energy(pcm[start : start+window]); best < 0 || e < best {
+					best = e
+					cut = start + window/2
+				}
+			}
+			cut -= cut % 2
+		}
+		segments = append(segments, pcm[:cut])
+		pcm = pcm[cut:]
+	}
+	if len(pcm) > 0 {
+		segments = append(segments, pcm)
+	}
+	return segments
+}
+
+// Duration returns the length in seconds of 16 bit mono PCM
+func Duration(pcm []byte, sampleRate int) float64 {
+	return float64(len(pcm)/2) / float64(sampleRate)
+}
+
+func energy(pcm []byte) float64 {
+	var sum float64
+	for i := 0; i+1 < len(pcm); i += 2 {
+		sample := float64(int16(binary.LittleEndian.Uint16(pcm[i:])))
+		sum += sample * sample
+	}
+	return sum
+}
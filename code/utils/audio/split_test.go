@@ -0,0 +1,46 @@
+package audio
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestEncodeWav(t *testing.T) {
+	wav := EncodeWav(make([]byte, 100), 16000)
+	if len(wav) != 144 || string(wav[:4]) != "RIFF" ||
+		string(wav[8:12]) != "WAVE" {
+		t.Fatalf("bad header % x", wav[:12])
+	}
+	if rate := binary.LittleEndian.Uint32(wav[24:]); rate != 16000 {
+		t.Errorf("sample rate = %d", rate)
+	}
+	if size := binary.LittleEndian.Uint32(wav[40:]); size != 100 {
+		t.Errorf("data size = %d", size)
+	}
+}
+
+func TestSplitPCMCutsAtSilence(t *testing.T) {
+	const rate = 1000 // 200 bytes per 100ms window
+	pcm := make([]byte, 10000)
+	for i := 0; i < len(pcm); i += 2 {
+		binary.LittleEndian.PutUint16(pcm[i:], 1000)
+	}
+	// silence at 2.35s, inside the last tenth of the first segment
+	for i := 4700; i < 4900; i += 2 {
+		binary.LittleEndian.PutUint16(pcm[i:], 0)
+	}
+	segments := SplitPCM(pcm, rate, 5000)
+	total := 0
+	for _, segment := range segments {
+		if len(segment) > 5000 || len(segment)%2 != 0 {
+			t.Errorf("segment of %d bytes", len(segment))
+		}
+		total += len(segment)
+	}
+	if total != len(pcm) {
+		t.Errorf("segments hold %d bytes, want %d", total, len(pcm))
+	}
+	if len(segments[0]) != 4800 {
+		t.Errorf("first cut at %d, want 4800", len(segments[0]))
+	}
+}
@@ -2,6 +2,8 @@ package audio
 
 import (
 	"encoding/binary"
+	"errors"
+	"fmt"
 	"io"
 )
 
@@ -71,7 +73,9 @@ func writeLe[T []byte | uint32 | uint16 | uint8](w io.Writer, data T) error {
 
 func (e *Encoder) Write(data []byte) error {
 	if !e.isHeaderWritten {
-		e.WriteHeader()
+		if err := e.WriteHeader(); err != nil {
+			return err
+		}
 	}
 	n, err := e.Output.Write(data)
 	if err != nil {
@@ -105,3 +109,92 @@ func NewEncoder(w io.WriteSeeker, sampleRate int, bitDepth int) *Encoder {
 		isHeaderWritten: false,
 	}
 }
+
+// wavSource reads the samples of a 16 bit PCM WAV, mixing its channels
+// down to mono
+type wavSource struct {
+	input     io.Reader
+	remaining int64 // bytes left in the data chunk
+	channels  int
+	rate      int
+	buf       []byte
+}
+
+func newWavSource(input io.Reader) (*wavSource, error) {
+	var riff [12]byte
+	if _, err := io.ReadFull(input, riff[:]); err != nil {
+		return nil, err
+	}
+	s := &wavSource{input: input}
+	for {
+		var chunk [8]byte
+		if _, err := io.ReadFull(input, chunk[:]); err != nil {
+			return nil, fmt.Errorf("wav has no data chunk: %w", err)
+		}
+		size := int64(binary.LittleEndian.Uint32(chunk[4:]))
+		switch string(chunk[:4]) {
+		case "fmt ":
+			if size < 16 {
+				return nil, errors.New("wav fmt chunk too short")
+			}
+			format := make([]byte, size+size%2)
+			if _, err := io.ReadFull(input, format); err != nil {
+				return nil, err
+			}
+			codec := binary.LittleEndian.Uint16(format)
+			bits := binary.LittleEndian.Uint16(format[14:])
+			// 0xfffe is WAVE_FORMAT_EXTENSIBLE, PCM in practice
+			if (codec != 1 && codec != 0xfffe) || bits != 16 {
+				return nil, fmt.Errorf("%w: wav codec %#x with %d bit samples",
+					ErrUnsupported, codec, bits)
+			}
+			s.channels = int(binary.LittleEndian.Uint16(format[2:]))
+			s.rate = int(binary.LittleEndian.Uint32(format[4:]))
+		case "data":
+			if s.channels == 0 || s.rate == 0 {
+				return nil, errors.New("wav data before its fmt chunk")
+			}
+			s.remaining = size
+			s.buf = make([]byte, 4096*2*s.channels)
+			return s, nil
+		default:
+			if _, err := io.CopyN(io.Discard, input, size+size%2); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+func (s *wavSource) sampleRate() int {
+	return s.rate
+}
+
+func (s *wavSource) next() ([]int16, error) {
+	if s.remaining <= 0 {
+		return nil, io.EOF
+	}
+	buf := s.buf
+	if int64(len(buf)) > s.remaining {
+		buf = buf[:s.remaining]
+	}
+	n, err := io.ReadFull(s.input, buf)
+	s.remaining -= int64(n)
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		// the data size of streamed WAVs is often left unset
+		s.remaining, err = 0, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	frame := 2 * s.channels
+	samples := readSamples(buf[:n-n%frame])
+	mono := make([]int16, len(samples)/s.channels)
+	for i := range mono {
+		var sum int
+		for c := 0; c < s.channels; c++ {
+			sum += int(samples[i*s.channels+c])
+		}
+		mono[i] = int16(sum / s.channels)
+	}
+	return mono, nil
+}
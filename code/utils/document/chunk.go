@@ -0,0 +1,128 @@
+package document
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Chunk splits text into pieces of at most size bytes, cutting at
+// paragraph and line breaks where possible
+func Chunk(text string, size int) []string {
+	var chunks []string
+	var current strings.Builder
+	flush := func() {
+		if chunk := strings.TrimSpace(current.String()); chunk != "" {
+			chunks = append(chunks, chunk)
+		}
+		current.Reset()
+	}
+	for _, line := range strings.SplitAfter(text, "\n") {
+		for len(line) > size {
+			flush()
+			cut := cutIndex(line, size)
+			current.WriteString(line[:cut])
+			flush()
+			line = line[cut:]
+		}
+		if current.Len()+len(line) > size {
+			flush()
+		}
+		current.WriteString(line)
+	}
+	flush()
+	return chunks
+}
+
+// cutIndex prefers the last space before limit and never splits a rune
+func cutIndex(text string, limit int) int {
+	if i := strings.LastIndexByte(text[:limit], ' '); i > limit/2 {
+		return i + 1
+	}
+	cut := limit
+	for cut > 0 && !utf8.RuneStart(text[cut]) {
+		cut--
+	}
+	return cut
+}
+
+// Rank orders the chunks matching query by relevance, weighing each query
+// term by how rare it is among the chunks. Chunks sharing no term with
+// the query are left out
+func Rank(query string, chunks []string) []int {
+	terms := termSet(query)
+	if len(terms) == 0 {
+		return nil
+	}
+
+	counts := make([]map[string]int, len(chunks))
+	frequency := map[string]int{}
+	for i, chunk := range chunks {
+		counts[i] = map[string]int{}
+		chunk = strings.ToLower(chunk)
+		for _, term := range terms {
+			if n := strings.Count(chunk, term); n > 0 {
+				counts[i][term] = n
+				frequency[term]++
+			}
+		}
+	}
+
+	scores := make([]float64, len(chunks))
+	var ranked []int
+	for i := range chunks {
+		for term, n := range counts[i] {
+			idf := math.Log(1 + float64(len(chunks))/float64(frequency[term]))
+			scores[i] += (1 + math.Log(float64(n))) * idf
+		}
+		if scores[i] > 0 {
+			ranked = append(ranked, i)
+		}
+	}
+	sort.SliceStable(ranked, func(a, b int) bool {
+		return scores[ranked[a]] > scores[ranked[b]]
+	})
+	return ranked
+}
+
+// termSet returns the distinct lower case words of text, words of scripts
+// written without spaces are split into characters
+func termSet(text string) []string {
+	seen := map[string]bool{}
+	var terms []string
+	add := func(term string) {
+		if term != "" && !seen[term] && !stopWords[term] {
+			seen[term] = true
+			terms = append(terms, term)
+		}
+	}
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	for _, word := range words {
+		if unicode.In([]rune(word)[0], unicode.Han, unicode.Hiragana,
+			unicode.Katakana, unicode.Hangul) {
+			for _, r := range word {
+				add(string(r))
+			}
+			continue
+		}
+		if utf8.RuneCountInString(word) > 1 {
+			add(word)
+		}
+	}
+	return terms
+}
+
+var stopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true,
+	"be": true, "by": true, "can": true, "do": true, "does": true,
+	"for": true, "from": true, "how": true, "in": true, "is": true,
+	"it": true, "of": true, "on": true, "or": true, "that": true,
+	"the": true, "this": true, "to": true, "was": true, "what": true,
+	"when": true, "where": true, "which": true, "who": true, "why": true,
+	"with": true, "you": true, "me": true, "about": true, "document": true,
+	"file": true,
+}
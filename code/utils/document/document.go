@@ -0,0 +1,135 @@
+// Package document extracts plain text from the files users send to the
+// bot and splits it into chunks that fit into a prompt.
+package document
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+type Kind string
+
+const (
+	KindPDF      Kind = "PDF"
+	KindDOCX     Kind = "DOCX"
+	KindMarkdown Kind = "Markdown"
+	KindText     Kind = "Text"
+	KindCSV      Kind = "CSV"
+)
+
+var (
+	ErrUnsupported = errors.New("unsupported file type")
+	ErrNoText      = errors.New("no extractable text")
+	ErrEncrypted   = errors.New("encrypted documents are not supported")
+	ErrMalformed   = errors.New("malformed document")
+)
+
+// KindOf returns the kind of document by file extension
+func KindOf(name string) (Kind, error) {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".pdf":
+		return KindPDF, nil
+	case ".docx":
+		return KindDOCX, nil
+	case ".md", ".markdown":
+		return KindMarkdown, nil
+	case ".txt", ".text", ".log":
+		return KindText, nil
+	case ".csv":
+		return KindCSV, nil
+	}
+	return "", fmt.Errorf("%w: %s", ErrUnsupported, filepath.Ext(name))
+}
+
+// Extract returns the text of the document named name. The parsers read
+// untrusted files, a bug they hit on a malformed one is returned as
+// ErrMalformed rather than crashing the caller
+func Extract(name string, data []byte) (_ string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%w: %s: %v", ErrMalformed, name, r)
+		}
+	}()
+	kind, err := KindOf(name)
+	if err != nil {
+		return "", err
+	}
+	var text string
+	switch kind {
+	case KindPDF:
+		text, err = extractPDF(data)
+	case KindDOCX:
+		text, err = extractDOCX(data)
+	case KindCSV:
+		text, err = extractCSV(data)
+	default:
+		text = decodeText(data)
+	}
+	if err != nil {
+		return "", err
+	}
+	text = normalize(text)
+	if text == "" {
+		return "", ErrNoText
+	}
+	return text, nil
+}
+
+// decodeText drops the byte order mark and invalid UTF-8
+func decodeText(data []byte) string {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	if utf8.Valid(data) {
+		return string(data)
+	}
+	return strings.ToValidUTF8(string(data), "")
+}
+
+// extractCSV renders every record on its own line with the fields
+// separated by " | "
+func extractCSV(data []byte) (string, error) {
+	reader := csv.NewReader(strings.NewReader(decodeText(data)))
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+
+	var text strings.Builder
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("read csv failed: %w", err)
+		}
+		text.WriteString(strings.Join(record, " | "))
+		text.WriteByte('\n')
+	}
+	return text.String(), nil
+}
+
+// normalize trims trailing spaces and collapses runs of blank lines
+func normalize(text string) string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	lines := strings.Split(text, "\n")
+	var out []string
+	blank := 0
+	for _, line := range lines {
+		line = strings.TrimRight(line, " \t\r")
+		if strings.TrimSpace(line) == "" {
+			blank++
+			if blank > 1 {
+				continue
+			}
+			line = ""
+		} else {
+			blank = 0
+		}
+		out = append(out, line)
+	}
+	return strings.TrimSpace(strings.Join(out, "\n"))
+}
@@ -0,0 +1,270 @@
+package document
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestExtract(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		data []byte
+		want string
+	}{
+		{
+			name: "Markdown with BOM",
+			file: "notes.md",
+			data: []byte("\xef\xbb\xbf# Title\r\n\r\n\r\n\r\nBody  \n"),
+			want: "# Title\n\nBody",
+		},
+		{
+			name: "CSV",
+			file: "keywords.CSV",
+			data: []byte("keyword,volume\n\"seo, audit\",1200\n"),
+			want: "keyword | volume\nseo, audit | 1200",
+		},
+		{
+			name: "DOCX",
+			file: "playbook.docx",
+			data: createTestDocx(t, `<w:document xmlns:w="w"><w:body>`+
+				`<w:p><w:r><w:t>Hello</w:t></w:r><w:r><w:t xml:space="preserve"> world</w:t></w:r></w:p>`+
+				`<w:p><w:r><w:t>Second</w:t><w:tab/><w:t>line</w:t></w:r></w:p>`+
+				`</w:body></w:document>`),
+			want: "Hello world\nSecond\tline",
+		},
+		{
+			name: "PDF",
+			file: "report.pdf",
+			data: createTestPdf(
+				"BT /F1 12 Tf 72 712 Td (Hello \\(PDF\\)) Tj 0 -14 Td [(Wor) -10 (ld) -300 (again)] TJ ET",
+				false),
+			want: "Hello (PDF)\nWorld again",
+		},
+		{
+			name: "Flate PDF",
+			file: "report.pdf",
+			data: createTestPdf("BT 72 712 Td <0048006900210021> Tj ET", true),
+			want: "Hi!!",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Extract(tt.file, tt.data)
+			if err != nil {
+				t.Fatalf("Extract() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Extract() got = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractErrors(t *testing.T) {
+	if _, err := Extract("slides.pptx", nil); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("Extract() pptx error = %v, want ErrUnsupported", err)
+	}
+	if _, err := Extract("empty.txt", []byte(" \n\n")); !errors.Is(err, ErrNoText) {
+		t.Errorf("Extract() empty error = %v, want ErrNoText", err)
+	}
+	scanned := createTestPdf("q 612 0 0 792 0 0 cm /Im1 Do Q", false)
+	if _, err := Extract("scan.pdf", scanned); !errors.Is(err, ErrNoText) {
+		t.Errorf("Extract() scanned pdf error = %v, want ErrNoText", err)
+	}
+}
+
+func TestExtractPDFFonts(t *testing.T) {
+	cmap := "/CIDInit /ProcSet findresource begin 12 dict begin begincmap\n" +
+		"1 begincodespacerange <0000> <FFFF> endcodespacerange\n" +
+		"4 beginbfchar <0001> <0056> <0002> <0069> <0003> <1EC7> <0004> <0074> endbfchar\n" +
+		"1 beginbfrange <0005> <0007> <0061> endbfrange\n" +
+		"endcmap CMapName currentdict /CMap defineresource pop end end"
+	content := "BT /F1 12 Tf 72 712 Td <0001000200030004> Tj 0 -14 Td " +
+		"[<0005> -300 <00060007>] TJ ET"
+	font := "<< /Type /Font /Subtype /Type0 /BaseFont /ABCDEF+Arial " +
+		"/Encoding /Identity-H /DescendantFonts [8 0 R] /ToUnicode 6 0 R >>"
+
+	for _, packed := range []bool{false, true} {
+		data := createTestPdfWithFont(content, font, cmap, packed)
+		got, err := Extract("vi.pdf", data)
+		if err != nil || got != "Việt\na bc" {
+			t.Errorf("Extract() packed=%v = %q, %v, want the mapped text",
+				packed, got, err)
+		}
+	}
+
+	unmapped := strings.Replace(font, " /ToUnicode 6 0 R", "", 1)
+	data := createTestPdfWithFont(content, unmapped, cmap, false)
+	if _, err := Extract("glyphs.pdf", data); !errors.Is(err, ErrNoText) {
+		t.Errorf("Extract() glyph ids without map error = %v, want ErrNoText", err)
+	}
+}
+
+func TestExtractPDFEncryption(t *testing.T) {
+	simple := "<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica >>"
+	data := createTestPdfWithFont("BT /F1 12 Tf (see /Encrypt in the spec) Tj ET",
+		simple, "", false)
+	if got, err := Extract("spec.pdf", data); err != nil || got != "see /Encrypt in the spec" {
+		t.Errorf("Extract() = %q, %v, want the text", got, err)
+	}
+
+	encrypted := bytes.Replace(data, []byte("<< /Root 1 0 R"),
+		[]byte("<< /Root 1 0 R /Encrypt 9 0 R"), 1)
+	if _, err := Extract("locked.pdf", encrypted); !errors.Is(err, ErrEncrypted) {
+		t.Errorf("Extract() encrypted error = %v, want ErrEncrypted", err)
+	}
+}
+
+// malformedCMaps are truncated or broken ToUnicode maps that once made
+// the parser slice out of range
+var malformedCMaps = []string{
+	"1 beginbfrange <0001> <0002> [<0041> >] endbfrange",
+	"1 beginbfrange <0001> <0002> [> <0041>] endbfrange",
+	"1 beginbfrange <0001> <0002> [",
+	"1 beginbfchar <0001> <00",
+	"1 begincodespacerange <",
+	"1 beginbfchar <0001> >",
+}
+
+func TestExtractPDFMalformedCMaps(t *testing.T) {
+	font := "<< /Type /Font /Subtype /Type0 /Encoding /Identity-H " +
+		"/ToUnicode 6 0 R >>"
+	for _, cmap := range malformedCMaps {
+		data := createTestPdfWithFont("BT /F1 12 Tf <0001> Tj ET", font,
+			cmap, false)
+		if _, err := Extract("broken.pdf", data); err != nil &&
+			!errors.Is(err, ErrNoText) {
+			t.Errorf("Extract() with cmap %q error = %v", cmap, err)
+		}
+	}
+	for _, hex := range []string{"", "<", ">", "0041>", "<00", "<>"} {
+		readHexString([]byte(hex))
+	}
+}
+
+func FuzzParseCMap(f *testing.F) {
+	for _, cmap := range malformedCMaps {
+		f.Add([]byte(cmap))
+	}
+	f.Add([]byte("1 begincodespacerange <0000> <FFFF> endcodespacerange " +
+		"1 beginbfrange <0005> <0007> [<0061> <0062> <0063>] endbfrange"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		m := parseCMap(data)
+		(&pdfFont{wide: true, cmap: m}).decode(data)
+		parseContentStream(data, nil)
+	})
+}
+
+func TestChunk(t *testing.T) {
+	text := "first paragraph\n\nsecond paragraph\n" + strings.Repeat("word ", 10)
+	chunks := Chunk(text, 20)
+	for _, chunk := range chunks {
+		if len(chunk) > 20 {
+			t.Errorf("Chunk() chunk %q longer than 20 bytes", chunk)
+		}
+	}
+	if chunks[0] != "first paragraph" || chunks[1] != "second paragraph" {
+		t.Errorf("Chunk() did not cut at line breaks: %q", chunks)
+	}
+	joined := strings.Join(strings.Fields(strings.Join(chunks, " ")), " ")
+	if want := strings.Join(strings.Fields(text), " "); joined != want {
+		t.Errorf("Chunk() lost text: %q", joined)
+	}
+}
+
+func TestRank(t *testing.T) {
+	chunks := []string{
+		"Our pricing starts at 10 dollars per month.",
+		"Backlinks from relevant sites improve rankings. Backlinks matter.",
+		"Internal links help crawlers discover pages.",
+	}
+	got := Rank("How do backlinks help rankings?", chunks)
+	if len(got) != 2 || got[0] != 1 || got[1] != 2 {
+		t.Errorf("Rank() got = %v, want [1 2]", got)
+	}
+	if got := Rank("what is this", chunks); got != nil {
+		t.Errorf("Rank() of stop words got = %v, want nil", got)
+	}
+}
+
+func createTestDocx(t *testing.T, document string) []byte {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	f, err := w.Create("word/document.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte(document))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func createTestPdf(content string, compress bool) []byte {
+	stream := []byte(content)
+	filter := ""
+	if compress {
+		var buf bytes.Buffer
+		w := zlib.NewWriter(&buf)
+		w.Write(stream)
+		w.Close()
+		stream = buf.Bytes()
+		filter = " /Filter /FlateDecode"
+	}
+	var pdf bytes.Buffer
+	pdf.WriteString("%PDF-1.4\n1 0 obj\n<< /Type /Catalog /Pages 2 0 R >>\nendobj\n")
+	fmt.Fprintf(&pdf, "4 0 obj\n<< /Length %d%s >>\nstream\n", len(stream), filter)
+	pdf.Write(stream)
+	pdf.WriteString("\nendstream\nendobj\ntrailer\n<< /Root 1 0 R >>\n%%EOF\n")
+	return pdf.Bytes()
+}
+
+// createTestPdfWithFont builds a one page PDF whose font F1 is inherited
+// from the page tree, packed puts the font in an object stream
+func createTestPdfWithFont(content, font, cmap string, packed bool) []byte {
+	var pdf bytes.Buffer
+	pdf.WriteString("%PDF-1.5\n1 0 obj\n<< /Type /Catalog /Pages 2 0 R >>\nendobj\n")
+	pdf.WriteString("2 0 obj\n<< /Type /Pages /Kids [3 0 R] /Count 1 " +
+		"/Resources << /Font << /F1 5 0 R >> >> >>\nendobj\n")
+	pdf.WriteString("3 0 obj\n<< /Type /Page /Parent 2 0 R /Contents [4 0 R] >>\nendobj\n")
+	fmt.Fprintf(&pdf, "4 0 obj\n<< /Length %d >>\nstream\n%s\nendstream\nendobj\n",
+		len(content), content)
+	if packed {
+		header := "5 0 "
+		objects := header + font
+		var buf bytes.Buffer
+		w := zlib.NewWriter(&buf)
+		w.Write([]byte(objects))
+		w.Close()
+		fmt.Fprintf(&pdf, "7 0 obj\n<< /Type /ObjStm /N 1 /First %d /Length %d "+
+			"/Filter /FlateDecode >>\nstream\n", len(header), buf.Len())
+		pdf.Write(buf.Bytes())
+		pdf.WriteString("\nendstream\nendobj\n")
+	} else {
+		fmt.Fprintf(&pdf, "5 0 obj\n%s\nendobj\n", font)
+	}
+	fmt.Fprintf(&pdf, "6 0 obj\n<< /Length %d >>\nstream\n%s\nendstream\nendobj\n",
+		len(cmap), cmap)
+	pdf.WriteString("trailer\n<< /Root 1 0 R >>\n%%EOF\n")
+	return pdf.Bytes()
+}
+
+func FuzzExtractPDF(f *testing.F) {
+	font := "<< /Type /Font /Subtype /Type0 /Encoding /Identity-H " +
+		"/ToUnicode 6 0 R >>"
+	for _, cmap := range malformedCMaps {
+		f.Add(createTestPdfWithFont("BT /F1 12 Tf <0001> Tj ET", font, cmap,
+			true))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		// extractPDF rather than Extract, which would hide the panics
+		extractPDF(data)
+	})
+}
@@ -0,0 +1,395 @@
+package document
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// maxStreamBytes bounds the inflated size of a single PDF stream
+const maxStreamBytes = 16 << 20
+
+// extractPDF reads the text shown by the content streams of a PDF, page by
+// page. It understands uncompressed and Flate streams, object streams and
+// fonts mapped to Unicode by a ToUnicode CMap. Scanned documents and text
+// in two byte fonts without a map yield ErrNoText
+func extractPDF(data []byte) (string, error) {
+	if !bytes.HasPrefix(bytes.TrimLeft(data, " \t\r\n"), []byte("%PDF-")) {
+		return "", ErrUnsupported
+	}
+	doc := parsePDF(data)
+	if doc.encrypted() {
+		return "", ErrEncrypted
+	}
+
+	var text strings.Builder
+	pages := doc.pages()
+	for _, page := range pages {
+		text.WriteString(parseContentStream(page.content, page.fonts))
+	}
+	if len(pages) > 0 {
+		return text.String(), nil
+	}
+	// the page tree could not be read, every content stream is read with
+	// simple fonts instead
+	for _, stream := range pdfStreams(data) {
+		if !bytes.Contains(stream, []byte("BT")) {
+			continue
+		}
+		text.WriteString(parseContentStream(stream, nil))
+	}
+	return text.String(), nil
+}
+
+// pdfStreams returns the decoded streams of data, skipping images and
+// filters other than Flate
+func pdfStreams(data []byte) [][]byte {
+	var streams [][]byte
+	offset := 0
+	for {
+		i := bytes.Index(data[offset:], []byte("stream"))
+		if i < 0 {
+			return streams
+		}
+		keyword := offset + i
+		offset = keyword + len("stream")
+		// skip "endstream"
+		if keyword >= 3 && string(data[keyword-3:keyword]) == "end" {
+			continue
+		}
+		start := offset
+		if start < len(data) && data[start] == '\r' {
+			start++
+		}
+		if start < len(data) && data[start] == '\n' {
+			start++
+		}
+		end := bytes.Index(data[start:], []byte("endstream"))
+		if end < 0 {
+			return streams
+		}
+		end += start
+		offset = end + len("endstream")
+
+		dict := data[:keyword]
+		if obj := bytes.LastIndex(dict, []byte("obj")); obj >= 0 {
+			dict = dict[obj:]
+		}
+		if bytes.Contains(dict, []byte("/Image")) {
+			continue
+		}
+		raw := data[start:end]
+		switch {
+		case bytes.Contains(dict, []byte("/FlateDecode")):
+			if decoded := inflate(raw); len(decoded) > 0 {
+				streams = append(streams, decoded)
+			}
+		case !bytes.Contains(dict, []byte("/Filter")):
+			streams = append(streams, raw)
+		}
+	}
+}
+
+// inflate keeps whatever could be decoded from damaged streams
+func inflate(raw []byte) []byte {
+	reader, err := zlib.NewReader(bytes.NewReader(raw))
+	if err != nil {
+		return nil
+	}
+	defer reader.Close()
+	decoded, _ := io.ReadAll(io.LimitReader(reader, maxStreamBytes))
+	return decoded
+}
+
+// parseContentStream interprets the text showing operators found between
+// BT and ET, decoding strings with the fonts of the page by their resource
+// names
+func parseContentStream(stream []byte, fonts map[string]*pdfFont) string {
+	var text strings.Builder
+	var operands []string
+	var array []string
+	var font *pdfFont
+	inText, inArray := false, false
+	lastLine := 0.0
+
+	s := stream
+	for len(s) > 0 {
+		c := s[0]
+		switch {
+		case isPDFSpace(c):
+			s = s[1:]
+		case c == '%':
+			if i := bytes.IndexAny(s, "\r\n"); i >= 0 {
+				s = s[i:]
+			} else {
+				s = nil
+			}
+		case c == '(':
+			raw, rest := readLiteralString(s)
+			str := font.decode(raw)
+			s = rest
+			if inArray {
+				array = append(array, str)
+			} else {
+				operands = append(operands, str)
+			}
+		case c == '<' && len(s) > 1 && s[1] == '<':
+			s = skipDict(s)
+		case c == '<':
+			raw, rest := readHexString(s)
+			str := font.decode(raw)
+			s = rest
+			if inArray {
+				array = append(array, str)
+			} else {
+				operands = append(operands, str)
+			}
+		case c == '[':
+			inArray, array = true, nil
+			s = s[1:]
+		case c == ']':
+			inArray = false
+			s = s[1:]
+		default:
+			word, rest := readWord(s)
+			s = rest
+			if inArray {
+				// large negative kerning usually separates words
+				if n, err := strconv.ParseFloat(word, 64); err == nil && n < -200 {
+					array = append(array, " ")
+				}
+				continue
+			}
+			switch word {
+			case "BT":
+				inText = true
+			case "Tf":
+				if len(operands) >= 2 {
+					font = fonts[strings.TrimPrefix(operands[len(operands)-2], "/")]
+				}
+			case "ET":
+				inText = false
+				text.WriteByte('\n')
+			case "Tj":
+				if inText && len(operands) > 0 {
+					text.WriteString(operands[len(operands)-1])
+				}
+			case "'", "\"":
+				if inText && len(operands) > 0 {
+					text.WriteByte('\n')
+					text.WriteString(operands[len(operands)-1])
+				}
+			case "TJ":
+				if inText {
+					text.WriteString(strings.Join(array, ""))
+				}
+				array = nil
+			case "T*":
+				text.WriteByte('\n')
+			case "Td", "TD":
+				if len(operands) >= 2 {
+					ty, _ := strconv.ParseFloat(operands[len(operands)-1], 64)
+					if ty != 0 {
+						text.WriteByte('\n')
+					} else {
+						text.WriteByte(' ')
+					}
+				}
+			case "Tm":
+				if len(operands) >= 6 {
+					f, _ := strconv.ParseFloat(operands[len(operands)-1], 64)
+					if f != lastLine {
+						text.WriteByte('\n')
+					}
+					lastLine = f
+				}
+			}
+			if isPDFOperator(word) {
+				operands = operands[:0]
+			} else {
+				operands = append(operands, word)
+			}
+		}
+	}
+	return text.String()
+}
+
+func isPDFSpace(c byte) bool {
+	switch c {
+	case ' ', '\t', '\r', '\n', '\f', 0:
+		return true
+	}
+	return false
+}
+
+func isPDFDelimiter(c byte) bool {
+	switch c {
+	case '(', ')', '<', '>', '[', ']', '{', '}', '/', '%':
+		return true
+	}
+	return isPDFSpace(c)
+}
+
+// isPDFOperator reports whether word is an operator rather than a number
+// or a name operand
+func isPDFOperator(word string) bool {
+	if word == "" || word[0] == '/' {
+		return false
+	}
+	if _, err := strconv.ParseFloat(word, 64); err == nil {
+		return false
+	}
+	return true
+}
+
+func readWord(s []byte) (string, []byte) {
+	i := 1
+	for i < len(s) && !isPDFDelimiter(s[i]) {
+		i++
+	}
+	return string(s[:i]), s[i:]
+}
+
+func skipDict(s []byte) []byte {
+	depth := 0
+	for i := 0; i+1 < len(s); i++ {
+		switch {
+		case s[i] == '<' && s[i+1] == '<':
+			depth++
+			i++
+		case s[i] == '>' && s[i+1] == '>':
+			depth--
+			i++
+			if depth == 0 {
+				return s[i+1:]
+			}
+		}
+	}
+	return nil
+}
+
+// readLiteralString reads the bytes of a (string) with its escapes and
+// balanced parentheses
+func readLiteralString(s []byte) ([]byte, []byte) {
+	var out []byte
+	depth := 0
+	i := 0
+	for ; i < len(s); i++ {
+		c := s[i]
+		switch c {
+		case '(':
+			depth++
+			if depth == 1 {
+				continue
+			}
+		case ')':
+			depth--
+			if depth == 0 {
+				return out, s[i+1:]
+			}
+		case '\\':
+			i++
+			if i >= len(s) {
+				return out, nil
+			}
+			switch e := s[i]; e {
+			case 'n':
+				out = append(out, '\n')
+			case 'r':
+				out = append(out, '\r')
+			case 't':
+				out = append(out, '\t')
+			case 'b', 'f':
+			case '\r':
+				if i+1 < len(s) && s[i+1] == '\n' {
+					i++
+				}
+			case '\n':
+			default:
+				if e >= '0' && e <= '7' {
+					n := 0
+					j := 0
+					for ; j < 3 && i+j < len(s) && s[i+j] >= '0' && s[i+j] <= '7'; j++ {
+						n = n*8 + int(s[i+j]-'0')
+					}
+					i += j - 1
+					out = append(out, byte(n))
+				} else {
+					out = append(out, e)
+				}
+			}
+			continue
+		}
+		out = append(out, c)
+	}
+	return out, nil
+}
+
+// readHexString reads the <hex> string s starts with, the rest is nil
+// when s holds no complete hex string
+func readHexString(s []byte) ([]byte, []byte) {
+	if len(s) == 0 || s[0] != '<' {
+		return nil, nil
+	}
+	end := bytes.IndexByte(s, '>')
+	if end < 1 {
+		return nil, nil
+	}
+	var digits []byte
+	for _, c := range s[1:end] {
+		if !isPDFSpace(c) {
+			digits = append(digits, c)
+		}
+	}
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+	out := make([]byte, 0, len(digits)/2)
+	for i := 0; i < len(digits); i += 2 {
+		n, err := strconv.ParseUint(string(digits[i:i+2]), 16, 8)
+		if err != nil {
+			return nil, s[end+1:]
+		}
+		out = append(out, byte(n))
+	}
+	return out, s[end+1:]
+}
+
+// decodePDFString reads UTF-16 strings marked with a byte order mark and
+// two byte codes of ASCII text, anything else as Latin-1
+func decodePDFString(b []byte) string {
+	if len(b) >= 2 && b[0] == 0xfe && b[1] == 0xff {
+		return decodeUTF16(b[2:])
+	}
+	if len(b) >= 2 && len(b)%2 == 0 {
+		wide := true
+		for i := 0; i < len(b); i += 2 {
+			if b[i] != 0 {
+				wide = false
+				break
+			}
+		}
+		if wide {
+			return decodeUTF16(b)
+		}
+	}
+	runes := make([]rune, 0, len(b))
+	for _, c := range b {
+		if c < 0x20 && c != '\n' && c != '\t' {
+			continue
+		}
+		runes = append(runes, rune(c))
+	}
+	return string(runes)
+}
+
+func decodeUTF16(b []byte) string {
+	units := make([]uint16, 0, len(b)/2)
+	for i := 0; i+1 < len(b); i += 2 {
+		units = append(units, uint16(b[i])<<8|uint16(b[i+1]))
+	}
+	return string(utf16.Decode(units))
+}
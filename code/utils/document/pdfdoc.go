@@ -0,0 +1,496 @@
+package document
+
+import (
+	"bytes"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// maxPageDepth bounds the nesting of the page tree, trees of broken or
+// hostile documents may loop
+const maxPageDepth = 32
+
+var (
+	objectHeader = regexp.MustCompile(`(\d+)\s+\d+\s+obj\b`)
+	refPattern   = regexp.MustCompile(`^(\d+)\s+\d+\s+R\b`)
+	rootPattern  = regexp.MustCompile(`/Root\s+(\d+\s+\d+\s+R)`)
+)
+
+// pdfDoc holds the indirect objects of a PDF by number, objects packed in
+// object streams included. Cross-reference tables are not read, the
+// objects are found by scanning the file
+type pdfDoc struct {
+	data    []byte
+	objects map[int][]byte
+	fonts   map[string]*pdfFont // by the reference to the font
+}
+
+type pdfPage struct {
+	content []byte
+	fonts   map[string]*pdfFont // by resource name, such as F1
+}
+
+func parsePDF(data []byte) *pdfDoc {
+	d := &pdfDoc{data: data, objects: map[int][]byte{},
+		fonts: map[string]*pdfFont{}}
+	locs := objectHeader.FindAllSubmatchIndex(data, -1)
+	next := 0
+	for i, loc := range locs {
+		// headers found inside the stream of the previous object are data
+		if loc[0] < next || (loc[0] > 0 && !isPDFDelimiter(data[loc[0]-1])) {
+			continue
+		}
+		end := len(data)
+		if i+1 < len(locs) {
+			end = locs[i+1][0]
+		}
+		// a stream may contain what looks like the next header, the object
+		// ends after it
+		if _, raw := splitObject(data[loc[1]:]); raw != nil {
+			streamEnd := cap(data) - cap(raw) + len(raw)
+			if j := bytes.Index(data[streamEnd:], []byte("endobj")); j >= 0 {
+				end = streamEnd + j
+			}
+			next = end
+		}
+		body := data[loc[1]:end]
+		if j := bytes.LastIndex(body, []byte("endobj")); j >= 0 {
+			body = body[:j]
+		}
+		num, _ := strconv.Atoi(string(data[loc[2]:loc[3]]))
+		d.objects[num] = body
+	}
+	for _, body := range d.objects {
+		d.unpackObjectStream(body)
+	}
+	return d
+}
+
+// unpackObjectStream adds the objects packed in body when it is an object
+// stream, objects defined in the file itself win
+func (d *pdfDoc) unpackObjectStream(body []byte) {
+	dict, raw := splitObject(body)
+	if string(dictGet(dict, "/Type")) != "/ObjStm" {
+		return
+	}
+	stream := decodeStream(dict, raw)
+	n, _ := strconv.Atoi(string(dictGet(dict, "/N")))
+	first, _ := strconv.Atoi(string(dictGet(dict, "/First")))
+	if stream == nil || first <= 0 || first > len(stream) {
+		return
+	}
+	header := strings.Fields(string(stream[:first]))
+	for k := 0; k < n && 2*k+1 < len(header); k++ {
+		num, err1 := strconv.Atoi(header[2*k])
+		start, err2 := strconv.Atoi(header[2*k+1])
+		end := len(stream) - first
+		if 2*k+3 < len(header) {
+			end, _ = strconv.Atoi(header[2*k+3])
+		}
+		if err1 != nil || err2 != nil || start < 0 || start > end ||
+			first+end > len(stream) {
+			return
+		}
+		if _, ok := d.objects[num]; !ok {
+			d.objects[num] = stream[first+start : first+end]
+		}
+	}
+}
+
+// encrypted tells whether a trailer or cross-reference stream names an
+// encryption dictionary
+func (d *pdfDoc) encrypted() bool {
+	for s := d.data; ; {
+		i := bytes.Index(s, []byte("trailer"))
+		if i < 0 {
+			break
+		}
+		s = s[i+len("trailer"):]
+		if trailer, _ := pdfValue(s); dictGet(trailer, "/Encrypt") != nil {
+			return true
+		}
+	}
+	for _, body := range d.objects {
+		dict, _ := splitObject(body)
+		if string(dictGet(dict, "/Type")) == "/XRef" &&
+			dictGet(dict, "/Encrypt") != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// pages walks the page tree from the document catalog, nil when it
+// cannot be found
+func (d *pdfDoc) pages() []pdfPage {
+	roots := rootPattern.FindAllSubmatch(d.data, -1)
+	if len(roots) == 0 {
+		return nil
+	}
+	catalog := d.resolve(roots[len(roots)-1][1])
+	var pages []pdfPage
+	d.walk(dictGet(catalog, "/Pages"), nil, &pages, 0)
+	return pages
+}
+
+func (d *pdfDoc) walk(node, resources []byte, pages *[]pdfPage, depth int) {
+	dict := d.resolve(node)
+	if dict == nil || depth > maxPageDepth {
+		return
+	}
+	// resources are inherited from the parent nodes
+	if r := dictGet(dict, "/Resources"); r != nil {
+		resources = r
+	}
+	if kids := dictGet(dict, "/Kids"); kids != nil {
+		for _, kid := range arrayItems(d.resolve(kids)) {
+			d.walk(kid, resources, pages, depth+1)
+		}
+		return
+	}
+	contents := dictGet(dict, "/Contents")
+	streams := [][]byte{contents}
+	if array := d.resolve(contents); bytes.HasPrefix(array, []byte("[")) {
+		streams = arrayItems(array)
+	}
+	var content []byte
+	for _, ref := range streams {
+		if stream := d.stream(ref); stream != nil {
+			content = append(append(content, stream...), '\n')
+		}
+	}
+	*pages = append(*pages, pdfPage{content: content,
+		fonts: d.pageFonts(resources)})
+}
+
+func (d *pdfDoc) pageFonts(resources []byte) map[string]*pdfFont {
+	fonts := map[string]*pdfFont{}
+	dictEach(d.resolve(dictGet(d.resolve(resources), "/Font")),
+		func(name string, value []byte) {
+			fonts[strings.TrimPrefix(name, "/")] = d.font(value)
+		})
+	return fonts
+}
+
+func (d *pdfDoc) font(ref []byte) *pdfFont {
+	if f, ok := d.fonts[string(ref)]; ok {
+		return f
+	}
+	dict := d.resolve(ref)
+	f := &pdfFont{wide: string(dictGet(dict, "/Subtype")) == "/Type0"}
+	if cmap := d.stream(dictGet(dict, "/ToUnicode")); cmap != nil {
+		f.cmap = parseCMap(cmap)
+	}
+	d.fonts[string(ref)] = f
+	return f
+}
+
+// resolve returns the object value refers to, value itself when it is
+// not a reference
+func (d *pdfDoc) resolve(value []byte) []byte {
+	m := refPattern.FindSubmatch(value)
+	if m == nil {
+		return value
+	}
+	num, _ := strconv.Atoi(string(m[1]))
+	body, ok := d.objects[num]
+	if !ok {
+		return nil
+	}
+	dict, _ := splitObject(body)
+	return dict
+}
+
+// stream returns the decoded stream of the object ref refers to
+func (d *pdfDoc) stream(ref []byte) []byte {
+	m := refPattern.FindSubmatch(ref)
+	if m == nil {
+		return nil
+	}
+	num, _ := strconv.Atoi(string(m[1]))
+	return decodeStream(splitObject(d.objects[num]))
+}
+
+// splitObject returns the value of an object body and its raw stream data,
+// nil when it has none
+func splitObject(body []byte) (value, raw []byte) {
+	value, rest := pdfValue(body)
+	if !bytes.HasPrefix(value, []byte("<<")) {
+		return value, nil
+	}
+	rest = bytes.TrimLeft(rest, " \t\r\n\f")
+	if !bytes.HasPrefix(rest, []byte("stream")) {
+		return value, nil
+	}
+	rest = rest[len("stream"):]
+	if len(rest) > 0 && rest[0] == '\r' {
+		rest = rest[1:]
+	}
+	if len(rest) > 0 && rest[0] == '\n' {
+		rest = rest[1:]
+	}
+	if n, err := strconv.Atoi(string(dictGet(value, "/Length"))); err == nil &&
+		n >= 0 && n <= len(rest) {
+		return value, rest[:n]
+	}
+	if end := bytes.Index(rest, []byte("endstream")); end >= 0 {
+		return value, rest[:end]
+	}
+	return value, rest
+}
+
+// decodeStream decodes uncompressed and Flate streams, nil for other
+// filters and images
+func decodeStream(dict, raw []byte) []byte {
+	switch {
+	case raw == nil || bytes.Contains(dict, []byte("/Image")):
+		return nil
+	case bytes.Contains(dict, []byte("/FlateDecode")):
+		return inflate(raw)
+	case dictGet(dict, "/Filter") == nil:
+		return raw
+	}
+	return nil
+}
+
+// pdfValue splits the value starting s, a dictionary, array, string,
+// reference, name or number, from what follows it
+func pdfValue(s []byte) (value, rest []byte) {
+	s = bytes.TrimLeft(s, " \t\r\n\f\x00")
+	if len(s) == 0 {
+		return nil, nil
+	}
+	switch {
+	case bytes.HasPrefix(s, []byte("<<")):
+		rest = skipDict(s)
+		if rest == nil {
+			return s, nil
+		}
+	case s[0] == '[':
+		depth := 0
+		for i := 0; i < len(s); i++ {
+			switch s[i] {
+			case '(':
+				_, r := readLiteralString(s[i:])
+				i = len(s) - len(r) - 1
+			case '[':
+				depth++
+			case ']':
+				if depth--; depth == 0 {
+					return s[:i+1], s[i+1:]
+				}
+			}
+		}
+		return s, nil
+	case s[0] == '(':
+		_, rest = readLiteralString(s)
+	case s[0] == '<':
+		_, rest = readHexString(s)
+	default:
+		if loc := refPattern.FindIndex(s); loc != nil {
+			return s[:loc[1]], s[loc[1]:]
+		}
+		_, rest = readWord(s)
+	}
+	return s[:len(s)-len(rest)], rest
+}
+
+// dictEach calls fn with the entries of dict, nested values are not
+// entered
+func dictEach(dict []byte, fn func(key string, value []byte)) {
+	s := bytes.TrimLeft(dict, " \t\r\n\f")
+	if !bytes.HasPrefix(s, []byte("<<")) {
+		return
+	}
+	s = s[2:]
+	for {
+		s = bytes.TrimLeft(s, " \t\r\n\f\x00")
+		if len(s) == 0 || bytes.HasPrefix(s, []byte(">>")) {
+			return
+		}
+		key, rest := pdfValue(s)
+		value, rest := pdfValue(rest)
+		fn(string(key), value)
+		s = rest
+	}
+}
+
+// dictGet returns the value of key in dict, nil when it is missing
+func dictGet(dict []byte, key string) []byte {
+	var found []byte
+	dictEach(dict, func(k string, value []byte) {
+		if k == key && found == nil {
+			found = value
+		}
+	})
+	return found
+}
+
+func arrayItems(array []byte) [][]byte {
+	s := bytes.TrimLeft(array, " \t\r\n\f")
+	if !bytes.HasPrefix(s, []byte("[")) {
+		return nil
+	}
+	s = bytes.TrimSuffix(bytes.TrimRight(s[1:], " \t\r\n\f"), []byte("]"))
+	var items [][]byte
+	for {
+		item, rest := pdfValue(s)
+		if item == nil {
+			return items
+		}
+		items = append(items, item)
+		s = rest
+	}
+}
+
+// pdfFont decodes the strings shown with a font
+type pdfFont struct {
+	wide bool       // a Type0 font, usually of two byte glyph ids
+	cmap *toUnicode // nil when the font has no ToUnicode map
+}
+
+// decode returns the text of raw shown with f. Simple fonts without a map
+// are read as Latin-1, two byte fonts without one cannot be read
+func (f *pdfFont) decode(raw []byte) string {
+	if f == nil || (f.cmap == nil && !f.wide) {
+		return decodePDFString(raw)
+	}
+	if f.cmap == nil {
+		return ""
+	}
+	n := f.cmap.codeLength
+	var text strings.Builder
+	for i := 0; i+n <= len(raw); i += n {
+		code := 0
+		for _, b := range raw[i : i+n] {
+			code = code<<8 | int(b)
+		}
+		if s, ok := f.cmap.codes[code]; ok {
+			text.WriteString(s)
+		} else if n == 1 && raw[i] >= 0x20 {
+			text.WriteRune(rune(raw[i]))
+		}
+	}
+	return text.String()
+}
+
+// toUnicode maps character codes to text, as read from a ToUnicode CMap
+type toUnicode struct {
+	codeLength int
+	codes      map[int]string
+}
+
+// maxRangeCodes bounds the codes a single bfrange may map
+const maxRangeCodes = 1 << 16
+
+func parseCMap(data []byte) *toUnicode {
+	m := &toUnicode{codeLength: 1, codes: map[int]string{}}
+	tokens := cmapTokens(data)
+	for i := 0; i < len(tokens); i++ {
+		switch tokens[i].word {
+		case "begincodespacerange":
+			if i+1 < len(tokens) && tokens[i+1].hex != nil {
+				m.codeLength = len(tokens[i+1].hex)
+			}
+		case "beginbfchar":
+			for i++; i+1 < len(tokens) && tokens[i].hex != nil; i += 2 {
+				m.codes[codeOf(tokens[i].hex)] = decodeUTF16(tokens[i+1].hex)
+			}
+		case "beginbfrange":
+			for i++; i+2 < len(tokens) && tokens[i].hex != nil; i += 3 {
+				lo, hi := codeOf(tokens[i].hex), codeOf(tokens[i+1].hex)
+				if hi < lo || hi-lo >= maxRangeCodes {
+					continue
+				}
+				if dst := tokens[i+2]; dst.hex != nil {
+					for code := lo; code <= hi; code++ {
+						m.codes[code] = decodeUTF16(offsetCode(dst.hex, code-lo))
+					}
+				} else {
+					for k, item := range dst.array {
+						if lo+k <= hi {
+							m.codes[lo+k] = decodeUTF16(item)
+						}
+					}
+				}
+			}
+		}
+	}
+	if m.codeLength < 1 || m.codeLength > 4 {
+		m.codeLength = 1
+	}
+	return m
+}
+
+type cmapToken struct {
+	word  string
+	hex   []byte   // a <hex> string
+	array [][]byte // an array of hex strings
+}
+
+func cmapTokens(data []byte) []cmapToken {
+	var tokens []cmapToken
+	for s := data; len(s) > 0; {
+		switch c := s[0]; {
+		case isPDFSpace(c):
+			s = s[1:]
+		case c == '%':
+			if i := bytes.IndexAny(s, "\r\n"); i >= 0 {
+				s = s[i:]
+			} else {
+				s = nil
+			}
+		case c == '<' && len(s) > 1 && s[1] == '<':
+			s = skipDict(s)
+		case c == '<':
+			hex, rest := readHexString(s)
+			tokens = append(tokens, cmapToken{hex: nonNil(hex)})
+			s = rest
+		case c == '[':
+			value, rest := pdfValue(s)
+			var array [][]byte
+			for _, item := range arrayItems(value) {
+				if hex, _ := readHexString(item); hex != nil {
+					array = append(array, hex)
+				}
+			}
+			tokens = append(tokens, cmapToken{array: array})
+			s = rest
+		case c == '(':
+			_, s = readLiteralString(s)
+		default:
+			word, rest := readWord(s)
+			tokens = append(tokens, cmapToken{word: word})
+			s = rest
+		}
+	}
+	return tokens
+}
+
+func nonNil(b []byte) []byte {
+	if b == nil {
+		return []byte{}
+	}
+	return b
+}
+
+func codeOf(b []byte) int {
+	code := 0
+	for _, c := range b {
+		code = code<<8 | int(c)
+	}
+	return code
+}
+
+// offsetCode adds offset to the big endian dst, as the codes of a bfrange
+// advance their destination
+func offsetCode(dst []byte, offset int) []byte {
+	out := append([]byte{}, dst...)
+	for i := len(out) - 1; i >= 0 && offset > 0; i-- {
+		sum := int(out[i]) + offset
+		out[i] = byte(sum)
+		offset = sum >> 8
+	}
+	return out
+}